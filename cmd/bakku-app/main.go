@@ -4,30 +4,46 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/glower/bakku-app/pkg/auth"
 	"github.com/glower/bakku-app/pkg/backup"
 	"github.com/glower/bakku-app/pkg/event"
 	"github.com/glower/bakku-app/pkg/message"
+	"github.com/glower/bakku-app/pkg/ratelimit"
+	"github.com/glower/bakku-app/pkg/scheduler"
 	"github.com/glower/bakku-app/pkg/snapshot"
 	"github.com/glower/bakku-app/pkg/storage"
+	"github.com/glower/bakku-app/pkg/storage/record"
 	"github.com/glower/bakku-app/pkg/types"
 
 	// autoimport
 	_ "github.com/glower/bakku-app/pkg"
 	"github.com/glower/bakku-app/pkg/config"
+	"github.com/glower/bakku-app/pkg/config/manager"
 	"github.com/glower/bakku-app/pkg/handlers"
 
 	"github.com/glower/file-watcher/watcher"
 )
 
+const (
+	// drainTimeout is how long running uploads can take on shutdown before they are canceled
+	drainTimeout = 30 * time.Second
+	// serverShutdownTimeout is how long open requests can take on shutdown
+	serverShutdownTimeout = 5 * time.Second
+)
+
 func init() {
-	config.ReadDefaultConfig()
+	if err := config.ReadDefaultConfig(); err != nil {
+		log.Fatalf("[ERROR] Can't read the config: %v\n", err)
+	}
 }
 
 func main() {
@@ -37,14 +53,22 @@ func main() {
 	fileWatcher := watcher.Setup(ctx,
 		&watcher.Options{
 			IgnoreDirectoies: true,
-			FileFilters:      []string{".crdownload", ".lock", ".snapshot", ".snapshot.lock"},
+			FileFilters:      config.FileFilters(),
 		})
 
+	db, err := storage.New(config.GetStoragePath())
+	if err != nil {
+		log.Fatalf("[ERROR] Can't open the snapshot storage: %v\n", err)
+	}
+	if err := record.Migrate(db); err != nil {
+		log.Fatalf("[ERROR] Can't migrate the snapshot storage: %v\n", err)
+	}
+
 	res := &types.GlobalResources{
 		BackupCompleteCh: make(chan types.BackupComplete),
 		MessageCh:        make(chan message.Message),
 		FileWatcher:      fileWatcher,
-		Storage:          storage.New(config.GetStoragePath()),
+		Storage:          db,
 	}
 
 	snapShotManager := snapshot.Setup(ctx, res)
@@ -57,15 +81,24 @@ func main() {
 	fmt.Printf("Dirs to watch: %v\n", dirs)
 	for _, d := range dirs.DirsToWatch {
 		if d.Active {
-			go fileWatcher.StartWatching(d.Path)
-			go snapShotManager.CreateOrUpdate(d.Path)
+			if err := config.DirAvailable(d.Path); err != nil {
+				log.Printf("[WARNING] directory [%s] is not available and not watched: %v\n", d.Path, err)
+				continue
+			}
+			// scan-only directories are not watched, they are scanned by the scheduler
+			if !d.ScanOnly {
+				go fileWatcher.StartWatching(d.Path)
+			}
+			if _, err := snapShotManager.Scan(d.Path); err != nil {
+				log.Printf("[ERROR] can't scan [%s]: %v\n", d.Path, err)
+			}
 		}
 	}
 
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
-	router := startHTTPServer(res)
+	ratelimit.Setup(ctx)
 
 	eventBuffer := event.NewBuffer(ctx, res)
 	fmt.Println("event buffer is up and running ...")
@@ -73,6 +106,22 @@ func main() {
 	backupStorageManager := backup.Setup(ctx, res, eventBuffer)
 	fmt.Println("backup storage manager is up and running ...")
 
+	backupScheduler := scheduler.Setup(ctx, snapShotManager, backupStorageManager)
+	fmt.Println("scheduler is up and running ...")
+
+	configManager, err := manager.Setup(ctx, res, snapShotManager, backupStorageManager, backupScheduler)
+	if err != nil {
+		panic(err)
+	}
+
+	srv, router := startHTTPServer(&handlers.Resources{
+		FileWatcher: res.FileWatcher,
+		Snapshot:    snapShotManager,
+		Config:      configManager,
+		Backup:      backupStorageManager,
+		Storage:     res.Storage,
+	})
+
 	sseServer := event.NewSSE(ctx, router, backupStorageManager.FileBackupProgressCh, res, eventBuffer)
 	fmt.Println("SSE server is up and running ...")
 
@@ -86,38 +135,74 @@ func main() {
 	}
 
 	log.Print("The service is shutting down...")
-	cancel()
+	go func() {
+		<-interrupt
+		log.Print("Got a second signal, exit immediately")
+		os.Exit(2)
+	}()
+
+	exitCode := 0
+	// the uploads need the event buffer and the SSE server, ctx is canceled after they are done
+	if err := backupStorageManager.Shutdown(drainTimeout); err != nil {
+		log.Printf("[ERROR] Unclean shutdown of the backup storages: %v", err)
+		exitCode = 1
+	}
+	// SSE connections never end by themselves, they have to be closed before the server can shut down
 	sseServer.StopSSE()
-	backup.Stop()
 	log.Println("Shutdown the web server ...")
-	// TODO: Shutdown is not working with open SSE connection, need to solve this first
-	// srv.Shutdown(context.Background())
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[ERROR] Unclean shutdown of the web server: %v", err)
+		exitCode = 1
+	}
+	cancel()
+	// the uploads are done, nothing writes to the snapshot anymore
+	if err := db.Close(); err != nil {
+		log.Printf("[ERROR] Can't close the snapshot storage: %v", err)
+		exitCode = 1
+	}
+	log.Printf("The service is stopped")
+	os.Exit(exitCode)
 }
 
-func startHTTPServer(res *types.GlobalResources) *mux.Router {
-	port := os.Getenv("BAKKU_PORT")
-	if port == "" {
-		log.Println("Port is not set, using default port 8080")
-		port = "8080"
-	}
+func startHTTPServer(r *handlers.Resources) (*http.Server, *mux.Router) {
+	settings := config.Current().Server
 
-	r := handlers.Resources{
-		FileWatcher: res.FileWatcher,
-	}
 	router := r.Router()
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: router,
+		Addr:    net.JoinHostPort(settings.Bind, settings.Port),
+		Handler: handlers.Secure(router),
 	}
 
-	go func() {
-		err := srv.ListenAndServe()
+	if settings.Auth.Enabled {
+		if err := auth.Init(handlers.Tokens()); err != nil {
+			log.Fatalf("[ERROR] Can't create the first API token: %v", err)
+		}
+	} else {
+		log.Print("[WARNING] Authentication of the API is disabled")
+	}
+	if settings.TLS.Enabled {
+		tlsConfig, err := auth.ServerTLSConfig(settings.TLS, []string{settings.Bind, "localhost", "127.0.0.1", "::1"})
 		if err != nil {
+			log.Fatalf("[ERROR] Can't setup TLS: %v", err)
+		}
+		srv.TLSConfig = tlsConfig
+	}
+
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Printf("[ERROR] Failed to run server: %v", err)
 		}
 	}()
-	log.Print("[OK] The service is ready to listen and serve!")
-	return router
+	log.Printf("[OK] The service is ready to listen and serve on [%s]!", srv.Addr)
+	return srv, router
 }
 
 func initStorage() {
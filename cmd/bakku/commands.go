@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/catalog"
+	"github.com/glower/bakku-app/pkg/client"
+	"github.com/glower/bakku-app/pkg/config"
+	"github.com/glower/bakku-app/pkg/snapshot"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+// CLI runs the commands against the service or, if it's not running, against the local files
+type CLI struct {
+	out     io.Writer
+	client  *client.Client
+	offline *offline
+}
+
+type command struct {
+	args int // minimal number of arguments
+	run  func(c *CLI, args []string) error
+}
+
+var commands = map[string]command{
+	"status":   {0, (*CLI).status},
+	"queue":    {0, (*CLI).queue},
+	"ls":       {1, (*CLI).ls},
+	"versions": {1, (*CLI).versions},
+	"restore":  {2, (*CLI).restore},
+	"verify":   {1, (*CLI).verify},
+	"rescan":   {1, (*CLI).rescan},
+	"pause":    {1, (*CLI).pause},
+	"resume":   {1, (*CLI).resume},
+	"drain":    {1, (*CLI).drain},
+	"cancel":   {2, (*CLI).cancel},
+	"bump":     {2, (*CLI).bump},
+	"config":   {1, (*CLI).config},
+	"history":  {0, (*CLI).history},
+	"scrub":    {0, (*CLI).scrub},
+	"reindex":  {1, (*CLI).reindex},
+	"export":   {0, (*CLI).export},
+	"import":   {1, (*CLI).importSnapshot},
+}
+
+// Run executes a command with its arguments
+func (c *CLI) Run(args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command [%s], see bakku -h", args[0])
+	}
+	if len(args)-1 < cmd.args {
+		return fmt.Errorf("%s: missing arguments, see bakku -h", args[0])
+	}
+	return cmd.run(c, args[1:])
+}
+
+func (c *CLI) online() bool {
+	return c.client.Ping() == nil
+}
+
+// requireService returns an error if the command can't be executed in the offline mode
+func (c *CLI) requireService(cmd string) error {
+	if c.offline != nil {
+		return fmt.Errorf("%s needs the running service", cmd)
+	}
+	return nil
+}
+
+func (c *CLI) status(args []string) error {
+	var storages []types.StorageStatus
+	var dirs []types.DirStatus
+	var scans []snapshot.ScanStatus
+	var err error
+	if c.offline != nil {
+		storages, err = c.offline.status()
+	} else {
+		if storages, err = c.client.Status(); err == nil {
+			if dirs, err = c.client.DirStatus(); err == nil {
+				scans, err = c.client.Scans()
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STORAGE\tACTIVE\tPAUSED\tTHROTTLED\tUPLOADING\tPENDING\tHELD\tFAILED\tLEFT\tETA\tLAST BACKUP\tLAST ERROR")
+	for _, s := range storages {
+		fmt.Fprintf(w, "%s\t%v\t%v\t%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n", s.Name, s.Active, s.Paused, s.Throttled,
+			s.InProgress, s.Pending, s.Held, s.Failed, formatBytes(s.BytesPending), formatETA(s.ETA), formatTime(s.LastBackup), s.LastError)
+	}
+	if len(dirs) > 0 {
+		fmt.Fprintln(w, "\nDIRECTORY\tACTIVE\tSCAN\tUPLOADING\tPENDING\tFAILED\tLEFT\tETA\tLAST BACKUP\tLAST ERROR")
+		for _, d := range dirs {
+			fmt.Fprintf(w, "%s\t%v\t%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n", d.Path, d.Active, d.Scan,
+				d.InProgress, d.Pending, d.Failed, formatBytes(d.BytesPending), formatETA(d.ETA), formatTime(d.LastBackup), d.LastError)
+		}
+	}
+	if len(scans) > 0 {
+		fmt.Fprintln(w, "\nSCAN\tSTATE\tSEEN\tCHANGED\tERRORS")
+		for _, s := range scans {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", s.Path, s.State, s.Seen, s.Changed, s.Errors)
+		}
+	}
+	return w.Flush()
+}
+
+func formatBytes(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return config.FormatSize(n)
+}
+
+func formatETA(seconds int64) string {
+	if seconds == 0 {
+		return "-"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func (c *CLI) queue(args []string) error {
+	if err := c.requireService("queue"); err != nil {
+		return err
+	}
+	files, err := c.client.Queue()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STORAGE\tSTATE\tSINCE\tFILE")
+	for _, f := range files {
+		since := ""
+		if !f.Started.IsZero() {
+			since = time.Since(f.Started).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Storage, f.State, since, f.Path)
+	}
+	return w.Flush()
+}
+
+func (c *CLI) ls(args []string) error {
+	prefix := ""
+	if len(args) > 1 {
+		prefix = args[1]
+	}
+	var entries []catalog.Entry
+	var err error
+	if c.offline != nil {
+		entries, err = c.offline.list(args[0], prefix)
+	} else {
+		entries, err = c.client.List(args[0], prefix)
+	}
+	if err != nil {
+		return err
+	}
+	return c.printEntries(entries, false)
+}
+
+func (c *CLI) versions(args []string) error {
+	var entries []catalog.Entry
+	var err error
+	if c.offline != nil {
+		entries, err = c.offline.versions(args[0])
+	} else {
+		entries, err = c.client.Versions(args[0])
+	}
+	if err != nil {
+		return err
+	}
+	return c.printEntries(entries, true)
+}
+
+// printEntries prints the backuped files, withStorage adds the storage and the former versions
+func (c *CLI) printEntries(entries []catalog.Entry, withStorage bool) error {
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	if withStorage {
+		fmt.Fprint(w, "STORAGE\tSTORED\t")
+	}
+	fmt.Fprintln(w, "SIZE\tCHECKSUM\tFILE")
+	for _, e := range entries {
+		if withStorage {
+			fmt.Fprintf(w, "%s\t%s\t", e.Storage, formatTime(&e.Timestamp))
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", e.Size, e.Checksum, e.AbsolutePath)
+		if !withStorage {
+			continue
+		}
+		for i := range e.Versions {
+			v := &e.Versions[i]
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", e.Storage, formatTime(&v.Stored), v.Size, v.Checksum.Value, e.AbsolutePath)
+		}
+	}
+	return w.Flush()
+}
+
+func (c *CLI) restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	flags.SetOutput(c.out)
+	overwrite := flags.Bool("overwrite", false, "replace an existing file, e.g. the file at the original location")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return fmt.Errorf("restore: missing storage or file")
+	}
+	to := flags.Arg(2)
+	var restored string
+	var err error
+	if c.offline != nil {
+		restored, err = c.offline.restore(flags.Arg(0), flags.Arg(1), to, *overwrite)
+	} else {
+		restored, err = c.client.Restore(flags.Arg(0), flags.Arg(1), to, *overwrite)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "restored %s\n", restored)
+	return nil
+}
+
+func (c *CLI) verify(args []string) error {
+	prefix := ""
+	if len(args) > 1 {
+		prefix = args[1]
+	}
+	var results []backup.VerifyResult
+	var err error
+	if c.offline != nil {
+		results, err = c.offline.verify(args[0], prefix)
+	} else {
+		results, err = c.client.Verify(args[0], prefix)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		if !r.OK {
+			failed++
+			fmt.Fprintf(c.out, "FAILED %s: %s\n", r.Path, r.Error)
+		}
+	}
+	fmt.Fprintf(c.out, "%d files verified, %d failed\n", len(results), failed)
+	if failed > 0 {
+		return fmt.Errorf("verification failed")
+	}
+	return nil
+}
+
+func (c *CLI) rescan(args []string) error {
+	if err := c.requireService("rescan"); err != nil {
+		return err
+	}
+	status, err := c.client.Rescan(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "scan of %s is %s\n", status.Path, status.State)
+	return nil
+}
+
+func (c *CLI) pause(args []string) error {
+	if err := c.requireService("pause"); err != nil {
+		return err
+	}
+	return c.client.Pause(args[0])
+}
+
+func (c *CLI) resume(args []string) error {
+	if err := c.requireService("resume"); err != nil {
+		return err
+	}
+	return c.client.Resume(args[0])
+}
+
+func (c *CLI) drain(args []string) error {
+	if err := c.requireService("drain"); err != nil {
+		return err
+	}
+	return c.client.Drain(args[0])
+}
+
+func (c *CLI) cancel(args []string) error {
+	if err := c.requireService("cancel"); err != nil {
+		return err
+	}
+	return c.client.Cancel(args[0], args[1])
+}
+
+func (c *CLI) bump(args []string) error {
+	if err := c.requireService("bump"); err != nil {
+		return err
+	}
+	return c.client.Prioritize(args[0], args[1])
+}
+
+func (c *CLI) config(args []string) error {
+	switch args[0] {
+	case "get":
+		conf, err := c.loadConfig()
+		if err != nil {
+			return err
+		}
+		var value interface{} = conf
+		if len(args) > 1 {
+			if value, err = getKey(conf, args[1]); err != nil {
+				return err
+			}
+		}
+		return c.printJSON(value)
+	case "set":
+		if len(args) < 3 {
+			return fmt.Errorf("config set: missing arguments, see bakku -h")
+		}
+		if err := c.requireService("config set"); err != nil {
+			return err
+		}
+		conf, err := c.client.Config()
+		if err != nil {
+			return err
+		}
+		if err := setKey(conf, args[1], args[2]); err != nil {
+			return err
+		}
+		return c.client.UpdateConfig(conf)
+	}
+	return fmt.Errorf("unknown config command [%s], use get or set", args[0])
+}
+
+func (c *CLI) loadConfig() (map[string]interface{}, error) {
+	if c.offline != nil {
+		return c.offline.config()
+	}
+	return c.client.Config()
+}
+
+func (c *CLI) printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, string(data))
+	return nil
+}
+
+// getKey returns the value of a key like storage.local.path or dirsToWatch.0.path
+func getKey(conf interface{}, key string) (interface{}, error) {
+	value := conf
+	for _, part := range strings.Split(key, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[part]; !ok {
+				return nil, fmt.Errorf("unknown key [%s]", key)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("invalid index [%s] in key [%s]", part, key)
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("unknown key [%s]", key)
+		}
+	}
+	return value, nil
+}
+
+// setKey changes the value of a key, missing objects are created. The value keeps the type
+// of the current value, a new value is parsed as JSON and used as a string if that fails.
+func setKey(conf map[string]interface{}, key, value string) error {
+	parts := strings.Split(key, ".")
+	parentKey := strings.Join(parts[:len(parts)-1], ".")
+	last := parts[len(parts)-1]
+
+	var parent interface{} = conf
+	if parentKey != "" {
+		var err error
+		if parent, err = getKey(conf, parentKey); err != nil {
+			// create the missing objects on the way
+			m := conf
+			for _, part := range parts[:len(parts)-1] {
+				next, ok := m[part].(map[string]interface{})
+				if !ok {
+					next = make(map[string]interface{})
+					m[part] = next
+				}
+				m = next
+			}
+			parent = m
+		}
+	}
+
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = parseValue(p[last], value)
+	case []interface{}:
+		i, err := strconv.Atoi(last)
+		if err != nil || i < 0 || i >= len(p) {
+			return fmt.Errorf("invalid index [%s] in key [%s]", last, key)
+		}
+		p[i] = parseValue(p[i], value)
+	default:
+		return fmt.Errorf("can't set [%s], [%s] is not an object", key, parentKey)
+	}
+	return nil
+}
+
+func parseValue(current interface{}, value string) interface{} {
+	if _, ok := current.(string); ok {
+		return value
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return value
+	}
+	return v
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func testConfig() map[string]interface{} {
+	conf := make(map[string]interface{})
+	json.Unmarshal([]byte(`{
+		"dirsToWatch": [{"path": "/home/john", "active": true}],
+		"storage": {"local": {"active": false, "path": "/backup"}},
+		"server": {"port": "8080"}
+	}`), &conf)
+	return conf
+}
+
+func TestSetKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+		want  interface{}
+	}{
+		{
+			name:  "Scenario 1: bool value is parsed",
+			key:   "storage.local.active",
+			value: "true",
+			want:  true,
+		},
+		{
+			name:  "Scenario 2: string value keeps its type",
+			key:   "server.port",
+			value: "9090",
+			want:  "9090",
+		},
+		{
+			name:  "Scenario 3: value in an array",
+			key:   "dirsToWatch.0.path",
+			value: "/home/jane",
+			want:  "/home/jane",
+		},
+		{
+			name:  "Scenario 4: missing objects are created",
+			key:   "storage.gdrive.active",
+			value: "true",
+			want:  true,
+		},
+		{
+			name:  "Scenario 5: new list value is parsed as JSON",
+			key:   "filters",
+			value: `[".tmp"]`,
+			want:  []interface{}{".tmp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := testConfig()
+			if err := setKey(conf, tt.key, tt.value); err != nil {
+				t.Fatalf("setKey() error = %v", err)
+			}
+			got, err := getKey(conf, tt.key)
+			if err != nil {
+				t.Fatalf("getKey() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getKey() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetKey_Errors(t *testing.T) {
+	conf := testConfig()
+	for _, key := range []string{"storage.s3", "dirsToWatch.1.path", "server.port.number"} {
+		if _, err := getKey(conf, key); err == nil {
+			t.Errorf("getKey(%q) expected an error", key)
+		}
+	}
+}
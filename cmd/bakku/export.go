@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/storage/record"
+)
+
+// export writes the snapshot records to a file or stdout, e.g. to move them to another machine
+func (c *CLI) export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(c.out)
+	format := flags.String("format", "", "jsonl or csv, default is the extension of the output file or jsonl")
+	storages := flags.String("storage", "", "only records of these storages, comma separated")
+	output := flags.String("o", "", "output file, default is stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	filter := snapshotFilter(*storages, flags.Arg(0))
+	f := formatOf(*format, *output)
+
+	w := c.out
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if c.offline != nil {
+		return c.offline.export(w, f, filter)
+	}
+	return c.client.Export(w, f, filter)
+}
+
+// import reads exported snapshot records, - reads from stdin
+func (c *CLI) importSnapshot(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(c.out)
+	format := flags.String("format", "", "jsonl or csv, default is the extension of the file or jsonl")
+	storages := flags.String("storage", "", "only records of these storages, comma separated")
+	prefix := flags.String("path", "", "only records with this path prefix")
+	overwrite := flags.Bool("overwrite", false, "replace existing records")
+	dryRun := flags.Bool("dry-run", false, "only print what would be changed")
+	mapping := flags.String("map", "", "replace a path prefix, like /home/old=/home/new")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("import: missing file")
+	}
+	opt := record.ImportOptions{
+		Filter:    snapshotFilter(*storages, *prefix),
+		Overwrite: *overwrite,
+		DryRun:    *dryRun,
+	}
+	if *mapping != "" {
+		parts := strings.SplitN(*mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("import: invalid -map [%s], expected from=to", *mapping)
+		}
+		opt.From, opt.To = parts[0], parts[1]
+	}
+
+	name := flags.Arg(0)
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	f := formatOf(*format, name)
+
+	var report *record.ImportReport
+	var err error
+	if c.offline != nil {
+		report, err = c.offline.importSnapshot(r, f, opt)
+	} else {
+		report, err = c.client.Import(r, f, opt)
+	}
+	if err != nil {
+		return err
+	}
+	prefixText := ""
+	if report.DryRun {
+		prefixText = "dry run: "
+	}
+	fmt.Fprintf(c.out, "%s%d records: %d added, %d updated, %d kept, %d skipped\n",
+		prefixText, report.Records, report.Added, report.Updated, report.Existing, report.Skipped)
+	return nil
+}
+
+func snapshotFilter(storages, prefix string) record.Filter {
+	f := record.Filter{Prefix: prefix}
+	if storages != "" {
+		for _, name := range strings.Split(storages, ",") {
+			f.Storages = append(f.Storages, backup.StorageName(name))
+		}
+	}
+	return f
+}
+
+// formatOf returns the given format or the format of the file extension
+func formatOf(format, file string) string {
+	if format != "" {
+		return format
+	}
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		return record.FormatCSV
+	}
+	return record.FormatJSONL
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/glower/bakku-app/pkg/history"
+)
+
+// history prints the logged uploads, deletions and restores, the newest first
+func (c *CLI) history(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.SetOutput(c.out)
+	storage := flags.String("storage", "", "only records of this storage")
+	status := flags.String("status", "", "only records with this result: ok, failed, canceled or skipped")
+	action := flags.String("action", "", "only records of this action: store, delete or restore")
+	since := flags.Duration("since", 0, "only records of the last duration like 24h")
+	limit := flags.Int("n", 20, "number of records")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	f := history.Filter{
+		Prefix:  flags.Arg(0),
+		Storage: *storage,
+		Action:  *action,
+		Result:  *status,
+		Limit:   *limit,
+	}
+	if *since > 0 {
+		f.Since = time.Now().Add(-*since)
+	}
+
+	var page *history.Page
+	var err error
+	if c.offline != nil {
+		page, err = c.offline.history(f)
+	} else {
+		page, err = c.client.History(f)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tSTORAGE\tRESULT\tDURATION\tFILE\tERROR")
+	for _, r := range page.Records {
+		d := time.Duration(r.Duration) * time.Millisecond
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Time.Format("2006-01-02 15:04:05"), r.Action, r.Storage, r.Result, d, r.File, r.Error)
+	}
+	if page.Total > len(page.Records) {
+		fmt.Fprintf(w, "\n%d of %d records\n", len(page.Records), page.Total)
+	}
+	return w.Flush()
+}
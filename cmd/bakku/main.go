@@ -0,0 +1,145 @@
+// bakku is the command line client of the bakku-app service. It talks to the REST API of the
+// running service, when the service is not running it reads the snapshot database and the
+// local storage directly.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/glower/bakku-app/pkg/auth"
+	"github.com/glower/bakku-app/pkg/client"
+	"github.com/glower/bakku-app/pkg/config"
+)
+
+const usage = `Usage: bakku [flags] <command> [arguments]
+
+Commands:
+  status                          state of the storages and scans
+  queue                           files which are uploaded or held
+  ls <storage> [path]             backuped files, path is a prefix
+  versions <file>                 backuped version of a file in every storage
+  restore [-overwrite] <storage> <file> [to]
+                                  restore a file, default is the original location,
+                                  an existing file is only replaced with -overwrite
+  verify <storage> [path]         compare backuped files with the snapshot checksums
+  scrub [-repair] [storage]       compare a storage with the snapshot, without storage print the last reports
+  reindex [-dry-run] [-overwrite] <storage>
+                                  rebuild the snapshot from the files in a storage
+  export [flags] [path]           write the snapshot as JSON lines or CSV, see bakku export -h
+  import [flags] <file>           add exported snapshot records, see bakku import -h
+  rescan <dir>                    scan a watched directory for changes
+  pause <storage>                 hold all uploads of a storage
+  resume <storage>                upload the held files of a paused storage
+  drain <storage>                 finish the running uploads of a storage, then pause it
+  cancel <storage> <file>         stop the upload of a file until it changes again
+  bump <storage> <file>           upload a held file now, even if the storage is paused
+  history [flags] [path]          uploads, deletions and restores, see bakku history -h
+  config get [key]                print the config or one key like storage.local.path
+  config set <key> <value>        change one key of the config
+  token create [-save] <name>     create an API token, -save stores it for this client
+  token ls                        list all API tokens
+  token rm <name>                 remove an API token
+
+Flags:
+`
+
+func main() {
+	addr := flag.String("addr", os.Getenv("BAKKU_ADDR"), "address of the service, default is read from the config")
+	token := flag.String("token", os.Getenv("BAKKU_TOKEN"), "API token, default is the token stored for this client")
+	caFile := flag.String("cacert", "", "CA certificate of the service, default is the self-signed certificate")
+	certFile := flag.String("cert", "", "client certificate for mTLS")
+	keyFile := flag.String("key", "", "client key for mTLS")
+	offline := flag.Bool("offline", false, "don't connect to the service, read the snapshot database directly")
+	verbose := flag.Bool("v", false, "print log messages")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	if err := config.ReadDefaultConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "bakku: can't read the config: %v\n", err)
+		os.Exit(1)
+	}
+	settings := config.Current().Server
+	if *addr == "" {
+		*addr = serviceAddress(settings)
+	}
+	if *token == "" {
+		*token = auth.ReadClientToken()
+	}
+	if *caFile == "" && settings.TLS.Enabled {
+		*caFile = config.ConfigDirPath(settings.TLS.CertFile)
+	}
+
+	c := client.New(*addr)
+	c.Token = *token
+	if strings.HasPrefix(*addr, "https://") {
+		tlsConfig, err := auth.ClientTLSConfig(*caFile, *certFile, *keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bakku: can't setup TLS: %v\n", err)
+			os.Exit(1)
+		}
+		c.HTTPClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	cli := &CLI{
+		out:    os.Stdout,
+		client: c,
+	}
+	if flag.Arg(0) == "token" {
+		// tokens are managed in the config dir, no connection to the service is needed
+		if err := cli.token(flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "bakku: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *offline || !cli.online() {
+		if !*offline {
+			fmt.Fprintln(os.Stderr, "bakku: service is not running, using offline mode")
+		}
+		o, err := newOffline()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bakku: can't open the snapshot database: %v\n", err)
+			os.Exit(1)
+		}
+		cli.offline = o
+	}
+
+	err := cli.Run(flag.Args())
+	if cli.offline != nil {
+		cli.offline.close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bakku: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// serviceAddress returns the base URL of the service from the server settings
+func serviceAddress(s config.ServerSettings) string {
+	host := s.Bind
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	scheme := "http"
+	if s.TLS.Enabled {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, s.Port)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/backup/local"
+	"github.com/glower/bakku-app/pkg/catalog"
+	"github.com/glower/bakku-app/pkg/config"
+	"github.com/glower/bakku-app/pkg/history"
+	"github.com/glower/bakku-app/pkg/storage"
+	"github.com/glower/bakku-app/pkg/storage/record"
+	"github.com/glower/bakku-app/pkg/types"
+
+	// autoimport for all implemented snapshot databases
+	_ "github.com/glower/bakku-app/pkg/storage/boltdb"
+)
+
+// offline reads the snapshot database and the local storage directly, it must only be
+// used when the service is not running
+type offline struct {
+	db storage.Storager
+}
+
+// newOffline opens the snapshot database, this fails while the service has it open
+func newOffline() (*offline, error) {
+	db, err := storage.New(config.GetStoragePath())
+	if err != nil {
+		return nil, err
+	}
+	if err := record.Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &offline{db: db}, nil
+}
+
+func (o *offline) close() error {
+	return o.db.Close()
+}
+
+// storages returns the names of all configured storages
+func (o *offline) storages() []string {
+	var names []string
+	for name := range config.Current().Storage {
+		names = append(names, backup.StorageName(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (o *offline) status() ([]types.StorageStatus, error) {
+	var result []types.StorageStatus
+	for name, s := range config.Current().Storage {
+		result = append(result, types.StorageStatus{
+			Name:   backup.StorageName(name),
+			Active: s.Active,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+func (o *offline) list(storageName, prefix string) ([]catalog.Entry, error) {
+	return catalog.List(o.db, backup.StorageName(storageName), prefix)
+}
+
+func (o *offline) versions(file string) ([]catalog.Entry, error) {
+	return catalog.Versions(o.db, o.storages(), file)
+}
+
+func (o *offline) restore(storageName, file, to string, overwrite bool) (string, error) {
+	restore, err := o.restoreFunc(storageName)
+	if err != nil {
+		return "", err
+	}
+	return catalog.Restore(o.db, backup.StorageName(storageName), file, to, overwrite, restore)
+}
+
+func (o *offline) verify(storageName, prefix string) ([]backup.VerifyResult, error) {
+	restore, err := o.restoreFunc(storageName)
+	if err != nil {
+		return nil, err
+	}
+	return backup.Verify(o.db, backup.StorageName(storageName), prefix, restore)
+}
+
+// reindex rebuilds the snapshot records of the local storage, other storages need the running service
+func (o *offline) reindex(storageName string, opt backup.ReindexOptions) (*backup.ReindexReport, error) {
+	if backup.StorageName(storageName) != "storage.local" {
+		return nil, fmt.Errorf("only the local storage can be reindexed in the offline mode")
+	}
+	path := config.Current().Storage["local"].Path
+	if path == "" {
+		return nil, fmt.Errorf("path of the local storage is not configured")
+	}
+	lister := backup.ListerFunc(func(ctx context.Context) ([]backup.Object, error) {
+		return local.List(ctx, path)
+	})
+	return backup.Reindex(context.Background(), o.db, "storage.local", lister, opt)
+}
+
+// restoreFunc reads files from the local storage, other storages need the running service
+func (o *offline) restoreFunc(storageName string) (catalog.RestoreFunc, error) {
+	if backup.StorageName(storageName) != "storage.local" {
+		return nil, fmt.Errorf("only the local storage can be read in the offline mode")
+	}
+	path := config.Current().Storage["local"].Path
+	if path == "" {
+		return nil, fmt.Errorf("path of the local storage is not configured")
+	}
+	return func(event *notification.Event, to string) error {
+		return local.Restore(path, event, to)
+	}, nil
+}
+
+func (o *offline) export(w io.Writer, format string, f record.Filter) error {
+	_, err := record.Export(o.db, w, format, f)
+	return err
+}
+
+func (o *offline) importSnapshot(r io.Reader, format string, opt record.ImportOptions) (*record.ImportReport, error) {
+	return record.Import(o.db, r, format, opt)
+}
+
+func (o *offline) history(f history.Filter) (*history.Page, error) {
+	if f.Storage != "" {
+		f.Storage = backup.StorageName(f.Storage)
+	}
+	return history.Default().Query(f)
+}
+
+// config returns the settings from the config file in the same format as the REST API
+func (o *offline) config() (map[string]interface{}, error) {
+	data, err := json.Marshal(config.Current())
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{})
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
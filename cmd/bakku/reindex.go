@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/glower/bakku-app/pkg/backup"
+)
+
+// reindex rebuilds the snapshot database from the files in a storage, e.g. after a reinstall.
+// Run it before the first scan, otherwise all files are uploaded again.
+func (c *CLI) reindex(args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	flags.SetOutput(c.out)
+	dryRun := flags.Bool("dry-run", false, "only print what would be changed")
+	overwrite := flags.Bool("overwrite", false, "replace existing records")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("reindex: missing storage")
+	}
+	opt := backup.ReindexOptions{DryRun: *dryRun, Overwrite: *overwrite}
+
+	var report *backup.ReindexReport
+	var err error
+	if c.offline != nil {
+		report, err = c.offline.reindex(flags.Arg(0), opt)
+	} else {
+		report, err = c.client.Reindex(flags.Arg(0), opt)
+	}
+	if err != nil {
+		return err
+	}
+
+	prefix := ""
+	if report.DryRun {
+		prefix = "dry run: "
+	}
+	fmt.Fprintf(c.out, "%s%d files in %s: %d added, %d updated, %d kept, %d changed locally\n",
+		prefix, report.Objects, report.Storage, report.Added, report.Updated, report.Existing, report.Changed)
+	if len(report.Unmatched) > 0 {
+		fmt.Fprintf(c.out, "skipped directories which are not watched: %s\n", strings.Join(report.Unmatched, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/glower/bakku-app/pkg/backup"
+)
+
+// scrub starts a verification of a storage or prints the last reports, the verification runs
+// in the service and its result is also published as SSE message
+func (c *CLI) scrub(args []string) error {
+	if err := c.requireService("scrub"); err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("scrub", flag.ContinueOnError)
+	flags.SetOutput(c.out)
+	repair := flags.Bool("repair", false, "upload missing and corrupted files again")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		report, err := c.client.Scrub(flags.Arg(0), *repair)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "verification of %s is %s, see bakku scrub\n", report.Storage, report.State)
+		return nil
+	}
+
+	reports, err := c.client.Scrubs()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STORAGE\tSTATE\tCHECKED\tUNVERIFIED\tMISSING\tCORRUPTED\tEXTRA\tREPAIRED")
+	for _, r := range reports {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n", r.Storage, r.State, r.Checked, r.Unverified,
+			len(r.Missing), len(r.Corrupted), len(r.Extra), r.Repaired)
+	}
+	problems := 0
+	for _, r := range reports {
+		problems += len(r.Missing) + len(r.Corrupted) + len(r.Extra)
+	}
+	if problems > 0 {
+		fmt.Fprintln(w, "\nSTORAGE\tPROBLEM\tFILE\tREASON")
+	}
+	for _, r := range reports {
+		printProblems(w, r.Storage, "missing", r.Missing)
+		printProblems(w, r.Storage, "corrupted", r.Corrupted)
+		printProblems(w, r.Storage, "extra", r.Extra)
+		if r.Error != "" {
+			fmt.Fprintf(w, "\n%s: %s\n", r.Storage, r.Error)
+		}
+	}
+	return w.Flush()
+}
+
+func printProblems(w *tabwriter.Writer, storageName, kind string, problems []backup.Problem) {
+	for _, p := range problems {
+		name := p.Path
+		if name == "" {
+			name = p.Key
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", storageName, kind, name, p.Reason)
+	}
+}
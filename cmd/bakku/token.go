@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/glower/bakku-app/pkg/auth"
+	"github.com/glower/bakku-app/pkg/config"
+)
+
+// token manages the API tokens, the service reads the token file again when it's changed
+func (c *CLI) token(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("token: missing command, use create, ls or rm")
+	}
+	tokens := auth.NewTokens(config.ConfigDirPath(config.Current().Server.Auth.TokenFile))
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("token create", flag.ContinueOnError)
+		save := flags.Bool("save", false, "store the token for this client")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() == 0 {
+			return fmt.Errorf("token create: missing name")
+		}
+		token, err := tokens.Create(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		if *save {
+			if err := auth.SaveClientToken(token); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(c.out, token)
+		return nil
+	case "ls":
+		list, err := tokens.List()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tCREATED")
+		for _, t := range list {
+			fmt.Fprintf(w, "%s\t%s\n", t.Name, t.Created.Format("2006-01-02 15:04"))
+		}
+		return w.Flush()
+	case "rm":
+		if len(args) < 2 {
+			return fmt.Errorf("token rm: missing name")
+		}
+		return tokens.Remove(args[1])
+	}
+	return fmt.Errorf("unknown token command [%s], use create, ls or rm", args[0])
+}
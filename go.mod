@@ -5,6 +5,8 @@ go 1.12
 require (
 	cloud.google.com/go v0.40.0 // indirect
 	github.com/boltdb/bolt v1.3.1
+	github.com/cespare/xxhash/v2 v2.1.0
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/glower/file-watcher v0.0.0-20190621203329-46bc36fd783e
 	github.com/go-logfmt/logfmt v0.4.0 // indirect
 	github.com/google/uuid v1.1.1
@@ -15,14 +17,13 @@ require (
 	github.com/nsf/gocode v0.0.0-20190302080247-5bee97b48836 // indirect
 	github.com/paulbellamy/ratecounter v0.2.0
 	github.com/pelletier/go-toml v1.4.0 // indirect
-	github.com/r3labs/sse v0.0.0-20190530104643-3c23fe8c6bd2
 	github.com/rcrowley/go-metrics v0.0.0-20181016184325-3113b8401b8a
 	github.com/spf13/afero v1.2.2 // indirect
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/spf13/viper v1.4.0
 	go.opencensus.io v0.22.0 // indirect
-	golang.org/x/crypto v0.0.0-20190621222207-cc06ce4a13d4 // indirect
-	golang.org/x/net v0.0.0-20190620200207-3b0461eec859 // indirect
+	golang.org/x/crypto v0.0.0-20190621222207-cc06ce4a13d4
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
 	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 	golang.org/x/sys v0.0.0-20190626221950-04f50cda93cb // indirect
 	golang.org/x/tools v0.0.0-20190627220010-94c5763a7c84 // indirect
@@ -30,5 +31,5 @@ require (
 	google.golang.org/appengine v1.6.1 // indirect
 	google.golang.org/genproto v0.0.0-20190611190212-a7e196e89fd3 // indirect
 	google.golang.org/grpc v1.21.1 // indirect
-	gopkg.in/cenkalti/backoff.v1 v1.1.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
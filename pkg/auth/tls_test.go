@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelfSigned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakku-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	if err := SelfSigned(certFile, keyFile, []string{"localhost", "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign != 0 {
+		t.Errorf("the server certificate can sign other certificates")
+	}
+
+	// a client which has the certificate as CA file trusts the server
+	server, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{server}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+	client, err := ClientTLSConfig(certFile, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ServerName = "localhost"
+	conn, err := tls.Dial("tcp", ln.Addr().String(), client)
+	if err != nil {
+		t.Fatalf("the client doesn't trust the server: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,200 @@
+// Package auth manages the API tokens and the TLS certificates of the HTTP API
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glower/bakku-app/pkg/config"
+)
+
+// tokenPrefix makes tokens recognizable, e.g. for secret scanners
+const tokenPrefix = "bakku_"
+
+// Token is a named API token, only the hash of the token is stored
+type Token struct {
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Created time.Time `json:"created"`
+}
+
+// Tokens is a file with token hashes, the file is read again when it was changed
+type Tokens struct {
+	sync.Mutex
+	file    string
+	modTime time.Time
+	tokens  []Token
+}
+
+// NewTokens returns the tokens stored in the given file
+func NewTokens(file string) *Tokens {
+	return &Tokens{file: file}
+}
+
+// Hash returns the hex encoded SHA-256 hash of a token
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Valid checks the token against all stored hashes
+func (t *Tokens) Valid(token string) bool {
+	if token == "" {
+		return false
+	}
+	t.Lock()
+	defer t.Unlock()
+	if err := t.load(); err != nil {
+		return false
+	}
+	hash := []byte(Hash(token))
+	valid := false
+	for _, stored := range t.tokens {
+		// compare all hashes in constant time, the position of a match is not leaked
+		if subtle.ConstantTimeCompare(hash, []byte(stored.Hash)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// Create generates a new token, stores its hash and returns the token. It's the only time the token is visible.
+func (t *Tokens) Create(name string) (string, error) {
+	t.Lock()
+	defer t.Unlock()
+	if err := t.load(); err != nil {
+		return "", err
+	}
+	for _, stored := range t.tokens {
+		if stored.Name == name {
+			return "", fmt.Errorf("token [%s] already exists", name)
+		}
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := tokenPrefix + hex.EncodeToString(b)
+	t.tokens = append(t.tokens, Token{
+		Name:    name,
+		Hash:    Hash(token),
+		Created: time.Now(),
+	})
+	return token, t.save()
+}
+
+// Remove deletes a token by name
+func (t *Tokens) Remove(name string) error {
+	t.Lock()
+	defer t.Unlock()
+	if err := t.load(); err != nil {
+		return err
+	}
+	for i, stored := range t.tokens {
+		if stored.Name == name {
+			t.tokens = append(t.tokens[:i], t.tokens[i+1:]...)
+			return t.save()
+		}
+	}
+	return fmt.Errorf("token [%s] not found", name)
+}
+
+// List returns all tokens sorted by name
+func (t *Tokens) List() ([]Token, error) {
+	t.Lock()
+	defer t.Unlock()
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	result := make([]Token, len(t.tokens))
+	copy(result, t.tokens)
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// load reads the file if it was changed since the last read, a missing file means no tokens
+func (t *Tokens) load() error {
+	info, err := os.Stat(t.file)
+	if os.IsNotExist(err) {
+		t.tokens = nil
+		t.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(t.modTime) && t.tokens != nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(t.file)
+	if err != nil {
+		return err
+	}
+	var tokens []Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return fmt.Errorf("invalid token file [%s]: %v", t.file, err)
+	}
+	t.tokens = tokens
+	t.modTime = info.ModTime()
+	return nil
+}
+
+func (t *Tokens) save() error {
+	data, err := json.MarshalIndent(t.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := config.WriteFileAtomic(t.file, data, 0600); err != nil {
+		return err
+	}
+	if info, err := os.Stat(t.file); err == nil {
+		t.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// clientTokenFile is read by local clients like the CLI and the UI
+const clientTokenFile = "client.token"
+
+// ClientTokenPath returns the path of the token file of local clients
+func ClientTokenPath() string {
+	return config.ConfigDirPath(clientTokenFile)
+}
+
+// ReadClientToken returns the token of local clients, it's empty if there is none
+func ReadClientToken() string {
+	data, err := ioutil.ReadFile(ClientTokenPath())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// SaveClientToken stores a token for local clients, the file is only readable by the user
+func SaveClientToken(token string) error {
+	return config.WriteFileAtomic(ClientTokenPath(), []byte(token+"\n"), 0600)
+}
+
+// Init creates the first token on the first start and stores it for local clients,
+// otherwise nobody could use the API after auth was enabled
+func Init(t *Tokens) error {
+	tokens, err := t.List()
+	if err != nil || len(tokens) > 0 {
+		return err
+	}
+	token, err := t.Create("default")
+	if err != nil {
+		return err
+	}
+	return SaveClientToken(token)
+}
@@ -5,4 +5,7 @@ import (
 	_ "github.com/glower/bakku-app/pkg/backup/fake"
 	_ "github.com/glower/bakku-app/pkg/backup/gdrive"
 	_ "github.com/glower/bakku-app/pkg/backup/local"
+
+	// autoimport for all implemented snapshot databases
+	_ "github.com/glower/bakku-app/pkg/storage/boltdb"
 )
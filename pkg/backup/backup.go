@@ -2,42 +2,75 @@ package backup
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/glower/file-watcher/notification"
 
+	"github.com/glower/bakku-app/pkg/checksum"
+	"github.com/glower/bakku-app/pkg/config"
+	conf "github.com/glower/bakku-app/pkg/config/storage"
 	"github.com/glower/bakku-app/pkg/event"
+	"github.com/glower/bakku-app/pkg/history"
 	"github.com/glower/bakku-app/pkg/message"
+	"github.com/glower/bakku-app/pkg/metrics"
+	"github.com/glower/bakku-app/pkg/ratelimit"
 	"github.com/glower/bakku-app/pkg/storage"
+	"github.com/glower/bakku-app/pkg/storage/record"
 	"github.com/glower/bakku-app/pkg/types"
 )
 
-// token represents a request that is being processed.
-type token struct{}
-
 type teardown func()
 
-// Storage represents an interface for a backup storage provider
+// Storage represents an interface for a backup storage provider, Store must return when the context is canceled
 type Storage interface {
 	Setup(*StorageManager) (bool, error)
-	Store(*notification.Event) error
+	Store(context.Context, *Upload) error
 }
 
-var teardowns = make(map[string]teardown)
+// Restorer is implemented by storages which can copy a backuped file back to the local disk
+type Restorer interface {
+	Restore(event *notification.Event, to string) error
+}
+
+var (
+	teardownsM sync.Mutex
+	teardowns  = make(map[string]teardown)
+	// uploadCtxs are canceled when the storage is stopped, all uploads of the storage are canceled with them
+	uploadCtxs = make(map[string]context.Context)
+	// timeouts is the maximum duration of one upload per storage
+	timeouts = make(map[string]time.Duration)
+)
 
 // StorageManager ...
 type StorageManager struct {
 	Ctx context.Context
 
-	tokens               chan token
 	MessageCh            chan message.Message
 	EventCh              chan notification.Event
 	FileBackupProgressCh chan types.BackupProgress
 	LocalSnapshotStorage storage.Storager
 	r                    *types.GlobalResources
+	// buffer sends the changed files, it's stopped on shutdown
+	buffer *event.Buffer
+	hold   *holdQueue
+	poolsM sync.Mutex
+	// pools are the waiting files and the workers per storage
+	pools map[string]*pool
+
+	// uploadsCtx is not derived from Ctx, running uploads can finish while the service is shutting down
+	uploadsCtx    context.Context
+	cancelUploads context.CancelFunc
+	stop          chan struct{}
+	stateM        sync.Mutex
+	stopping      bool
+	inflight      sync.WaitGroup
+	// unfinished are files which were not uploaded because of the shutdown, they are uploaded on the next start
+	unfinished map[string]pendingFile
 }
 
 // Setup runs all implemented storages
@@ -47,48 +80,95 @@ func Setup(ctx context.Context, res *types.GlobalResources, eventBuffer *event.B
 		EventCh:              eventBuffer.EvenOutCh,
 		LocalSnapshotStorage: res.Storage,
 		FileBackupProgressCh: make(chan types.BackupProgress),
-		tokens:               make(chan token, 5),
 		r:                    res,
+		buffer:               eventBuffer,
+		hold:                 newHoldQueue(),
+		pools:                make(map[string]*pool),
+		stop:                 make(chan struct{}),
+		unfinished:           make(map[string]pendingFile),
 	}
+	m.uploadsCtx, m.cancelUploads = context.WithCancel(context.Background())
 
-	for i := 0; i < 5; i++ {
-		m.tokens <- token{}
-	}
-
-	for name, storage := range GetAll() {
-		ok, err := storage.Setup(m)
-		if ok && err == nil {
-			log.Printf("Setup(): backup storage [%s] is ready\n", name)
-			_, cancel := context.WithCancel(context.Background())
-			teardowns[name] = func() { cancel() }
-		} else if !ok && err == nil {
-			log.Printf("storage.SetupManager(): storage [%s] is not configured\n", name)
-			Unregister(name)
-		}
-		if err != nil {
+	for name := range GetAll() {
+		if err := m.setupStorage(name); err != nil {
 			m.r.MessageCh <- message.FormatMessage("ERROR", err.Error(), name)
 		}
 	}
+	metrics.OnCollect(m.collectMetrics)
+	event.OnProgress(queueBytes)
+	m.loadControl()
 	go m.ProcessNotifications(ctx)
+	go m.releaseAfterQuietHours(ctx)
+	go m.requeuePending()
 	return m
 }
 
+// setupStorage configures a storage and activates it, a storage which is not configured is deactivated
+func (m *StorageManager) setupStorage(name string) error {
+	storage, ok := Registered()[name]
+	if !ok {
+		return fmt.Errorf("unknown storage [%s]", name)
+	}
+	ok, err := storage.Setup(m)
+	if ok && err == nil {
+		log.Printf("Setup(): backup storage [%s] is ready\n", name)
+		if err := m.setupHoldPolicy(name); err != nil {
+			log.Printf("[ERROR] Setup(): storage [%s]: %v\n", name, err)
+		}
+		teardownsM.Lock()
+		// a storage which is set up again keeps its running uploads, a new timeout is used for the next ones
+		if _, ok := uploadCtxs[name]; !ok {
+			ctx, cancel := context.WithCancel(m.uploadsCtx)
+			teardowns[name] = func() { cancel() }
+			uploadCtxs[name] = ctx
+		}
+		timeouts[name] = conf.ProviderConf(strings.TrimPrefix(name, "storage.")).Timeout
+		teardownsM.Unlock()
+		activate(name)
+		m.setupPool(name)
+		return nil
+	}
+	if !ok && err == nil {
+		log.Printf("storage.SetupManager(): storage [%s] is not configured\n", name)
+	}
+	stopStorage(name)
+	return err
+}
+
+// ReloadStorage reads the configuration of a storage again and activates or deactivates it
+func (m *StorageManager) ReloadStorage(name string) error {
+	m.hold.Lock()
+	delete(m.hold.policies, name)
+	m.hold.Unlock()
+	return m.setupStorage(name)
+}
+
 // ProcessNotifications sends file change notofocations to all registerd storages
 func (m *StorageManager) ProcessNotifications(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-m.stop:
+			return
 		case file := <-m.EventCh:
 			switch file.Action {
 			case notification.FileRemoved:
 				fmt.Printf("backup: file=[%s] was deleted\n", file.AbsolutePath)
-			case notification.FileAdded, notification.FileModified, notification.FileRenamedNewName:
-				fmt.Printf("backup: file [%s] was added, free slots: %d\n", file.AbsolutePath, len(m.tokens))
-				for storageName, storageProvider := range GetAll() {
-					tok := <-m.tokens
-					go m.sendFileToStorage(&file, storageProvider, storageName, tok)
+				for storageName := range GetAll() {
+					history.Add(history.Record{
+						Action:  history.ActionDelete,
+						File:    file.AbsolutePath,
+						Storage: storageName,
+						Result:  history.ResultSkipped,
+						Error:   "backups of deleted files are kept",
+					})
 				}
+				// nothing is uploaded, the buffer can forget the file
+				m.report(types.BackupComplete{FilePath: file.AbsolutePath, Finished: true, Done: true})
+			case notification.FileAdded, notification.FileModified, notification.FileRenamedNewName:
+				fmt.Printf("backup: file [%s] was added\n", file.AbsolutePath)
+				m.route(file)
 			default:
 				log.Printf("[ERROR] ProcessFileChangeNotifications(): unknown file change notification: %#v\n", file)
 			}
@@ -96,76 +176,258 @@ func (m *StorageManager) ProcessNotifications(ctx context.Context) {
 	}
 }
 
-func (m *StorageManager) sendFileToStorage(event *notification.Event, backup Storage, storageName string, t token) {
+// route sends a file to all active storages which don't have this version yet, the buffer is told when
+// the file is done or held by all storages
+func (m *StorageManager) route(file notification.Event) {
+	var active []string
+	for storageName := range GetAll() {
+		active = append(active, storageName)
+	}
+	if len(active) == 0 {
+		// the buffer sends the file again with the next batch
+		m.report(types.BackupComplete{FilePath: file.AbsolutePath, Finished: true})
+		return
+	}
+	storages, ok := routeStorages(&file, active)
+	if !ok {
+		// the attempt for the older version of the file is not reported anymore
+		m.report(types.BackupComplete{FilePath: file.AbsolutePath, Finished: true})
+	}
+	for _, storageName := range storages {
+		queued(&file, storageName)
+		if m.held(storageName, file) {
+			holdRoute(file.AbsolutePath, storageName)
+			continue
+		}
+		m.dispatch(file, storageName)
+	}
+	m.routed(&file)
+}
+
+func (m *StorageManager) sendFileToStorage(event *notification.Event, storageName string) {
+	defer m.inflight.Done()
+	defer m.addActive(storageName, -1)
 	if event.AbsolutePath == "" {
 		return
 	}
+	backup, ok := GetAll()[storageName]
+	if !ok {
+		untrack(event, storageName)
+		m.reroute(event.AbsolutePath, storageName, "")
+		return
+	}
 	fmt.Printf("sendFileToStorage(): backup [%s] => %s BEGIN\n", event.AbsolutePath, storageName)
-	defer func() {
-		m.tokens <- t
-	}()
 
-	if InProgress(event, storageName) {
+	if m.skipCanceled(event, storageName) {
+		log.Printf("sendFileToStorage(): upload of [%s] => %s was canceled\n", event.AbsolutePath, storageName)
+		untrack(event, storageName)
+		m.reroute(event.AbsolutePath, storageName, "")
 		return
 	}
+	if m.holdIfPaused(*event, storageName) {
+		m.reroute(event.AbsolutePath, storageName, routeHeld)
+		return
+	}
+
+	storageCtx, timeout := storageCtx(storageName)
+	ctx := storageCtx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(storageCtx, timeout)
+		defer cancel()
+	}
+	ctx, cancelUpload := context.WithCancel(ctx)
+	defer cancelUpload()
 
-	Start(event, storageName)
-	err := backup.Store(event)
+	if !Start(event, storageName) {
+		// the buffer sends the file again after the running upload
+		m.reroute(event.AbsolutePath, storageName, routeFailed)
+		return
+	}
+	setCancel(event, storageName, cancelUpload)
+	started := time.Now()
+	remoteID, sums, err := m.store(ctx, backup, event, storageName)
+	canceled := clearCancel(event, storageName)
 	Finish(event, storageName)
 
-	if err != nil {
-		m.r.BackupCompleteCh <- types.BackupComplete{
-			Success:     false,
-			StorageName: storageName,
-			FilePath:    event.AbsolutePath,
+	if err != nil && canceled == canceledByUser {
+		log.Printf("sendFileToStorage(): backup [%s] => %s canceled by the user\n", event.AbsolutePath, storageName)
+		addHistory(event, storageName, started, history.ResultCanceled, err)
+		untrack(event, storageName)
+		m.reroute(event.AbsolutePath, storageName, "")
+		m.r.MessageCh <- message.FormatMessage("INFO", fmt.Sprintf("upload of [%s] was canceled", event.AbsolutePath), storageName)
+		return
+	}
+	if err != nil && canceled == canceledByPause {
+		// the upload starts again when the storage is resumed
+		log.Printf("sendFileToStorage(): backup [%s] => %s stopped by the pause\n", event.AbsolutePath, storageName)
+		addHistory(event, storageName, started, history.ResultCanceled, err)
+		untrack(event, storageName)
+		trackQueued(event, storageName)
+		if !m.holdIfPaused(*event, storageName) {
+			m.dispatch(*event, storageName)
+			return
 		}
+		m.reroute(event.AbsolutePath, storageName, routeHeld)
+		return
+	}
+	if err != nil && storageCtx.Err() != nil {
+		// the upload was canceled by the shutdown or because the storage was stopped
+		log.Printf("sendFileToStorage(): backup [%s] => %s canceled\n", event.AbsolutePath, storageName)
+		addHistory(event, storageName, started, history.ResultCanceled, err)
+		untrack(event, storageName)
+		m.canceled(event, storageName)
+		return
+	}
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("upload of [%s] timed out after %s", event.AbsolutePath, timeout)
+	}
+	uploaded(event, storageName, started, err)
+	if err != nil {
+		addHistory(event, storageName, started, history.ResultFailed, err)
+		trackFinished(event, storageName, err)
+		m.complete(event, storageName, err)
 		m.r.MessageCh <- message.FormatMessage("ERROR", err.Error(), storageName)
 		return
 	}
 
-	err = m.updateLocalStorage(event, storageName)
+	err = m.updateLocalStorage(event, storageName, remoteID, sums)
+	trackFinished(event, storageName, err)
+	m.complete(event, storageName, err)
 	if err != nil {
+		addHistory(event, storageName, started, history.ResultFailed, err)
 		m.r.MessageCh <- message.FormatMessage("ERROR", err.Error(), storageName)
 		return
 	}
-	m.r.BackupCompleteCh <- types.BackupComplete{
-		Success:     true,
-		StorageName: storageName,
-		FilePath:    event.AbsolutePath,
-	}
+	addHistory(event, storageName, started, history.ResultOK, nil)
 	fmt.Printf("sendFileToStorage(): backup [%s] => %s DONE\n", event.AbsolutePath, storageName)
 }
 
-func (m *StorageManager) updateLocalStorage(event *notification.Event, storageName string) error {
-	value, err := json.Marshal(&event)
+// canceled keeps a file whose upload was canceled with the context of its storage. During the shutdown it's
+// uploaded on the next start, a storage which is active again uploads it now and the buffer sends it again
+// if the storage was stopped.
+func (m *StorageManager) canceled(event *notification.Event, storageName string) {
+	m.stateM.Lock()
+	stopping := m.stopping
+	m.stateM.Unlock()
+	if stopping {
+		m.addUnfinished(*event, storageName)
+		return
+	}
+	if ctx, _ := storageCtx(storageName); ctx.Err() == nil {
+		trackQueued(event, storageName)
+		m.dispatch(*event, storageName)
+		return
+	}
+	m.reroute(event.AbsolutePath, storageName, routeFailed)
+}
+
+// store opens the file and sends it to the storage, it returns the id of the file in the storage if there is one
+// and the checksums of the sent content. The checksums are missing if the storage didn't read the whole file.
+// The upload is limited by the bandwidth settings, restores don't pass here and are not limited.
+func (m *StorageManager) store(ctx context.Context, backup Storage, event *notification.Event, storageName string) (string, map[string]string, error) {
+	upload, f, err := openUpload(event)
 	if err != nil {
-		return err
+		return "", nil, err
+	}
+	defer f.Close()
+	checksums := config.Current().Checksum
+	sums := checksum.NewWriter(checksums.ChangeAlgorithm(), checksums.VerifyAlgorithm())
+	upload.Reader = &countingReader{
+		r:       io.TeeReader(ratelimit.NewReader(ctx, storageName, upload.Reader), sums),
+		counter: metrics.Counter(metrics.BytesTransferred, "storage", shortName(storageName)),
+		sent:    trackStarted(event, storageName, upload.Size),
+	}
+	err = backup.Store(ctx, upload)
+	if sums.Written() != upload.Size {
+		return upload.RemoteID, nil, err
+	}
+	return upload.RemoteID, sums.Sums(), err
+}
+
+// storageCtx returns the context and the timeout for uploads to the storage, the context
+// of a stopped storage is canceled
+func storageCtx(storageName string) (context.Context, time.Duration) {
+	teardownsM.Lock()
+	defer teardownsM.Unlock()
+	if ctx, ok := uploadCtxs[storageName]; ok {
+		return ctx, timeouts[storageName]
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx, 0
+}
+
+// addHistory logs an upload attempt
+func addHistory(event *notification.Event, storageName string, started time.Time, result string, err error) {
+	r := history.Record{
+		Action:   history.ActionStore,
+		File:     event.AbsolutePath,
+		Storage:  storageName,
+		Size:     event.Size,
+		Checksum: event.Checksum,
+		Duration: int64(time.Since(started) / time.Millisecond),
+		Result:   result,
 	}
-	err = m.LocalSnapshotStorage.Add(event.AbsolutePath, storageName, value)
 	if err != nil {
-		return err
+		r.Error = err.Error()
 	}
-	return nil
+	history.Add(r)
 }
 
-// Stop eveything
-func Stop() {
-	// block here untill all files are transferd
-	for {
-		<-time.After(3 * time.Second)
-		inProgress := TotalFilesInProgres()
-		log.Printf("backup.Stop(): TotalFilesInProgres: %d, waiting ...	\n", inProgress)
-		// if inProgress == 0 {
-		if true {
-			teardownAll()
-			return
+// updateLocalStorage writes the record of an uploaded file with the checksums of the configured algorithms,
+// the former record becomes a version
+func (m *StorageManager) updateLocalStorage(event *notification.Event, storageName, remoteID string, sums map[string]string) error {
+	checksums := config.Current().Checksum
+	change, verify := checksums.ChangeAlgorithm(), checksums.VerifyAlgorithm()
+	r := record.FromEvent(event, storageName)
+	r.RemoteID = remoteID
+	r.Checksum = record.Checksum{Algorithm: change, Value: fileChecksum(event, change, sums)}
+	if sum := fileChecksum(event, verify, sums); sum != "" {
+		r.Verify = &record.Checksum{Algorithm: verify, Value: sum}
+	}
+	if former, err := m.LocalSnapshotStorage.Get(event.AbsolutePath, storageName); err == nil && former != "" {
+		if f, err := record.Decode(former, storageName); err == nil {
+			r.Replace(f)
 		}
 	}
+	value, err := r.Encode()
+	if err != nil {
+		return err
+	}
+	return m.LocalSnapshotStorage.Add(event.AbsolutePath, storageName, value)
+}
+
+// fileChecksum returns the checksum of the uploaded content or reads the file again if it's missing
+func fileChecksum(event *notification.Event, algorithm string, sums map[string]string) string {
+	if sum, ok := sums[algorithm]; ok {
+		return sum
+	}
+	if algorithm == checksum.Default {
+		return event.Checksum
+	}
+	sum, err := checksum.File(event.AbsolutePath, algorithm)
+	if err != nil {
+		log.Printf("[ERROR] backup.fileChecksum(): can't compute the %s checksum of [%s]: %v\n", algorithm, event.AbsolutePath, err)
+	}
+	return sum
 }
 
 func teardownAll() {
-	for name, teardown := range teardowns {
-		teardown()
-		Unregister(name)
+	for name := range GetAll() {
+		stopStorage(name)
+	}
+}
+
+// stopStorage stops and deactivates a storage
+func stopStorage(name string) {
+	teardownsM.Lock()
+	if t, ok := teardowns[name]; ok {
+		t()
+		delete(teardowns, name)
+		delete(uploadCtxs, name)
+		delete(timeouts, name)
 	}
+	teardownsM.Unlock()
+	Unregister(name)
 }
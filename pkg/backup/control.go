@@ -0,0 +1,291 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/event"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+// controlBucket stores the state of paused and draining storages, canceledBucket the canceled files
+const (
+	controlBucket  = "backup.control"
+	canceledBucket = "backup.canceled"
+)
+
+// canceledFile is the version of a file whose upload was canceled, it's uploaded again when it changes
+type canceledFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func newCanceledFile(path string) canceledFile {
+	info, err := os.Stat(path)
+	if err != nil {
+		return canceledFile{}
+	}
+	return canceledFile{Size: info.Size(), ModTime: info.ModTime()}
+}
+
+func (c canceledFile) same(o canceledFile) bool {
+	return c.Size == o.Size && c.ModTime.Equal(o.ModTime)
+}
+
+// loadControl reads the states of the storages and the canceled files of the last run,
+// a storage which was draining is paused because nothing is uploading after the start
+func (m *StorageManager) loadControl() {
+	// the buckets don't exist if nothing was paused or canceled yet
+	states, _ := m.LocalSnapshotStorage.GetAll(controlBucket)
+	canceled, _ := m.LocalSnapshotStorage.GetAll(canceledBucket)
+
+	m.hold.Lock()
+	defer m.hold.Unlock()
+	for name, state := range states {
+		if state == types.StatePaused || state == types.StateDraining {
+			m.hold.paused[name] = true
+			log.Printf("backup.loadControl(): storage [%s] is paused\n", name)
+		}
+	}
+	for key, value := range canceled {
+		c := canceledFile{}
+		if err := json.Unmarshal([]byte(value), &c); err != nil {
+			log.Printf("[ERROR] backup.loadControl(): invalid canceled file [%s]: %v\n", key, err)
+			continue
+		}
+		m.hold.canceled[key] = c
+	}
+}
+
+// saveState stores the state of a storage for the next start and publishes it
+func (m *StorageManager) saveState(storageName, state string) {
+	var err error
+	if state == types.StateActive {
+		err = m.LocalSnapshotStorage.Remove(storageName, controlBucket)
+	} else {
+		err = m.LocalSnapshotStorage.Add(storageName, controlBucket, []byte(state))
+	}
+	if err != nil {
+		log.Printf("[ERROR] backup.saveState(): can't store the state of [%s]: %v\n", storageName, err)
+	}
+	publishState(storageName, "", state)
+}
+
+func publishState(storageName, path, state string) {
+	event.Publish(event.TypeState, storageName, path, types.ControlState{
+		Storage: storageName,
+		Path:    path,
+		State:   state,
+	})
+}
+
+// state returns the control state of a storage, the caller must hold the lock
+func (q *holdQueue) state(storageName string) string {
+	switch {
+	case q.paused[storageName]:
+		return types.StatePaused
+	case q.draining[storageName]:
+		return types.StateDraining
+	}
+	return types.StateActive
+}
+
+// Pause holds all files of a storage until it is resumed, running uploads are stopped and held as well
+func (m *StorageManager) Pause(storageName string) error {
+	if _, ok := Registered()[storageName]; !ok {
+		return fmt.Errorf("unknown storage [%s]", storageName)
+	}
+	m.hold.Lock()
+	m.hold.paused[storageName] = true
+	delete(m.hold.draining, storageName)
+	m.hold.Unlock()
+	n := cancelUploads(func(key string) bool {
+		_, s := splitKey(key)
+		return s == storageName
+	}, canceledByPause)
+	log.Printf("backup.Pause(): storage [%s] is paused, %d running uploads are stopped\n", storageName, n)
+	m.saveState(storageName, types.StatePaused)
+	return nil
+}
+
+// Drain lets a storage finish the files which are uploading or waiting for a slot and pauses it afterwards,
+// files which change meanwhile are held
+func (m *StorageManager) Drain(storageName string) error {
+	if _, ok := Registered()[storageName]; !ok {
+		return fmt.Errorf("unknown storage [%s]", storageName)
+	}
+	m.hold.Lock()
+	if m.hold.paused[storageName] {
+		m.hold.Unlock()
+		return nil
+	}
+	m.hold.draining[storageName] = true
+	m.hold.Unlock()
+	log.Printf("backup.Drain(): storage [%s] is draining\n", storageName)
+	m.saveState(storageName, types.StateDraining)
+	m.drained(storageName)
+	return nil
+}
+
+// drained pauses a draining storage if it has no dispatched files anymore
+func (m *StorageManager) drained(storageName string) {
+	m.hold.Lock()
+	if !m.hold.draining[storageName] || m.hold.active[storageName] > 0 {
+		m.hold.Unlock()
+		return
+	}
+	delete(m.hold.draining, storageName)
+	m.hold.paused[storageName] = true
+	m.hold.Unlock()
+	log.Printf("backup.drained(): storage [%s] is drained and paused\n", storageName)
+	m.saveState(storageName, types.StatePaused)
+}
+
+// Resume uploads all files held while the storage was paused, unless its schedule or quiet hours hold them further
+func (m *StorageManager) Resume(storageName string) error {
+	if _, ok := Registered()[storageName]; !ok {
+		return fmt.Errorf("unknown storage [%s]", storageName)
+	}
+	m.hold.Lock()
+	stopped := m.hold.stopped(storageName)
+	delete(m.hold.paused, storageName)
+	delete(m.hold.draining, storageName)
+	holds := m.hold.holds(storageName, time.Now())
+	m.hold.Unlock()
+	log.Printf("backup.Resume(): storage [%s] is resumed\n", storageName)
+	if stopped {
+		m.saveState(storageName, types.StateActive)
+	}
+	if !holds {
+		go m.Release(storageName)
+	}
+	return nil
+}
+
+// addActive counts the dispatched files of a storage, a draining storage is paused after the last one
+func (m *StorageManager) addActive(storageName string, n int) {
+	m.hold.Lock()
+	m.hold.active[storageName] += n
+	idle := m.hold.active[storageName] <= 0
+	if idle {
+		delete(m.hold.active, storageName)
+	}
+	draining := m.hold.draining[storageName]
+	m.hold.Unlock()
+	if idle && draining {
+		m.drained(storageName)
+	}
+}
+
+// holdIfPaused holds a dispatched file if its storage was paused meanwhile, prioritized files are uploaded anyway
+func (m *StorageManager) holdIfPaused(event notification.Event, storageName string) bool {
+	key := buildKey(event.AbsolutePath, storageName)
+	m.hold.Lock()
+	defer m.hold.Unlock()
+	if m.hold.prioritized[key] {
+		delete(m.hold.prioritized, key)
+		return false
+	}
+	if !m.hold.paused[storageName] {
+		return false
+	}
+	m.hold.add(storageName, event)
+	return true
+}
+
+// Cancel stops the upload of a file to a storage, it can be running, waiting for a slot or held.
+// The file is not uploaded again until it changes.
+func (m *StorageManager) Cancel(path, storageName string) error {
+	key := buildKey(path, storageName)
+	running := cancelUploads(func(k string) bool { return k == key }, canceledByUser) > 0
+	waiting := m.unqueue(path, storageName)
+	m.hold.Lock()
+	_, held := m.hold.pending[storageName][path]
+	delete(m.hold.pending[storageName], path)
+	delete(m.hold.prioritized, key)
+	m.hold.Unlock()
+	if !running && !waiting && !held && !isTracked(path, storageName) {
+		return fmt.Errorf("[%s] is not queued for [%s]", path, storageName)
+	}
+	if waiting || held {
+		untrack(&notification.Event{AbsolutePath: path}, storageName)
+		m.reroute(path, storageName, "")
+	}
+	m.setCanceled(key, newCanceledFile(path))
+	log.Printf("backup.Cancel(): upload of [%s] to [%s] is canceled\n", path, storageName)
+	publishState(storageName, path, types.StateCanceled)
+	return nil
+}
+
+// Prioritize uploads a file before the other waiting files of the storage, a held file is uploaded even if
+// its storage is paused or in quiet hours. A canceled file is uploaded again with its next change or scan.
+func (m *StorageManager) Prioritize(path, storageName string) error {
+	if _, ok := GetAll()[storageName]; !ok {
+		return fmt.Errorf("storage [%s] is not active", storageName)
+	}
+	key := buildKey(path, storageName)
+	uncanceled := m.uncancel(key)
+	m.hold.Lock()
+	e, held := m.hold.pending[storageName][path]
+	delete(m.hold.pending[storageName], path)
+	if held {
+		m.hold.prioritized[key] = true
+	}
+	m.hold.Unlock()
+	if held {
+		m.dispatch(e, storageName)
+	}
+	bumped := m.pool(storageName).queue.Bump(path)
+	if !held && !bumped && !uncanceled && !isTracked(path, storageName) {
+		return fmt.Errorf("[%s] is not queued for [%s]", path, storageName)
+	}
+	log.Printf("backup.Prioritize(): upload of [%s] to [%s] is prioritized\n", path, storageName)
+	publishState(storageName, path, types.StatePrioritized)
+	return nil
+}
+
+func (m *StorageManager) setCanceled(key string, c canceledFile) {
+	m.hold.Lock()
+	m.hold.canceled[key] = c
+	m.hold.Unlock()
+	value, _ := json.Marshal(c)
+	if err := m.LocalSnapshotStorage.Add(key, canceledBucket, value); err != nil {
+		log.Printf("[ERROR] backup.setCanceled(): %v\n", err)
+	}
+}
+
+// uncancel forgets a canceled file, it returns false if the file was not canceled
+func (m *StorageManager) uncancel(key string) bool {
+	m.hold.Lock()
+	_, ok := m.hold.canceled[key]
+	delete(m.hold.canceled, key)
+	m.hold.Unlock()
+	if !ok {
+		return false
+	}
+	if err := m.LocalSnapshotStorage.Remove(key, canceledBucket); err != nil {
+		log.Printf("[ERROR] backup.uncancel(): %v\n", err)
+	}
+	return true
+}
+
+// skipCanceled checks if the upload of the file was canceled and the file didn't change since
+func (m *StorageManager) skipCanceled(event *notification.Event, storageName string) bool {
+	key := buildKey(event.AbsolutePath, storageName)
+	m.hold.Lock()
+	c, ok := m.hold.canceled[key]
+	m.hold.Unlock()
+	if !ok {
+		return false
+	}
+	if c.same(newCanceledFile(event.AbsolutePath)) {
+		return true
+	}
+	m.uncancel(key)
+	return false
+}
@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/storage/memory"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+func TestDrainPauseCancel(t *testing.T) {
+	const name = "storage.control"
+	storage := &blockingStorage{started: make(chan struct{})}
+	Register(name, storage)
+	defer stopStorage(name)
+
+	db := memory.New()
+	m := &StorageManager{
+		LocalSnapshotStorage: db,
+		hold:                 newHoldQueue(),
+		stop:                 make(chan struct{}),
+	}
+	m.uploadsCtx, m.cancelUploads = context.WithCancel(context.Background())
+	if err := m.setupStorage(name); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "bakku-control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	running := notification.Event{AbsolutePath: f.Name()}
+	changed := notification.Event{AbsolutePath: "/data/changed.txt"}
+
+	m.dispatch(running, name)
+	<-storage.started
+	if err := m.Drain(name); err != nil {
+		t.Fatal(err)
+	}
+	if state := m.stateOf(name); state != types.StateDraining {
+		t.Errorf("state while the upload is running = %s, want %s", state, types.StateDraining)
+	}
+	if !m.held(name, changed) {
+		t.Errorf("a draining storage has to hold changed files")
+	}
+
+	// the pause stops the running upload and holds the file
+	if err := m.Pause(name); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !m.isHeld(running.AbsolutePath, name) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !m.isHeld(running.AbsolutePath, name) {
+		t.Errorf("the stopped upload was not held")
+	}
+	if state, _ := db.Get(name, controlBucket); state != types.StatePaused {
+		t.Errorf("stored state = %q, want %q", state, types.StatePaused)
+	}
+
+	if err := m.Cancel(changed.AbsolutePath, name); err != nil {
+		t.Fatal(err)
+	}
+	if m.isHeld(changed.AbsolutePath, name) {
+		t.Errorf("a canceled file must not be held")
+	}
+
+	// the state and the canceled file are kept after a restart
+	restarted := &StorageManager{LocalSnapshotStorage: db, hold: newHoldQueue()}
+	restarted.loadControl()
+	if state := restarted.stateOf(name); state != types.StatePaused {
+		t.Errorf("state after the restart = %s, want %s", state, types.StatePaused)
+	}
+	if !restarted.skipCanceled(&changed, name) {
+		t.Errorf("the canceled file has to be skipped after the restart")
+	}
+}
+
+func (m *StorageManager) stateOf(storageName string) string {
+	m.hold.Lock()
+	defer m.hold.Unlock()
+	return m.hold.state(storageName)
+}
+
+func (m *StorageManager) isHeld(path, storageName string) bool {
+	m.hold.Lock()
+	defer m.hold.Unlock()
+	_, ok := m.hold.pending[storageName][path]
+	return ok
+}
@@ -1,9 +1,13 @@
 package fake
 
 import (
+	"context"
 	"crypto/sha1"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"path"
 	"time"
 
 	"github.com/glower/file-watcher/notification"
@@ -40,25 +44,34 @@ func (s *Storage) Setup(m *backup.StorageManager) (bool, error) {
 	return false, nil
 }
 
-// Store file on event
-func (s *Storage) Store(ev *notification.Event) error {
-	file := ev.AbsolutePath
-	data := []byte(file)
+// Store reads the upload slowly and fails randomly
+func (s *Storage) Store(ctx context.Context, upload *backup.Upload) error {
+	data := []byte(upload.Key)
 	p := 0.0
 	if rand.Intn(10) < 4 {
 		return fmt.Errorf("Random error")
 	}
+	if _, err := io.Copy(ioutil.Discard, upload.Reader); err != nil {
+		return err
+	}
 	for {
-		<-time.After(1 * time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(1 * time.Second):
+		}
 		// sleepRandom()
 		p = p + 1 + float64(rand.Intn(4))
-		s.fileStorageProgressCh <- types.BackupProgress{
-			AbsolutePath: file,
-			StorageName:  storageName,
-			FileName:     ev.FileName,
-			ID:           fmt.Sprintf("%x", sha1.Sum(data)),
-			Percent:      p,
+		progress := types.BackupProgress{
+			StorageName: storageName,
+			FileName:    path.Base(upload.Key),
+			ID:          fmt.Sprintf("%x", sha1.Sum(data)),
+			Percent:     p,
+		}
+		if upload.Event != nil {
+			progress.AbsolutePath = upload.Event.AbsolutePath
 		}
+		s.fileStorageProgressCh <- progress
 		if p >= float64(100.0) {
 			return nil
 		}
@@ -1,10 +1,13 @@
 package gdrive
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
-	"os"
+	"path"
 
+	"github.com/glower/bakku-app/pkg/backup"
 	drive "google.golang.org/api/drive/v3"
 )
 
@@ -36,7 +39,7 @@ func (s *Storage) FindFile(name, folderID string) (*drive.File, error) {
 }
 
 // CreateOrUpdateFile ...
-func (s *Storage) CreateOrUpdateFile(fromFile *os.File, fileName, mimeType, folderID string) (*drive.File, error) {
+func (s *Storage) CreateOrUpdateFile(ctx context.Context, fromFile io.Reader, fileName, mimeType, folderID string) (*drive.File, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
@@ -51,15 +54,56 @@ func (s *Storage) CreateOrUpdateFile(fromFile *os.File, fileName, mimeType, fold
 			MimeType: mimeType,
 			Parents:  []string{folderID},
 		}
-		file, err = s.service.Files.Create(f).Media(fromFile).Do()
+		file, err = s.service.Files.Create(f).Media(fromFile).Context(ctx).Do()
 		return file, err
 	}
 
 	if err == nil && file != nil {
 		// Media needs io.Reader so we can make progress from it
-		file, err = s.service.Files.Update(file.Id, nil).Media(fromFile).Do()
+		file, err = s.service.Files.Update(file.Id, nil).Media(fromFile).Context(ctx).Do()
 		return file, err
 	}
 
 	return nil, fmt.Errorf("cannot create or update file on GDrive: %s", fileName)
 }
+
+// List returns all files below the root folder with the MD5 checksums computed by Google Drive
+func (s *Storage) List(ctx context.Context) ([]backup.Object, error) {
+	var result []backup.Object
+	err := s.listFolder(ctx, s.root.Id, "", &result)
+	return result, err
+}
+
+func (s *Storage) listFolder(ctx context.Context, folderID, prefix string, result *[]backup.Object) error {
+	q := fmt.Sprintf("trashed = false and '%s' in parents", folderID)
+	pageToken := ""
+	for {
+		call := s.service.Files.List().Q(q).PageSize(1000).Context(ctx).
+			Fields("nextPageToken, files(id, name, mimeType, size, md5Checksum)")
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		list, err := call.Do()
+		if err != nil {
+			return err
+		}
+		for _, f := range list.Files {
+			key := path.Join(prefix, f.Name)
+			if f.MimeType == "application/vnd.google-apps.folder" {
+				if err := s.listFolder(ctx, f.Id, key, result); err != nil {
+					return err
+				}
+				continue
+			}
+			*result = append(*result, backup.Object{
+				Key:  key,
+				Size: f.Size,
+				MD5:  f.Md5Checksum,
+			})
+		}
+		if list.NextPageToken == "" {
+			return nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
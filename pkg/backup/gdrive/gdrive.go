@@ -6,9 +6,8 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/glower/bakku-app/pkg/backup"
@@ -23,8 +22,6 @@ import (
 
 // Storage ...
 type Storage struct {
-	ctx context.Context
-
 	name                  string // storage name
 	globalConfigPath      string
 	MessageCh             chan message.Message
@@ -48,7 +45,6 @@ func init() {
 func (s *Storage) Setup(m *backup.StorageManager) (bool, error) {
 	gdriveConfig := gdrive.GoogleDriveConfig()
 	if gdriveConfig.Active {
-		s.ctx = m.Ctx
 		s.eventCh = make(chan notification.Event)
 		s.fileStorageProgressCh = m.FileBackupProgressCh
 		s.MessageCh = m.MessageCh
@@ -97,50 +93,25 @@ func (s *Storage) Setup(m *backup.StorageManager) (bool, error) {
 }
 
 // Store ...
-func (s *Storage) Store(event *notification.Event) error {
-	to := remotePath(event.AbsolutePath, event.RelativePath)
-	return s.store(event.AbsolutePath, to, event.MimeType)
-}
-
-// gdrive.store(): C:\Users\Brown\MyFiles\pixiv\71738080_p0_master1200.jpg > MyFiles\pixiv
-func (s *Storage) store(file, toPath, mimeType string) error {
+func (s *Storage) Store(ctx context.Context, upload *backup.Upload) error {
 	sleepRandom()
-	// log.Printf("[DEBUG] gdrive.store(): send [%s] -> [%s]\n", file, filepath.Join(s.storagePath, toPath))
+	// log.Printf("[DEBUG] gdrive.store(): send [%s]\n", filepath.Join(s.storagePath, upload.Key))
 
-	fromFile, err := os.Open(file)
-	if err != nil {
-		return fmt.Errorf("cannot open file  [%s]: %v", file, err)
-	}
-	defer fromFile.Close()
-	lastFolder, err := s.GetOrCreateAllFolders(toPath)
+	lastFolder, err := s.GetOrCreateAllFolders(filepath.FromSlash(path.Dir(upload.Key)))
 	if err != nil {
 		return err
 	}
-	// if lastFolder == nil {
-	// 	return fmt.Errorf("foler was not found or created")
-	// }
-	// fmt.Printf("[DEBUG] Create of update file %s in folder %s\n", filepath.Base(file), lastFolder.Name)
-	_, err = s.CreateOrUpdateFile(fromFile, filepath.Base(file), mimeType, lastFolder.Id)
+	// fmt.Printf("[DEBUG] Create of update file %s in folder %s\n", path.Base(upload.Key), lastFolder.Name)
+	file, err := s.CreateOrUpdateFile(ctx, upload.Reader, path.Base(upload.Key), upload.MimeType, lastFolder.Id)
 	if err != nil {
 		return err
 	}
+	upload.RemoteID = file.Id
 
 	// log.Printf("[OK] gdrive.store(): %s, %s, %s DONE\n", gFile.Name, gFile.Id, gFile.MimeType)
 	return nil
 }
 
-func remotePath(absolutePath, relativePath string) string {
-	absolutePathArr := strings.Split(absolutePath, string(os.PathSeparator))
-	relativePathArr := strings.Split(relativePath, string(os.PathSeparator))
-	result := []string{}
-	for i, part := range absolutePathArr {
-		if part == string(relativePathArr[0]) {
-			result = absolutePathArr[i-1 : len(absolutePathArr)-1]
-		}
-	}
-	return strings.Join(result, string(os.PathSeparator))
-}
-
 func sleepRandom() {
 	r := 500000 + rand.Intn(2000000)
 	time.Sleep(time.Duration(r) * time.Microsecond)
@@ -0,0 +1,178 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+
+	conf "github.com/glower/bakku-app/pkg/config/storage"
+	"github.com/glower/bakku-app/pkg/message"
+	"github.com/glower/bakku-app/pkg/schedule"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+// holdPolicy decides when a storage is allowed to upload files
+type holdPolicy struct {
+	// quiet is a list of windows during which the queue of the storage is held
+	quiet []schedule.Window
+	// schedule is set when files are only uploaded on schedule
+	schedule *schedule.Schedule
+}
+
+// holdQueue keeps all file changes for storages that are not allowed to upload right now
+type holdQueue struct {
+	sync.Mutex
+	policies map[string]*holdPolicy
+	pending  map[string]map[string]notification.Event // storage name -> file path -> event
+	// paused storages hold all files until they are resumed
+	paused map[string]bool
+	// draining storages hold new files and are paused when their running uploads are finished
+	draining map[string]bool
+	// active is the number of dispatched files per storage which are waiting for a slot or uploading
+	active map[string]int
+	// canceled files are not uploaded until they change
+	canceled map[string]canceledFile
+	// prioritized files are uploaded even if their storage is paused
+	prioritized map[string]bool
+}
+
+func newHoldQueue() *holdQueue {
+	return &holdQueue{
+		policies:    make(map[string]*holdPolicy),
+		pending:     make(map[string]map[string]notification.Event),
+		paused:      make(map[string]bool),
+		draining:    make(map[string]bool),
+		active:      make(map[string]int),
+		canceled:    make(map[string]canceledFile),
+		prioritized: make(map[string]bool),
+	}
+}
+
+// setupHoldPolicy reads quiet hours and the upload schedule of a storage from the config
+func (m *StorageManager) setupHoldPolicy(name string) error {
+	c := conf.ProviderConf(strings.TrimPrefix(name, "storage."))
+	if c.Schedule == "" && len(c.QuietHours) == 0 {
+		return nil
+	}
+	p := &holdPolicy{}
+	var err error
+	if p.quiet, err = schedule.ParseWindows(c.QuietHours); err != nil {
+		return fmt.Errorf("invalid quiet hours: %v", err)
+	}
+	if c.Schedule != "" {
+		if p.schedule, err = schedule.Parse(c.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule: %v", err)
+		}
+	}
+
+	m.hold.Lock()
+	defer m.hold.Unlock()
+	m.hold.policies[name] = p
+	log.Printf("backup.setupHoldPolicy(): storage [%s] quiet hours %v, schedule [%s]\n", name, p.quiet, c.Schedule)
+	return nil
+}
+
+// held checks if the storage is not allowed to upload now, the event is queued in that case
+func (m *StorageManager) held(storageName string, event notification.Event) bool {
+	m.hold.Lock()
+	defer m.hold.Unlock()
+	if !m.hold.stopped(storageName) && !m.hold.holds(storageName, time.Now()) {
+		return false
+	}
+	m.hold.add(storageName, event)
+	return true
+}
+
+// add queues the event, the caller must hold the lock
+func (q *holdQueue) add(storageName string, event notification.Event) {
+	if q.pending[storageName] == nil {
+		q.pending[storageName] = make(map[string]notification.Event)
+	}
+	q.pending[storageName][event.AbsolutePath] = event
+}
+
+// Release uploads all held files of a storage, it's called by the scheduler
+func (m *StorageManager) Release(storageName string) {
+	m.hold.Lock()
+	if m.hold.stopped(storageName) {
+		m.hold.Unlock()
+		log.Printf("backup.Release(): storage [%s] is paused, skip release\n", storageName)
+		return
+	}
+	if p, ok := m.hold.policies[storageName]; ok && schedule.InAny(p.quiet, time.Now()) {
+		m.hold.Unlock()
+		log.Printf("backup.Release(): storage [%s] is in quiet hours, skip release\n", storageName)
+		return
+	}
+	pending := m.hold.pending[storageName]
+	delete(m.hold.pending, storageName)
+	m.hold.Unlock()
+
+	if _, ok := GetAll()[storageName]; !ok || len(pending) == 0 {
+		return
+	}
+	m.r.MessageCh <- message.FormatMessage("INFO", fmt.Sprintf("uploading %d held files", len(pending)), storageName)
+	for _, e := range pending {
+		m.dispatch(e, storageName)
+	}
+}
+
+// releaseAfterQuietHours uploads held files of storages without a schedule once their quiet hours are over
+func (m *StorageManager) releaseAfterQuietHours(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			var release []string
+			m.hold.Lock()
+			for name, p := range m.hold.policies {
+				if p.schedule == nil && len(m.hold.pending[name]) > 0 && !m.hold.stopped(name) && !schedule.InAny(p.quiet, now) {
+					release = append(release, name)
+				}
+			}
+			m.hold.Unlock()
+			for _, name := range release {
+				m.Release(name)
+			}
+		}
+	}
+}
+
+// stopped checks if the storage is paused or draining, the caller must hold the lock
+func (q *holdQueue) stopped(storageName string) bool {
+	return q.paused[storageName] || q.draining[storageName]
+}
+
+// holds checks if the policy of a storage doesn't allow uploads at the given time, the caller must hold the lock
+func (q *holdQueue) holds(storageName string, now time.Time) bool {
+	p, ok := q.policies[storageName]
+	if !ok {
+		return false
+	}
+	return p.schedule != nil || schedule.InAny(p.quiet, now)
+}
+
+// Queue returns all files which are uploaded right now, wait for a slot or are held
+func (m *StorageManager) Queue() []types.QueuedFile {
+	result := append(FilesInProgress(), m.waiting()...)
+	m.hold.Lock()
+	defer m.hold.Unlock()
+	for name, pending := range m.hold.pending {
+		for path := range pending {
+			result = append(result, types.QueuedFile{
+				Path:    path,
+				Storage: name,
+				State:   types.QueueHeld,
+			})
+		}
+	}
+	return result
+}
@@ -1,10 +1,15 @@
 package local
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"path/filepath"
 
 	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/checksum"
 
+	"github.com/glower/bakku-app/pkg/config"
 	conf "github.com/glower/bakku-app/pkg/config/storage"
 	"github.com/glower/bakku-app/pkg/types"
 
@@ -32,6 +37,9 @@ func init() {
 type StoreOptions struct {
 	reportProgress bool
 	fileID         string
+	// name is the path of the original file for progress reports
+	name    string
+	restore bool
 }
 
 // Setup local storage
@@ -49,18 +57,77 @@ func (s *Storage) Setup(m *backup.StorageManager) (bool, error) {
 }
 
 // Store stores a file to a local storage
-func (s *Storage) Store(event *notification.Event) error {
-	absolutePath := event.AbsolutePath
-	relativePath := event.RelativePath
-	directoryPath := event.DirectoryPath
+func (s *Storage) Store(ctx context.Context, upload *backup.Upload) error {
+	opt := StoreOptions{
+		reportProgress: upload.Event != nil,
+	}
+	if upload.Event != nil {
+		opt.fileID = upload.Event.UUID.String() // Or checksum?
+		opt.name = upload.Event.AbsolutePath
+	}
+	return s.store(ctx, upload.Reader, upload.Size, BackupPath(s.storagePath, upload.Key), opt)
+}
 
-	// fmt.Printf("\nlocal.Store():\n")
-	// fmt.Printf(">\tabsolutePath:\t%s\n>\trelativePath:\t%s\n>\tdirectoryPath:\t%s\n\n", absolutePath, relativePath, directoryPath)
+// Restore copies a backuped file back to the given path
+func (s *Storage) Restore(event *notification.Event, to string) error {
+	return Restore(s.storagePath, event, to)
+}
+
+// List returns all files in the storage directory, every file is hashed again to find corrupted copies
+func (s *Storage) List(ctx context.Context) ([]backup.Object, error) {
+	return List(ctx, s.storagePath)
+}
 
-	from := absolutePath
-	to := filepath.Join(s.storagePath, filepath.Base(directoryPath), relativePath)
-	return s.store(from, to, StoreOptions{
-		reportProgress: true,
-		fileID:         event.UUID.String(), // Or checksum?
+// List returns all files in the given storage directory with their checksums, it works without
+// a running storage manager and is used by the offline CLI
+func List(ctx context.Context, storagePath string) ([]backup.Object, error) {
+	filters := config.FileFilters()
+	algorithm := config.Current().Checksum.VerifyAlgorithm()
+	var result []backup.Object
+	err := filepath.Walk(storagePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() || config.Filtered(path, filters) {
+			return nil
+		}
+		rel, err := filepath.Rel(storagePath, path)
+		if err != nil {
+			return err
+		}
+		o := backup.Object{
+			Key:       filepath.ToSlash(rel),
+			Size:      info.Size(),
+			Algorithm: algorithm,
+		}
+		o.Checksum, _ = checksum.File(path, algorithm)
+		result = append(result, o)
+		return nil
 	})
+	return result, err
+}
+
+// BackupPath returns the path of a file in the storage directory for the upload key
+func BackupPath(storagePath, key string) string {
+	return filepath.Join(storagePath, filepath.FromSlash(key))
+}
+
+// Restore copies the backup copy of a file from the storage directory to the given path,
+// it works without a running storage manager and is used by the offline CLI
+func Restore(storagePath string, event *notification.Event, to string) error {
+	fromPath := BackupPath(storagePath, backup.Key(event))
+	from, err := os.Open(fromPath)
+	if err != nil {
+		return fmt.Errorf("cannot open file [%s]: %v", fromPath, err)
+	}
+	defer from.Close()
+	info, err := from.Stat()
+	if err != nil {
+		return err
+	}
+	s := &Storage{}
+	return s.store(context.Background(), from, info.Size(), to, StoreOptions{restore: true})
 }
@@ -0,0 +1,48 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glower/bakku-app/pkg/backup"
+)
+
+func TestStorage_Store(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakku-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := &Storage{storagePath: dir}
+
+	content := "hello backup"
+	err = s.Store(context.Background(), &backup.Upload{
+		Reader: strings.NewReader(content),
+		Size:   int64(len(content)),
+		Key:    "Documents/notes/todo.txt",
+	})
+	if err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "Documents", "notes", "todo.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("stored content = %q, want %q", got, content)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = s.Store(ctx, &backup.Upload{
+		Reader: strings.NewReader(content),
+		Key:    "Documents/canceled.txt",
+	})
+	if err != context.Canceled {
+		t.Errorf("Store() with canceled context error = %v, want %v", err, context.Canceled)
+	}
+}
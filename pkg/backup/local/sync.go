@@ -2,6 +2,7 @@ package local
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"math/rand"
@@ -12,26 +13,16 @@ import (
 	"github.com/glower/bakku-app/pkg/types"
 )
 
-func (s *Storage) store(fromPath, toPath string, opt StoreOptions) error {
-	// fmt.Printf("storage.local.store(): Copy file from [%s] to [%s]\n", fromPath, toPath)
-	from, err := os.Open(fromPath)
-	if err != nil {
-		return fmt.Errorf("cannot open file  [%s]: %v", fromPath, err)
-	}
-	defer from.Close()
-	fromStrats, err := from.Stat()
-	if err != nil {
-		return err
-	}
+func (s *Storage) store(ctx context.Context, from io.Reader, totalSize int64, toPath string, opt StoreOptions) error {
+	// fmt.Printf("storage.local.store(): Copy file to [%s]\n", toPath)
 	readBuffer := bufio.NewReader(from)
-	totalSize := fromStrats.Size()
 
 	fileStoragePath := filepath.Dir(toPath)
 	if err := os.MkdirAll(fileStoragePath, 0744); err != nil {
 		return fmt.Errorf("mkdirAll for path: [%s] err: %v", fileStoragePath, err)
 	}
 
-	to, err := os.OpenFile(toPath, os.O_RDWR|os.O_CREATE, 0644)
+	to, err := os.OpenFile(toPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("cannot open file [%s] to write: %v", toPath, err)
 	}
@@ -40,10 +31,13 @@ func (s *Storage) store(fromPath, toPath string, opt StoreOptions) error {
 
 	totalWritten := 0
 	buf := make([]byte, bufferSize)
-	if s.addLatency {
-		sleepRandom()
+	if s.addLatency && !opt.restore {
+		sleepRandom(ctx)
 	}
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		// read a chunk
 		n, err := readBuffer.Read(buf)
 		if err != nil && err != io.EOF {
@@ -61,7 +55,7 @@ func (s *Storage) store(fromPath, toPath string, opt StoreOptions) error {
 		totalWritten = totalWritten + written
 
 		if opt.reportProgress {
-			s.reportProgress(int64(written), int64(totalSize), int64(totalWritten), from.Name(), opt.fileID)
+			s.reportProgress(int64(written), totalSize, int64(totalWritten), opt.name, opt.fileID)
 		}
 	}
 
@@ -71,14 +65,17 @@ func (s *Storage) store(fromPath, toPath string, opt StoreOptions) error {
 	return nil
 }
 
-func sleepRandom() {
+func sleepRandom(ctx context.Context) {
 	r := 5 + rand.Intn(20)
-	time.Sleep(time.Duration(r) * time.Second)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Duration(r) * time.Second):
+	}
 }
 
 func (s *Storage) reportProgress(written, totalSize, totalWritten int64, name, id string) {
 	var percent float64
-	if int64(written) == totalSize {
+	if int64(written) == totalSize || totalSize <= 0 {
 		percent = float64(100)
 	} else {
 		percent = float64(100 * int64(totalWritten) / totalSize)
@@ -0,0 +1,61 @@
+package backup
+
+import (
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+	gometrics "github.com/rcrowley/go-metrics"
+
+	"github.com/glower/bakku-app/pkg/metrics"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+// shortName is the storage name used in metric labels, it's the same as in the config file
+func shortName(storageName string) string {
+	return strings.TrimPrefix(storageName, "storage.")
+}
+
+// queued counts a file which has to be uploaded to the storage, it's tracked until it's uploaded
+func queued(event *notification.Event, storageName string) {
+	metrics.Counter(metrics.FilesQueued, "storage", shortName(storageName)).Inc(1)
+	trackQueued(event, storageName)
+}
+
+// uploaded records a finished upload, the watched directory of a successful upload gets a new timestamp
+func uploaded(event *notification.Event, storageName string, started time.Time, err error) {
+	name := shortName(storageName)
+	if err != nil {
+		metrics.Counter(metrics.FilesFailed, "storage", name).Inc(1)
+		return
+	}
+	metrics.Counter(metrics.FilesUploaded, "storage", name).Inc(1)
+	metrics.Summary(metrics.UploadDuration, "storage", name).Observe(time.Since(started))
+	metrics.Gauge(metrics.LastBackup, "dir", event.DirectoryPath).Update(float64(time.Now().Unix()))
+}
+
+// collectMetrics updates the queue depth of all storages before the metrics are exported
+func (m *StorageManager) collectMetrics() {
+	for _, s := range m.Statuses() {
+		name := shortName(s.Name)
+		metrics.Gauge(metrics.QueueDepth, "storage", name, "state", types.QueueUploading).Update(float64(s.InProgress))
+		metrics.Gauge(metrics.QueueDepth, "storage", name, "state", types.QueueHeld).Update(float64(s.Held))
+	}
+}
+
+// countingReader counts the bytes which are read by a storage
+type countingReader struct {
+	r       io.Reader
+	counter gometrics.Counter
+	// sent are the bytes of this upload
+	sent *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.counter.Inc(int64(n))
+	atomic.AddInt64(c.sent, int64(n))
+	return n, err
+}
@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/config"
+	conf "github.com/glower/bakku-app/pkg/config/storage"
+	"github.com/glower/bakku-app/pkg/queue"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+// pool uploads the dispatched files of one storage, a slow storage doesn't block the others.
+// The files wait in the order of the queue policy for one of the workers.
+type pool struct {
+	sync.Mutex
+	queue *queue.Queue
+	// limit is the number of parallel uploads
+	limit int
+	// workers is the number of running workers, a worker stops when the queue is empty
+	workers int
+}
+
+// pool returns the pool of a storage, it's created with the first file
+func (m *StorageManager) pool(storageName string) *pool {
+	m.poolsM.Lock()
+	defer m.poolsM.Unlock()
+	if m.pools == nil {
+		m.pools = make(map[string]*pool)
+	}
+	p, ok := m.pools[storageName]
+	if !ok {
+		settings := config.Current()
+		p = &pool{
+			queue: queue.New(settings.Queue.PolicyName(), settings.QueueDirs()),
+			limit: concurrency(storageName),
+		}
+		m.pools[storageName] = p
+	}
+	return p
+}
+
+// concurrency returns the configured number of parallel uploads of a storage
+func concurrency(storageName string) int {
+	return conf.ProviderConf(strings.TrimPrefix(storageName, "storage.")).Concurrency
+}
+
+// setupPool applies the concurrency and the queue policy from the config, more workers are started
+// if the limit was raised
+func (m *StorageManager) setupPool(storageName string) {
+	p := m.pool(storageName)
+	settings := config.Current()
+	p.queue.Configure(settings.Queue.PolicyName(), settings.QueueDirs())
+	p.Lock()
+	defer p.Unlock()
+	p.limit = concurrency(storageName)
+	m.startWorkers(storageName, p)
+}
+
+// dispatch queues the file for an upload slot of the storage, during the shutdown the file is kept for the next start
+func (m *StorageManager) dispatch(event notification.Event, storageName string) {
+	m.stateM.Lock()
+	stopping := m.stopping
+	m.stateM.Unlock()
+	if stopping {
+		m.addUnfinished(event, storageName)
+		return
+	}
+	p := m.pool(storageName)
+	p.Lock()
+	defer p.Unlock()
+	// a file which is waiting already is only updated
+	if p.queue.Push(event) {
+		m.addActive(storageName, 1)
+	}
+	m.startWorkers(storageName, p)
+}
+
+// startWorkers starts workers up to the limit, the caller must hold the lock of the pool
+func (m *StorageManager) startWorkers(storageName string, p *pool) {
+	for p.workers < p.limit && p.workers < p.queue.Len() {
+		p.workers++
+		go m.work(storageName, p)
+	}
+}
+
+// work uploads the files of the pool until it's empty
+func (m *StorageManager) work(storageName string, p *pool) {
+	for {
+		p.Lock()
+		e, ok := m.next(p)
+		if !ok {
+			p.workers--
+			p.Unlock()
+			return
+		}
+		p.Unlock()
+		m.sendFileToStorage(&e, storageName)
+	}
+}
+
+// next takes the next file of the pool, it returns false if the worker has to stop because the limit was lowered
+// or the service is shutting down. The caller must hold the lock of the pool.
+func (m *StorageManager) next(p *pool) (notification.Event, bool) {
+	if p.workers > p.limit {
+		return notification.Event{}, false
+	}
+	m.stateM.Lock()
+	defer m.stateM.Unlock()
+	if m.stopping {
+		return notification.Event{}, false
+	}
+	e, ok := p.queue.Pop()
+	if ok {
+		m.inflight.Add(1)
+	}
+	return e, ok
+}
+
+// unqueue takes a waiting file out of the pool of a storage, it returns false if the file is not waiting
+func (m *StorageManager) unqueue(path, storageName string) bool {
+	if !m.pool(storageName).queue.Remove(path) {
+		return false
+	}
+	m.addActive(storageName, -1)
+	return true
+}
+
+// drainPools takes all waiting files out of the pools, they are uploaded on the next start
+func (m *StorageManager) drainPools() {
+	m.poolsM.Lock()
+	pools := make(map[string]*pool, len(m.pools))
+	for name, p := range m.pools {
+		pools[name] = p
+	}
+	m.poolsM.Unlock()
+	for name, p := range pools {
+		p.Lock()
+		for {
+			e, ok := p.queue.Pop()
+			if !ok {
+				break
+			}
+			m.addActive(name, -1)
+			m.addUnfinished(e, name)
+		}
+		p.Unlock()
+	}
+}
+
+// waiting returns the files which wait for an upload slot
+func (m *StorageManager) waiting() []types.QueuedFile {
+	m.poolsM.Lock()
+	defer m.poolsM.Unlock()
+	var result []types.QueuedFile
+	for name, p := range m.pools {
+		for _, path := range p.queue.Paths() {
+			result = append(result, types.QueuedFile{
+				Path:    path,
+				Storage: name,
+				State:   types.QueueWaiting,
+			})
+		}
+	}
+	return result
+}
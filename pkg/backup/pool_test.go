@@ -0,0 +1,148 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/message"
+	"github.com/glower/bakku-app/pkg/storage/memory"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+// countingStorage counts the parallel uploads, it waits for release before each upload returns
+type countingStorage struct {
+	release chan struct{}
+	running int32
+	max     int32
+}
+
+func (s *countingStorage) Setup(*StorageManager) (bool, error) { return true, nil }
+
+func (s *countingStorage) Store(ctx context.Context, _ *Upload) error {
+	n := atomic.AddInt32(&s.running, 1)
+	defer atomic.AddInt32(&s.running, -1)
+	for {
+		max := atomic.LoadInt32(&s.max)
+		if n <= max || atomic.CompareAndSwapInt32(&s.max, max, n) {
+			break
+		}
+	}
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func TestPool_SlowStorageDoesNotBlockOthers(t *testing.T) {
+	const files = 50
+	slow := &countingStorage{release: make(chan struct{})}
+	fast := &countingStorage{release: make(chan struct{})}
+	close(fast.release)
+	Register("storage.slow", slow)
+	Register("storage.fast", fast)
+	defer stopStorage("storage.slow")
+	defer stopStorage("storage.fast")
+
+	m := &StorageManager{
+		LocalSnapshotStorage: memory.New(),
+		r: &types.GlobalResources{
+			BackupCompleteCh: make(chan types.BackupComplete),
+			MessageCh:        make(chan message.Message),
+		},
+		hold:       newHoldQueue(),
+		stop:       make(chan struct{}),
+		unfinished: make(map[string]pendingFile),
+	}
+	m.uploadsCtx, m.cancelUploads = context.WithCancel(context.Background())
+	for _, name := range []string{"storage.slow", "storage.fast"} {
+		if err := m.setupStorage(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "bakku-pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var events []notification.Event
+	for i := 0; i < files; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file-%d.txt", i))
+		if err := ioutil.WriteFile(path, []byte(path), 0600); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, notification.Event{AbsolutePath: path, Size: int64(len(path))})
+	}
+
+	done := make(map[string]int)
+	fastDone := make(chan struct{})
+	allDone := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case c := <-m.r.BackupCompleteCh:
+				if !c.Success {
+					continue
+				}
+				done[c.StorageName]++
+				if done["storage.fast"] == files && c.StorageName == "storage.fast" {
+					close(fastDone)
+				}
+				if done["storage.fast"]+done["storage.slow"] == 2*files {
+					close(allDone)
+					return
+				}
+			case <-m.r.MessageCh:
+			}
+		}
+	}()
+
+	// files are dispatched and the queue is read from many goroutines at once
+	var wg sync.WaitGroup
+	for i := range events {
+		wg.Add(1)
+		go func(e notification.Event) {
+			defer wg.Done()
+			for _, name := range []string{"storage.slow", "storage.fast"} {
+				m.dispatch(e, name)
+			}
+			m.Queue()
+			TotalFilesInProgres()
+			InProgress(&e, "storage.slow")
+		}(events[i])
+	}
+	wg.Wait()
+
+	select {
+	case <-fastDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the fast storage is blocked by the slow one")
+	}
+	close(slow.release)
+	select {
+	case <-allDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("not all files were uploaded")
+	}
+
+	limit := int32(concurrency("storage.slow"))
+	for name, s := range map[string]*countingStorage{"storage.slow": slow, "storage.fast": fast} {
+		if max := atomic.LoadInt32(&s.max); max > limit {
+			t.Errorf("storage [%s] had %d parallel uploads, the limit is %d", name, max, limit)
+		}
+	}
+	if n := len(m.waiting()); n != 0 {
+		t.Errorf("%d files are still waiting", n)
+	}
+}
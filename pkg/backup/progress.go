@@ -1,21 +1,40 @@
 package backup
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/types"
 )
 
 var (
 	filesInProgressM sync.RWMutex
 	filesInProgress  = make(map[string]time.Time)
+	// cancels stop the running uploads, they use the lock of filesInProgress
+	cancels = make(map[string]*uploadCancel)
+)
+
+// uploadCancel stops one upload, reason is set when it was stopped by the user or a pause
+type uploadCancel struct {
+	cancel context.CancelFunc
+	reason string
+}
+
+// Reasons why an upload was stopped
+const (
+	canceledByUser  = "user"
+	canceledByPause = "pause"
 )
 
-// Start stores the information about files in progress
-func Start(fileChange *notification.Event, storage string) {
+// Start stores the information about files in progress, it returns false if the file is in progress already
+func Start(fileChange *notification.Event, storage string) bool {
 	file := fileChange.AbsolutePath
 	filesInProgressM.Lock()
 	defer filesInProgressM.Unlock()
@@ -24,16 +43,19 @@ func Start(fileChange *notification.Event, storage string) {
 	// TODO: find good strategy for this case
 	if _, dup := filesInProgress[key]; dup {
 		log.Printf("storage.Start(): file [%s] is in progress for the storage provider [%s]\n", file, storage)
-		return
+		return false
 	}
 
 	filesInProgress[key] = time.Now()
+	return true
 }
 
 // InProgress ...
 func InProgress(fileChange *notification.Event, storage string) bool {
 	file := fileChange.AbsolutePath
 	key := buildKey(file, storage)
+	filesInProgressM.RLock()
+	defer filesInProgressM.RUnlock()
 	if _, dup := filesInProgress[key]; dup {
 		log.Printf("storage.InProgress(): file [%s] is in progress for the storage provider [%s]\n", file, storage)
 		return true
@@ -50,11 +72,73 @@ func Finish(fileChange *notification.Event, storage string) {
 	delete(filesInProgress, key)
 }
 
+// setCancel registers the function which stops the upload of the file
+func setCancel(fileChange *notification.Event, storage string, cancel context.CancelFunc) {
+	filesInProgressM.Lock()
+	defer filesInProgressM.Unlock()
+	cancels[buildKey(fileChange.AbsolutePath, storage)] = &uploadCancel{cancel: cancel}
+}
+
+// clearCancel forgets the cancel function of an upload and returns why it was canceled, if it was
+func clearCancel(fileChange *notification.Event, storage string) string {
+	filesInProgressM.Lock()
+	defer filesInProgressM.Unlock()
+	key := buildKey(fileChange.AbsolutePath, storage)
+	c, ok := cancels[key]
+	delete(cancels, key)
+	if !ok {
+		return ""
+	}
+	return c.reason
+}
+
+// cancelUploads stops the running uploads whose key matches and returns their number
+func cancelUploads(match func(key string) bool, reason string) int {
+	filesInProgressM.Lock()
+	defer filesInProgressM.Unlock()
+	n := 0
+	for key, c := range cancels {
+		if match(key) {
+			c.reason = reason
+			c.cancel()
+			n++
+		}
+	}
+	return n
+}
+
 // TotalFilesInProgres returns total number of files in progress
 func TotalFilesInProgres() int {
+	filesInProgressM.RLock()
+	defer filesInProgressM.RUnlock()
 	return len(filesInProgress)
 }
 
+// FilesInProgress returns all files which are uploaded right now, oldest first
+func FilesInProgress() []types.QueuedFile {
+	filesInProgressM.RLock()
+	defer filesInProgressM.RUnlock()
+	result := make([]types.QueuedFile, 0, len(filesInProgress))
+	for key, started := range filesInProgress {
+		path, storage := splitKey(key)
+		result = append(result, types.QueuedFile{
+			Path:    path,
+			Storage: storage,
+			State:   types.QueueUploading,
+			Started: started,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Started.Before(result[j].Started) })
+	return result
+}
+
 func buildKey(file, storage string) string {
 	return fmt.Sprintf("%s:%s", file, storage)
 }
+
+// splitKey returns the file and the storage of a key, the file path can contain a colon on windows,
+// the storage name never does
+func splitKey(key string) (string, string) {
+	i := strings.LastIndex(key, ":")
+	return key[:i], key[i+1:]
+}
@@ -0,0 +1,187 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/checksum"
+	"github.com/glower/bakku-app/pkg/config"
+	"github.com/glower/bakku-app/pkg/storage"
+	"github.com/glower/bakku-app/pkg/storage/record"
+)
+
+// ReindexOptions control how the snapshot is rebuilt from a storage
+type ReindexOptions struct {
+	// DryRun only reports what would be changed
+	DryRun bool `json:"dryRun"`
+	// Overwrite replaces existing records, by default only missing records are added
+	Overwrite bool `json:"overwrite"`
+}
+
+// ReindexReport is the result of a reindex
+type ReindexReport struct {
+	Storage string `json:"storage"`
+	DryRun  bool   `json:"dryRun"`
+	Objects int    `json:"objects"`
+	Added   int    `json:"added"`
+	Updated int    `json:"updated"`
+	// Existing records are kept
+	Existing int `json:"existing"`
+	// Changed are objects whose local file differs, they are recorded without checksum and uploaded by the next scan
+	Changed int `json:"changed"`
+	// Unmatched are the first path elements of objects which don't belong to a watched directory
+	Unmatched []string `json:"unmatched"`
+}
+
+// Reindex lists a storage and rebuilds its snapshot records, a new installation can use existing
+// backups without uploading everything again
+func (m *StorageManager) Reindex(ctx context.Context, storageName string, opt ReindexOptions) (*ReindexReport, error) {
+	s, ok := GetAll()[storageName]
+	if !ok {
+		return nil, fmt.Errorf("storage [%s] is not active", storageName)
+	}
+	lister, ok := s.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("storage [%s] can't be listed", storageName)
+	}
+	return Reindex(ctx, m.LocalSnapshotStorage, storageName, lister, opt)
+}
+
+// Reindex rebuilds the snapshot records of a storage from its objects. The first element of an object key
+// is the name of the watched directory, objects of unknown directories are skipped.
+func Reindex(ctx context.Context, db storage.Storager, storageName string, lister Lister, opt ReindexOptions) (*ReindexReport, error) {
+	objects, err := lister.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't list the storage: %v", err)
+	}
+	dirs := make(map[string]string)
+	for _, d := range config.Current().DirsToWatch {
+		dirs[filepath.Base(filepath.Clean(d.Path))] = filepath.Clean(d.Path)
+	}
+	return rebuild(ctx, db, storageName, objects, dirs, opt)
+}
+
+// rebuild writes the records of all objects, dirs maps the names of the watched directories to their paths
+func rebuild(ctx context.Context, db storage.Storager, storageName string, objects []Object, dirs map[string]string, opt ReindexOptions) (*ReindexReport, error) {
+	r := &ReindexReport{
+		Storage:   storageName,
+		DryRun:    opt.DryRun,
+		Objects:   len(objects),
+		Unmatched: []string{},
+	}
+	unmatched := make(map[string]bool)
+	var records []*record.Record
+	var keys []string
+	for i := range objects {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		o := &objects[i]
+		parts := strings.SplitN(o.Key, "/", 2)
+		dir, ok := dirs[parts[0]]
+		if !ok || len(parts) < 2 {
+			unmatched[parts[0]] = true
+			continue
+		}
+		rec, changed := reindexRecord(o, dir, parts[1], storageName)
+		if changed {
+			r.Changed++
+		}
+		records = append(records, rec)
+		keys = append(keys, rec.Path)
+	}
+
+	existing, err := db.GetMany(storageName, keys)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte)
+	for _, rec := range records {
+		_, ok := existing[rec.Path]
+		switch {
+		case ok && !opt.Overwrite:
+			r.Existing++
+			continue
+		case ok:
+			r.Updated++
+		default:
+			r.Added++
+		}
+		value, err := rec.Encode()
+		if err != nil {
+			return nil, err
+		}
+		values[rec.Path] = value
+	}
+	if !opt.DryRun && len(values) > 0 {
+		if err := db.AddAll(storageName, values); err != nil {
+			return nil, err
+		}
+	}
+	for name := range unmatched {
+		r.Unmatched = append(r.Unmatched, name)
+	}
+	sort.Strings(r.Unmatched)
+	log.Printf("backup.Reindex(): [%s] objects=%d added=%d updated=%d existing=%d changed=%d unmatched=%d dryRun=%v\n",
+		storageName, r.Objects, r.Added, r.Updated, r.Existing, r.Changed, len(r.Unmatched), r.DryRun)
+	return r, nil
+}
+
+// reindexRecord returns the snapshot record of an object and if the local file is different. The checksum
+// of an existing local file is only set if the object is the same, otherwise the next scan uploads it.
+func reindexRecord(o *Object, dir, rel, storageName string) (*record.Record, bool) {
+	relativePath := filepath.FromSlash(rel)
+	e := &notification.Event{
+		FileName:      filepath.Base(relativePath),
+		Size:          o.Size,
+		DirectoryPath: dir,
+		AbsolutePath:  filepath.Join(dir, relativePath),
+		RelativePath:  relativePath,
+		Action:        notification.FileAdded,
+		Timestamp:     time.Now(),
+	}
+	algorithm := o.Algorithm
+	switch {
+	case o.Checksum == "":
+		// the storage only knows the MD5 checksum, the local file gets the checksum for change detection
+		algorithm = config.Current().Checksum.ChangeAlgorithm()
+	case algorithm == "":
+		algorithm = checksum.Default
+	}
+	result := func(changed bool) (*record.Record, bool) {
+		r := record.FromEvent(e, storageName)
+		r.Checksum.Algorithm = algorithm
+		return r, changed
+	}
+
+	info, err := os.Stat(e.AbsolutePath)
+	if err != nil {
+		// the file was not restored yet, the checksum of the storage is the best we have
+		e.Checksum = o.Checksum
+		return result(false)
+	}
+	if info.Size() != o.Size {
+		return result(true)
+	}
+	sum, err := checksum.File(e.AbsolutePath, algorithm)
+	if err != nil {
+		return result(true)
+	}
+	switch {
+	case o.Checksum != "" && o.Checksum == sum:
+		e.Checksum = sum
+	case o.Checksum == "" && o.MD5 != "":
+		if md5sum, err := md5File(e.AbsolutePath); err == nil && md5sum == o.MD5 {
+			e.Checksum = sum
+		}
+	}
+	return result(e.Checksum == "")
+}
@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/storage/memory"
+	"github.com/glower/bakku-app/pkg/storage/record"
+)
+
+func TestReindex(t *testing.T) {
+	const name = "storage.test"
+	dir, err := ioutil.TempDir("", "bakku-reindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	docs := filepath.Join(dir, "docs")
+	dirs := map[string]string{"docs": docs}
+
+	db := memory.New()
+	kept, _ := json.Marshal(notification.Event{AbsolutePath: filepath.Join(docs, "kept.txt"), Checksum: "old"})
+	db.Add(filepath.Join(docs, "kept.txt"), name, kept)
+
+	objects := []Object{
+		{Key: "docs/a/new.txt", Size: 3, Checksum: "abc"},
+		{Key: "docs/kept.txt", Size: 3, Checksum: "new"},
+		{Key: "music/song.mp3", Size: 3},
+	}
+
+	tests := []struct {
+		name         string
+		opt          ReindexOptions
+		wantAdded    int
+		wantUpdated  int
+		wantExisting int
+		wantChecksum string
+	}{
+		{
+			name:         "Scenario 1: dry run doesn't change the snapshot",
+			opt:          ReindexOptions{DryRun: true},
+			wantAdded:    1,
+			wantExisting: 1,
+			wantChecksum: "old",
+		},
+		{
+			name:         "Scenario 2: existing records are kept",
+			wantAdded:    1,
+			wantExisting: 1,
+			wantChecksum: "old",
+		},
+		{
+			name:         "Scenario 3: overwrite replaces existing records",
+			opt:          ReindexOptions{Overwrite: true},
+			wantUpdated:  2,
+			wantChecksum: "new",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := rebuild(context.Background(), db, name, objects, dirs, tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Added != tt.wantAdded || r.Updated != tt.wantUpdated || r.Existing != tt.wantExisting {
+				t.Errorf("Reindex() = %+v, want added=%d updated=%d existing=%d", r, tt.wantAdded, tt.wantUpdated, tt.wantExisting)
+			}
+			if len(r.Unmatched) != 1 || r.Unmatched[0] != "music" {
+				t.Errorf("Reindex() unmatched = %v, want [music]", r.Unmatched)
+			}
+			value, _ := db.Get(filepath.Join(docs, "kept.txt"), name)
+			kept, err := record.Decode(value, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept.Checksum.Value != tt.wantChecksum {
+				t.Errorf("checksum of kept.txt = %q, want %q", kept.Checksum.Value, tt.wantChecksum)
+			}
+		})
+	}
+}
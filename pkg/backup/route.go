@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"sync"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+// States of a storage a file was routed to
+const (
+	routeUploading = "uploading"
+	routeHeld      = "held"
+	routeFailed    = "failed"
+	routeDone      = "done"
+)
+
+// route are the storages a file from the buffer was sent to, the file is done when all of them have it
+type route struct {
+	size      int64
+	checksum  string
+	timestamp time.Time
+	storages  map[string]string
+	// attempt is set while the buffer waits for the results of the uploads it sent
+	attempt bool
+}
+
+// same checks if the event is the version of the file the route was made for
+func (r *route) same(e *notification.Event) bool {
+	return r.size == e.Size && r.checksum == e.Checksum && r.timestamp.Equal(e.Timestamp)
+}
+
+// result sets if the attempt is finished and if the file is done or held, a finished attempt is reported only once
+func (r *route) result(c *types.BackupComplete) {
+	count := make(map[string]int)
+	for _, state := range r.storages {
+		count[state]++
+	}
+	if count[routeUploading] > 0 {
+		return
+	}
+	c.Done = count[routeHeld] == 0 && count[routeFailed] == 0
+	c.Held = count[routeHeld] > 0 && count[routeFailed] == 0
+	c.Finished = r.attempt
+	r.attempt = false
+}
+
+var (
+	routesM sync.Mutex
+	routes  = make(map[string]*route)
+)
+
+// routeStorages returns the storages the file has to be uploaded to. A file which is sent again by the buffer
+// without a change is only uploaded to the storages which don't have it yet. The second value is false if
+// another attempt for an older version of the file was not finished yet.
+func routeStorages(e *notification.Event, active []string) ([]string, bool) {
+	routesM.Lock()
+	defer routesM.Unlock()
+	r, ok := routes[e.AbsolutePath]
+	replaced := ok && r.attempt && !r.same(e)
+	if !ok || !r.same(e) {
+		r = &route{size: e.Size, checksum: e.Checksum, timestamp: e.Timestamp, storages: make(map[string]string)}
+		routes[e.AbsolutePath] = r
+	}
+	r.attempt = true
+	// storages which were stopped meanwhile don't get the file anymore
+	isActive := make(map[string]bool, len(active))
+	for _, name := range active {
+		isActive[name] = true
+	}
+	for name := range r.storages {
+		if !isActive[name] {
+			delete(r.storages, name)
+		}
+	}
+	var result []string
+	for _, name := range active {
+		if r.storages[name] != routeDone {
+			r.storages[name] = routeUploading
+			result = append(result, name)
+		}
+	}
+	return result, !replaced
+}
+
+// uploadedTo returns true if this version of the file was uploaded to the storage
+func uploadedTo(e *notification.Event, storageName string) bool {
+	routesM.Lock()
+	defer routesM.Unlock()
+	r, ok := routes[e.AbsolutePath]
+	return ok && r.same(e) && r.storages[storageName] == routeDone
+}
+
+// setRoute changes the state of a storage of a routed file, an empty state removes the storage from the route.
+// The result of an upload of another version of the file is ignored if the event is given.
+func setRoute(path string, e *notification.Event, storageName, state string) types.BackupComplete {
+	routesM.Lock()
+	defer routesM.Unlock()
+	c := types.BackupComplete{FilePath: path}
+	r, ok := routes[path]
+	if !ok || (e != nil && !r.same(e)) {
+		return c
+	}
+	if _, routed := r.storages[storageName]; !routed {
+		return c
+	}
+	if state == "" {
+		delete(r.storages, storageName)
+	} else {
+		r.storages[storageName] = state
+	}
+	finishRoute(path, r, &c)
+	return c
+}
+
+// holdRoute marks a storage which holds the file while the file is routed, the attempt is finished by routed
+func holdRoute(path, storageName string) {
+	routesM.Lock()
+	defer routesM.Unlock()
+	if r, ok := routes[path]; ok && r.storages[storageName] == routeUploading {
+		r.storages[storageName] = routeHeld
+	}
+}
+
+// finishRoute sets the result of the route and forgets a done file, the caller must hold the lock
+func finishRoute(path string, r *route, c *types.BackupComplete) {
+	r.result(c)
+	if c.Done {
+		delete(routes, path)
+	}
+}
+
+// routed reports a file to the buffer whose attempt is finished without an upload, e.g. if all storages hold it
+func (m *StorageManager) routed(e *notification.Event) {
+	c := types.BackupComplete{FilePath: e.AbsolutePath}
+	routesM.Lock()
+	if r, ok := routes[e.AbsolutePath]; ok {
+		finishRoute(e.AbsolutePath, r, &c)
+	}
+	routesM.Unlock()
+	m.report(c)
+}
+
+// reroute changes the state of a storage of a routed file and reports it to the buffer if the file is finished
+func (m *StorageManager) reroute(path, storageName, state string) {
+	m.report(setRoute(path, nil, storageName, state))
+}
+
+// report tells the buffer about a file whose attempt is finished without the result of an upload
+func (m *StorageManager) report(c types.BackupComplete) {
+	if !c.Finished && !c.Done {
+		return
+	}
+	m.r.BackupCompleteCh <- c
+}
+
+// complete records the result of an upload and sends it to the buffer
+func (m *StorageManager) complete(e *notification.Event, storageName string, err error) {
+	state := routeDone
+	if err != nil {
+		state = routeFailed
+	}
+	c := setRoute(e.AbsolutePath, e, storageName, state)
+	c.Success = err == nil
+	c.StorageName = storageName
+	m.r.BackupCompleteCh <- c
+}
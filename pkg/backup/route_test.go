@@ -0,0 +1,200 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/message"
+	"github.com/glower/bakku-app/pkg/storage/memory"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+func TestRoute(t *testing.T) {
+	active := []string{"storage.a", "storage.b"}
+	type step struct {
+		storage string
+		state   string
+		want    types.BackupComplete
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		// resend are the storages the file is sent to again by the buffer, nil if it's done
+		resend []string
+	}{
+		{
+			name: "Scenario 1: the file is done when all storages have it",
+			steps: []step{
+				{"storage.a", routeDone, types.BackupComplete{}},
+				{"storage.b", routeDone, types.BackupComplete{Finished: true, Done: true}},
+			},
+		},
+		{
+			name: "Scenario 2: a failed storage gets the file again",
+			steps: []step{
+				{"storage.a", routeFailed, types.BackupComplete{}},
+				{"storage.b", routeDone, types.BackupComplete{Finished: true}},
+			},
+			resend: []string{"storage.a"},
+		},
+		{
+			name: "Scenario 3: a held file is done after the release",
+			steps: []step{
+				{"storage.a", routeHeld, types.BackupComplete{}},
+				{"storage.b", routeDone, types.BackupComplete{Finished: true, Held: true}},
+				{"storage.a", routeDone, types.BackupComplete{Done: true}},
+			},
+		},
+		{
+			name: "Scenario 4: a canceled storage is not waited for",
+			steps: []step{
+				{"storage.a", "", types.BackupComplete{}},
+				{"storage.b", routeDone, types.BackupComplete{Finished: true, Done: true}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := notification.Event{AbsolutePath: "/data/" + tt.name, Size: 1, Timestamp: time.Unix(1, 0)}
+			defer func() {
+				routesM.Lock()
+				delete(routes, e.AbsolutePath)
+				routesM.Unlock()
+			}()
+			if storages, ok := routeStorages(&e, active); !ok || len(storages) != len(active) {
+				t.Fatalf("routeStorages() = %v, %v", storages, ok)
+			}
+			for i, s := range tt.steps {
+				got := setRoute(e.AbsolutePath, &e, s.storage, s.state)
+				got.FilePath = ""
+				if got != s.want {
+					t.Errorf("step %d: setRoute(%s, %q) = %+v, want %+v", i, s.storage, s.state, got, s.want)
+				}
+			}
+			storages, _ := routeStorages(&e, active)
+			sort.Strings(storages)
+			if tt.resend != nil && !reflect.DeepEqual(storages, tt.resend) {
+				t.Errorf("the file is sent again to %v, want %v", storages, tt.resend)
+			}
+			if tt.resend == nil && len(storages) != len(active) {
+				t.Errorf("a done file is routed as new file to all storages, got %v", storages)
+			}
+		})
+	}
+
+	t.Run("Scenario 5: a changed file replaces the unfinished attempt", func(t *testing.T) {
+		e := notification.Event{AbsolutePath: "/data/changed.txt", Size: 1}
+		defer func() {
+			routesM.Lock()
+			delete(routes, e.AbsolutePath)
+			routesM.Unlock()
+		}()
+		routeStorages(&e, active)
+		old := e
+		e.Size = 2
+		if _, ok := routeStorages(&e, active); ok {
+			t.Errorf("the unfinished attempt has to be reported")
+		}
+		if c := setRoute(old.AbsolutePath, &old, "storage.a", routeDone); c.Finished || c.Done {
+			t.Errorf("the result of the old version must be ignored, got %+v", c)
+		}
+	})
+}
+
+// releaseStorage uploads until it's released or the context is canceled
+type releaseStorage struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *releaseStorage) Setup(*StorageManager) (bool, error) { return true, nil }
+
+func (s *releaseStorage) Store(ctx context.Context, _ *Upload) error {
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestRoute_StorageStoppedDuringUpload(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(m *StorageManager, storageName string)
+		want types.BackupComplete
+	}{
+		{
+			name: "Scenario 1: a reloaded storage finishes the upload",
+			stop: func(m *StorageManager, storageName string) {
+				if err := m.ReloadStorage(storageName); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: types.BackupComplete{Success: true, Finished: true, Done: true},
+		},
+		{
+			name: "Scenario 2: the file is sent again after the storage was stopped",
+			stop: func(m *StorageManager, storageName string) { stopStorage(storageName) },
+			want: types.BackupComplete{Finished: true},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := fmt.Sprintf("storage.reload%d", i)
+			storage := &releaseStorage{started: make(chan struct{}, 2), release: make(chan struct{})}
+			Register(name, storage)
+			defer stopStorage(name)
+			m := &StorageManager{
+				LocalSnapshotStorage: memory.New(),
+				r: &types.GlobalResources{
+					BackupCompleteCh: make(chan types.BackupComplete),
+					MessageCh:        make(chan message.Message, 10),
+				},
+				hold:       newHoldQueue(),
+				stop:       make(chan struct{}),
+				unfinished: make(map[string]pendingFile),
+			}
+			m.uploadsCtx, m.cancelUploads = context.WithCancel(context.Background())
+			if err := m.setupStorage(name); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := ioutil.TempFile("", "bakku-reload")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			defer os.Remove(f.Name())
+			e := notification.Event{AbsolutePath: f.Name(), Timestamp: time.Now()}
+			defer func() {
+				routesM.Lock()
+				delete(routes, e.AbsolutePath)
+				routesM.Unlock()
+			}()
+
+			m.route(e)
+			<-storage.started
+			tt.stop(m, name)
+			close(storage.release)
+			select {
+			case c := <-m.r.BackupCompleteCh:
+				c.FilePath, c.StorageName = "", ""
+				if c != tt.want {
+					t.Errorf("got %+v, want %+v", c, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("the buffer didn't get the result of the file")
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package backup
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/catalog"
+	"github.com/glower/bakku-app/pkg/checksum"
+	"github.com/glower/bakku-app/pkg/message"
+)
+
+// Possible states of a scrub job
+const (
+	ScrubRunning = "running"
+	ScrubDone    = "done"
+	ScrubFailed  = "failed"
+)
+
+// Object is a file in a storage
+type Object struct {
+	// Key is the same as the key of the upload
+	Key  string
+	Size int64
+	// Checksum is computed with Algorithm, it's empty if the storage can't compute it
+	Checksum  string
+	Algorithm string
+	// MD5 is the hex encoded MD5 checksum computed by the storage, e.g. by Google Drive
+	MD5 string
+}
+
+// Lister is implemented by storages which can list their objects, only these storages can be scrubbed
+type Lister interface {
+	List(ctx context.Context) ([]Object, error)
+}
+
+// ListerFunc is a function which can be used as Lister
+type ListerFunc func(ctx context.Context) ([]Object, error)
+
+// List calls f
+func (f ListerFunc) List(ctx context.Context) ([]Object, error) {
+	return f(ctx)
+}
+
+// Problem is a file which is missing, corrupted or unknown in a storage
+type Problem struct {
+	Path   string `json:"path,omitempty"`
+	Key    string `json:"key"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ScrubReport is the result of comparing all objects of a storage with the snapshot
+type ScrubReport struct {
+	Storage string `json:"storage"`
+	State   string `json:"state"`
+	Repair  bool   `json:"repair"`
+	Checked int    `json:"checked"`
+	// Unverified objects have the right size but their checksum can't be checked, e.g. because the local file was changed
+	Unverified int       `json:"unverified"`
+	Missing    []Problem `json:"missing"`
+	Corrupted  []Problem `json:"corrupted"`
+	// Extra objects are in the storage but not in the snapshot
+	Extra     []Problem `json:"extra"`
+	Repaired  int       `json:"repaired"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	// FinishedAt is nil while the job is running
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+var (
+	scrubsM sync.RWMutex
+	scrubs  = make(map[string]*ScrubReport)
+)
+
+// Scrub starts a job which compares all objects of a storage with the snapshot. With repair missing and
+// corrupted files are uploaded again if the local file still exists.
+func (m *StorageManager) Scrub(storageName string, repair bool) (*ScrubReport, error) {
+	s, ok := GetAll()[storageName]
+	if !ok {
+		return nil, fmt.Errorf("storage [%s] is not active", storageName)
+	}
+	lister, ok := s.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("storage [%s] can't be verified", storageName)
+	}
+
+	scrubsM.Lock()
+	defer scrubsM.Unlock()
+	if r, ok := scrubs[storageName]; ok && r.State == ScrubRunning {
+		return nil, fmt.Errorf("verification of [%s] is already running", storageName)
+	}
+	r := &ScrubReport{
+		Storage:   storageName,
+		State:     ScrubRunning,
+		Repair:    repair,
+		StartedAt: time.Now(),
+	}
+	scrubs[storageName] = r
+	go m.scrub(lister, *r)
+	result := *r
+	return &result, nil
+}
+
+// ScrubReports returns the report of the last scrub job of every storage
+func ScrubReports() []ScrubReport {
+	scrubsM.RLock()
+	defer scrubsM.RUnlock()
+	result := make([]ScrubReport, 0, len(scrubs))
+	for _, r := range scrubs {
+		result = append(result, *r)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Storage < result[j].Storage })
+	return result
+}
+
+func (m *StorageManager) scrub(lister Lister, r ScrubReport) {
+	storageName := r.Storage
+	// the job is canceled with the storage
+	ctx, _ := storageCtx(storageName)
+	broken, err := m.compare(ctx, lister, &r)
+	if err == nil && r.Repair {
+		r.Repaired = m.repair(storageName, broken)
+	}
+	finished := time.Now()
+	r.FinishedAt = &finished
+	r.State = ScrubDone
+	if err != nil {
+		r.State = ScrubFailed
+		r.Error = err.Error()
+	}
+
+	scrubsM.Lock()
+	scrubs[storageName] = &r
+	scrubsM.Unlock()
+
+	log.Printf("backup.scrub(): verification of [%s] %s: checked=%d missing=%d corrupted=%d extra=%d repaired=%d\n",
+		storageName, r.State, r.Checked, len(r.Missing), len(r.Corrupted), len(r.Extra), r.Repaired)
+	m.r.MessageCh <- scrubMessage(&r)
+}
+
+// compare lists the storage and compares every object with its snapshot record, it returns the
+// records of all missing and corrupted files
+func (m *StorageManager) compare(ctx context.Context, lister Lister, r *ScrubReport) ([]notification.Event, error) {
+	objects, err := lister.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't list the storage: %v", err)
+	}
+	entries, err := catalog.List(m.LocalSnapshotStorage, r.Storage, "")
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]Object, len(objects))
+	for _, o := range objects {
+		byKey[o.Key] = o
+	}
+	r.Missing, r.Corrupted, r.Extra = []Problem{}, []Problem{}, []Problem{}
+	var broken []notification.Event
+	for i := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		e := &entries[i]
+		key := Key(&e.Event)
+		o, ok := byKey[key]
+		delete(byKey, key)
+		if !ok {
+			r.Missing = append(r.Missing, Problem{Path: e.AbsolutePath, Key: key})
+			broken = append(broken, e.Event)
+			continue
+		}
+		r.Checked++
+		reason, verified := checkObject(&o, e)
+		switch {
+		case reason != "":
+			r.Corrupted = append(r.Corrupted, Problem{Path: e.AbsolutePath, Key: key, Reason: reason})
+			broken = append(broken, e.Event)
+		case !verified:
+			r.Unverified++
+		}
+	}
+	for key := range byKey {
+		r.Extra = append(r.Extra, Problem{Key: key})
+	}
+	sort.Slice(r.Extra, func(i, j int) bool { return r.Extra[i].Key < r.Extra[j].Key })
+	return broken, nil
+}
+
+// checkObject returns why the object doesn't match the snapshot record and if its content was verified
+func checkObject(o *Object, e *catalog.Entry) (string, bool) {
+	if o.Size != e.Size {
+		return fmt.Sprintf("size is %d, expected %d", o.Size, e.Size), true
+	}
+	if o.Checksum != "" {
+		algorithm := o.Algorithm
+		if algorithm == "" {
+			algorithm = checksum.Default
+		}
+		expected := e.ChecksumOf(algorithm)
+		switch {
+		case expected == "":
+			// the record was written with other algorithms, only the MD5 checksum can be checked
+		case o.Checksum != expected:
+			return fmt.Sprintf("%s checksum is [%s], expected [%s]", algorithm, o.Checksum, expected), true
+		default:
+			return "", true
+		}
+	}
+	if o.MD5 == "" {
+		return "", false
+	}
+	// the storage only knows the MD5 checksum, it can be compared with the local file as long as
+	// the local file is the backuped version
+	if sum, err := checksum.File(e.AbsolutePath, e.Algorithm); err != nil || sum != e.Checksum {
+		return "", false
+	}
+	sum, err := md5File(e.AbsolutePath)
+	if err != nil {
+		return "", false
+	}
+	if sum != o.MD5 {
+		return fmt.Sprintf("md5 is [%s], expected [%s]", o.MD5, sum), true
+	}
+	return "", true
+}
+
+func md5File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// repair uploads the files again if they still exist locally and returns the number of queued files
+func (m *StorageManager) repair(storageName string, events []notification.Event) int {
+	queuedFiles := 0
+	for _, e := range events {
+		if _, err := os.Stat(e.AbsolutePath); err != nil {
+			continue
+		}
+		queued(&e, storageName)
+		go m.dispatch(e, storageName)
+		queuedFiles++
+	}
+	return queuedFiles
+}
+
+func scrubMessage(r *ScrubReport) message.Message {
+	if r.State == ScrubFailed {
+		return message.FormatMessage("ERROR", fmt.Sprintf("verification failed: %s", r.Error), r.Storage)
+	}
+	msg := fmt.Sprintf("verification done: %d checked, %d missing, %d corrupted, %d extra, %d repaired",
+		r.Checked, len(r.Missing), len(r.Corrupted), len(r.Extra), r.Repaired)
+	if len(r.Missing)+len(r.Corrupted) > 0 {
+		return message.FormatMessage("ERROR", msg, r.Storage)
+	}
+	return message.FormatMessage("INFO", msg, r.Storage)
+}
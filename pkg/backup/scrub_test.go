@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/storage/memory"
+)
+
+// staticLister returns a fixed list of objects
+type staticLister []Object
+
+func (l staticLister) List(context.Context) ([]Object, error) { return l, nil }
+
+func TestStorageManager_compare(t *testing.T) {
+	const name = "storage.test"
+	db := memory.New()
+	for _, f := range []string{"ok.txt", "missing.txt", "size.txt", "checksum.txt", "changed.txt"} {
+		e := notification.Event{
+			AbsolutePath:  "/home/test/docs/" + f,
+			DirectoryPath: "/home/test/docs",
+			RelativePath:  f,
+			Size:          10,
+			Checksum:      "abc",
+		}
+		value, _ := json.Marshal(e)
+		db.Add(e.AbsolutePath, name, value)
+	}
+	objects := staticLister{
+		{Key: "docs/ok.txt", Size: 10, Checksum: "abc"},
+		{Key: "docs/size.txt", Size: 7, Checksum: "abc"},
+		{Key: "docs/checksum.txt", Size: 10, Checksum: "xyz"},
+		// the local file doesn't exist, the MD5 checksum can't be compared
+		{Key: "docs/changed.txt", Size: 10, MD5: "d41d8cd98f00b204e9800998ecf8427e"},
+		{Key: "docs/unknown.txt", Size: 1},
+	}
+
+	m := &StorageManager{LocalSnapshotStorage: db}
+	r := &ScrubReport{Storage: name}
+	broken, err := m.compare(context.Background(), objects, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  int
+		want int
+	}{
+		{"Scenario 1: all objects with a snapshot record are checked", r.Checked, 4},
+		{"Scenario 2: missing object", len(r.Missing), 1},
+		{"Scenario 3: wrong size and wrong checksum are corrupted", len(r.Corrupted), 2},
+		{"Scenario 4: object without a snapshot record", len(r.Extra), 1},
+		{"Scenario 5: MD5 of a changed local file can't be verified", r.Unverified, 1},
+		{"Scenario 6: missing and corrupted files are repaired", len(broken), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %d, want %d; report: %+v", tt.got, tt.want, r)
+			}
+		})
+	}
+}
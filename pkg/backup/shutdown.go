@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+)
+
+// pendingBucket is the name of the bucket in the snapshot database where unfinished uploads are stored
+const pendingBucket = "backup.pending"
+
+// cancelTimeout is how long Shutdown waits for uploads to return after they were canceled
+const cancelTimeout = 10 * time.Second
+
+// pendingFile is a file which still has to be uploaded to a storage
+type pendingFile struct {
+	Storage string             `json:"storage"`
+	Event   notification.Event `json:"event"`
+}
+
+// Shutdown stops dispatching new files and waits for running uploads until the timeout, after it the
+// uploads are canceled. All files which were not uploaded are stored and uploaded on the next start.
+// An error is returned if uploads had to be canceled or didn't stop.
+func (m *StorageManager) Shutdown(timeout time.Duration) error {
+	m.stateM.Lock()
+	if m.stopping {
+		m.stateM.Unlock()
+		return fmt.Errorf("shutdown is already in progress")
+	}
+	m.stopping = true
+	close(m.stop)
+	m.stateM.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.inflight.Wait()
+		close(done)
+	}()
+
+	var result error
+	select {
+	case <-done:
+		log.Println("backup.Shutdown(): all uploads are finished")
+	case <-time.After(timeout):
+		log.Printf("backup.Shutdown(): %d uploads are still running after %s, cancel them\n", TotalFilesInProgres(), timeout)
+		m.cancelUploads()
+		result = fmt.Errorf("running uploads were canceled after %s", timeout)
+		select {
+		case <-done:
+		case <-time.After(cancelTimeout):
+			result = fmt.Errorf("%d uploads didn't stop after they were canceled", TotalFilesInProgres())
+		}
+	}
+
+	// files which the buffer didn't send or which were waiting for a free upload slot or are held
+	m.drainBuffer()
+	m.drainEvents()
+	m.drainPools()
+	m.hold.Lock()
+	for name, pending := range m.hold.pending {
+		for _, e := range pending {
+			m.addUnfinishedLocked(e, name)
+		}
+	}
+	m.hold.pending = make(map[string]map[string]notification.Event)
+	m.hold.Unlock()
+
+	if err := m.persistUnfinished(); err != nil {
+		log.Printf("[ERROR] backup.Shutdown(): can't store unfinished uploads: %v\n", err)
+		if result == nil {
+			result = err
+		}
+	}
+	teardownAll()
+	return result
+}
+
+// drainEvents takes all events which were not dispatched yet, they are uploaded to all active storages on the next start
+func (m *StorageManager) drainEvents() {
+	for {
+		select {
+		case e := <-m.EventCh:
+			for name := range GetAll() {
+				m.addUnfinished(e, name)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// drainBuffer stops the buffer and takes its files, they are uploaded on the next start to all active storages
+// which don't have them yet
+func (m *StorageManager) drainBuffer() {
+	if m.buffer == nil {
+		return
+	}
+	for _, e := range m.buffer.Stop() {
+		for name := range GetAll() {
+			if !uploadedTo(&e, name) {
+				m.addUnfinished(e, name)
+			}
+		}
+	}
+}
+
+func (m *StorageManager) addUnfinished(event notification.Event, storageName string) {
+	m.hold.Lock()
+	defer m.hold.Unlock()
+	m.addUnfinishedLocked(event, storageName)
+}
+
+// addUnfinishedLocked uses the lock of the hold queue, the caller must hold it
+func (m *StorageManager) addUnfinishedLocked(event notification.Event, storageName string) {
+	m.unfinished[buildKey(event.AbsolutePath, storageName)] = pendingFile{
+		Storage: storageName,
+		Event:   event,
+	}
+}
+
+func (m *StorageManager) persistUnfinished() error {
+	m.hold.Lock()
+	defer m.hold.Unlock()
+	for key, p := range m.unfinished {
+		value, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		if err := m.LocalSnapshotStorage.Add(key, pendingBucket, value); err != nil {
+			return err
+		}
+	}
+	if len(m.unfinished) > 0 {
+		log.Printf("backup.persistUnfinished(): %d unfinished uploads are stored for the next start\n", len(m.unfinished))
+	}
+	return nil
+}
+
+// requeuePending uploads all files which were not finished before the last shutdown
+func (m *StorageManager) requeuePending() {
+	all, err := m.LocalSnapshotStorage.GetAll(pendingBucket)
+	if err != nil || len(all) == 0 {
+		// the bucket doesn't exist if the last shutdown was clean
+		return
+	}
+	log.Printf("backup.requeuePending(): %d unfinished uploads from the last run\n", len(all))
+	for key, value := range all {
+		if err := m.LocalSnapshotStorage.Remove(key, pendingBucket); err != nil {
+			log.Printf("[ERROR] backup.requeuePending(): %v\n", err)
+		}
+		p := pendingFile{}
+		if err := json.Unmarshal([]byte(value), &p); err != nil {
+			log.Printf("[ERROR] backup.requeuePending(): invalid entry [%s]: %v\n", key, err)
+			continue
+		}
+		if _, ok := GetAll()[p.Storage]; !ok {
+			continue
+		}
+		queued(&p.Event, p.Storage)
+		if m.held(p.Storage, p.Event) {
+			continue
+		}
+		m.dispatch(p.Event, p.Storage)
+	}
+}
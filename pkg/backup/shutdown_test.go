@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+	"github.com/glower/file-watcher/watcher"
+
+	"github.com/glower/bakku-app/pkg/event"
+	"github.com/glower/bakku-app/pkg/message"
+	"github.com/glower/bakku-app/pkg/storage/memory"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+// blockingStorage uploads until the context is canceled
+type blockingStorage struct {
+	started chan struct{}
+}
+
+func (s *blockingStorage) Setup(*StorageManager) (bool, error) { return true, nil }
+
+func (s *blockingStorage) Store(ctx context.Context, _ *Upload) error {
+	close(s.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestShutdown_CancelAndPersist(t *testing.T) {
+	const name = "storage.blocking"
+	storage := &blockingStorage{started: make(chan struct{})}
+	Register(name, storage)
+	defer stopStorage(name)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fileWatcher := &watcher.Watch{EventCh: make(chan notification.Event)}
+	buffer := event.NewBuffer(ctx, &types.GlobalResources{FileWatcher: fileWatcher})
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-buffer.BackupStatusCh:
+			}
+		}
+	}()
+
+	db := memory.New()
+	m := &StorageManager{
+		EventCh:              make(chan notification.Event, 1),
+		LocalSnapshotStorage: db,
+		r: &types.GlobalResources{
+			BackupCompleteCh: make(chan types.BackupComplete),
+			MessageCh:        make(chan message.Message),
+		},
+		buffer:     buffer,
+		hold:       newHoldQueue(),
+		stop:       make(chan struct{}),
+		unfinished: make(map[string]pendingFile),
+	}
+	m.uploadsCtx, m.cancelUploads = context.WithCancel(context.Background())
+	if err := m.setupStorage(name); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "bakku-shutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	m.dispatch(notification.Event{AbsolutePath: f.Name()}, name)
+	<-storage.started
+	// this file is not dispatched yet and has to be stored as well
+	m.EventCh <- notification.Event{AbsolutePath: "/data/b.txt"}
+	// this file is still in the buffer
+	fileWatcher.EventCh <- notification.Event{AbsolutePath: "/data/c.txt"}
+
+	if err := m.Shutdown(50 * time.Millisecond); err == nil {
+		t.Errorf("Shutdown() expected an error for canceled uploads")
+	}
+
+	pending, _ := db.GetAll(pendingBucket)
+	for _, file := range []string{f.Name(), "/data/b.txt", "/data/c.txt"} {
+		if _, ok := pending[buildKey(file, name)]; !ok {
+			t.Errorf("file [%s] was not stored as pending, have: %v", file, pending)
+		}
+	}
+}
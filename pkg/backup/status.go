@@ -0,0 +1,332 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/config"
+	"github.com/glower/bakku-app/pkg/config/watchdir"
+	"github.com/glower/bakku-app/pkg/event"
+	"github.com/glower/bakku-app/pkg/history"
+	"github.com/glower/bakku-app/pkg/ratelimit"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+// Reasons why a storage doesn't upload right now
+const (
+	ThrottledPaused     = "paused"
+	ThrottledDraining   = "draining"
+	ThrottledSchedule   = "schedule"
+	ThrottledQuietHours = "quiet hours"
+	ThrottledErrors     = "errors"
+)
+
+// trackedFile is a file which was queued for a storage and is not backuped yet
+type trackedFile struct {
+	path, dir, storage string
+	size               int64
+	uploading          bool
+	// sent is the number of bytes read by the storage, it's updated while uploading
+	sent int64
+}
+
+// outcome are the results of the finished uploads of a storage or a watched directory
+type outcome struct {
+	// failed are the events of the files whose last upload failed, they can be retried
+	failed        map[string]notification.Event
+	lastBackup    time.Time
+	lastError     string
+	lastErrorTime time.Time
+}
+
+var (
+	trackedM sync.Mutex
+	tracked  = make(map[string]*trackedFile)
+	// outcomes of the storages and of the watched directories
+	storageOutcomes = make(map[string]*outcome)
+	dirOutcomes     = make(map[string]*outcome)
+	// completedBytes are the bytes of the files uploaded since the queue was empty the last time
+	completedBytes int64
+	// lastBackupsOnce reads the last successful uploads from the history when the status is read the first time
+	lastBackupsOnce sync.Once
+)
+
+// trackQueued remembers a file until it is uploaded, a file which is uploaded right now is not queued again
+func trackQueued(e *notification.Event, storageName string) {
+	trackedM.Lock()
+	defer trackedM.Unlock()
+	key := buildKey(e.AbsolutePath, storageName)
+	if f, ok := tracked[key]; ok && f.uploading {
+		return
+	}
+	if len(tracked) == 0 {
+		completedBytes = 0
+	}
+	tracked[key] = &trackedFile{
+		path:    e.AbsolutePath,
+		dir:     e.DirectoryPath,
+		storage: storageName,
+		size:    e.Size,
+	}
+}
+
+// trackStarted marks a file as uploading, the storage has to add the bytes it read to the returned counter
+func trackStarted(e *notification.Event, storageName string, size int64) *int64 {
+	trackedM.Lock()
+	defer trackedM.Unlock()
+	key := buildKey(e.AbsolutePath, storageName)
+	f, ok := tracked[key]
+	if !ok {
+		f = &trackedFile{path: e.AbsolutePath, dir: e.DirectoryPath, storage: storageName}
+		tracked[key] = f
+	}
+	f.uploading = true
+	f.size = size
+	return &f.sent
+}
+
+// trackFinished forgets a file, records the result of its upload and publishes it as completion event
+func trackFinished(e *notification.Event, storageName string, err error) {
+	c := types.BackupComplete{
+		Success:            err == nil,
+		StorageName:        storageName,
+		FilePath:           e.AbsolutePath,
+		WatchDirectoryName: e.DirectoryPath,
+	}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	event.Publish(event.TypeCompletion, storageName, e.AbsolutePath, c)
+
+	trackedM.Lock()
+	defer trackedM.Unlock()
+	key := buildKey(e.AbsolutePath, storageName)
+	if f, ok := tracked[key]; ok && err == nil {
+		completedBytes += f.size
+	}
+	delete(tracked, key)
+	now := time.Now()
+	for _, o := range []*outcome{outcomeOf(storageOutcomes, storageName), outcomeOf(dirOutcomes, filepath.Clean(e.DirectoryPath))} {
+		if err != nil {
+			o.failed[e.AbsolutePath] = *e
+			o.lastError = err.Error()
+			o.lastErrorTime = now
+			continue
+		}
+		delete(o.failed, e.AbsolutePath)
+		o.lastBackup = now
+	}
+}
+
+// queueBytes returns the bytes uploaded since the queue was empty, the bytes left of all queued files and
+// the number of active storages
+func queueBytes() (int64, int64, int) {
+	trackedM.Lock()
+	defer trackedM.Unlock()
+	done, left := completedBytes, int64(0)
+	for _, f := range tracked {
+		sent := atomic.LoadInt64(&f.sent)
+		if !f.uploading {
+			sent = 0
+		}
+		done += sent
+		if f.size > sent {
+			left += f.size - sent
+		}
+	}
+	return done, left, len(GetAll())
+}
+
+// isTracked checks if a file is queued or uploading for the storage
+func isTracked(path, storageName string) bool {
+	trackedM.Lock()
+	defer trackedM.Unlock()
+	_, ok := tracked[buildKey(path, storageName)]
+	return ok
+}
+
+// untrack forgets a file without a result, e.g. if the upload was canceled
+func untrack(e *notification.Event, storageName string) {
+	trackedM.Lock()
+	defer trackedM.Unlock()
+	delete(tracked, buildKey(e.AbsolutePath, storageName))
+}
+
+// failedEvent returns the event of a file whose last upload to the storage failed
+func failedEvent(path, storageName string) (notification.Event, bool) {
+	trackedM.Lock()
+	defer trackedM.Unlock()
+	o, ok := storageOutcomes[storageName]
+	if !ok {
+		return notification.Event{}, false
+	}
+	e, ok := o.failed[path]
+	return e, ok
+}
+
+// Retry uploads a file again whose last upload to the storage failed
+func (m *StorageManager) Retry(path, storageName string) error {
+	if _, ok := GetAll()[storageName]; !ok {
+		return fmt.Errorf("storage [%s] is not active", storageName)
+	}
+	e, ok := failedEvent(path, storageName)
+	if !ok {
+		return fmt.Errorf("the last upload of [%s] to [%s] didn't fail", path, storageName)
+	}
+	if _, err := os.Stat(e.AbsolutePath); err != nil {
+		return err
+	}
+	m.uncancel(buildKey(path, storageName))
+	queued(&e, storageName)
+	if !m.held(storageName, e) {
+		go m.dispatch(e, storageName)
+	}
+	return nil
+}
+
+// outcomeOf returns the outcome with the name, the caller must hold the lock
+func outcomeOf(outcomes map[string]*outcome, name string) *outcome {
+	o, ok := outcomes[name]
+	if !ok {
+		o = &outcome{failed: make(map[string]notification.Event)}
+		outcomes[name] = o
+	}
+	return o
+}
+
+// readLastBackups sets the time of the last successful upload of every storage and watched directory
+// from the history, so it's known after a restart
+func readLastBackups() {
+	page, err := history.Default().Query(history.Filter{Action: history.ActionStore, Result: history.ResultOK})
+	if err != nil {
+		return
+	}
+	dirs := config.WatchedDirs()
+	trackedM.Lock()
+	defer trackedM.Unlock()
+	// the records are sorted by time, the newest first
+	for _, r := range page.Records {
+		if o := outcomeOf(storageOutcomes, r.Storage); o.lastBackup.IsZero() {
+			o.lastBackup = r.Time
+		}
+		if dir := watchdir.Of(dirs, r.File); dir != "" {
+			if o := outcomeOf(dirOutcomes, dir); o.lastBackup.IsZero() {
+				o.lastBackup = r.Time
+			}
+		}
+	}
+}
+
+// uploadStats counts the tracked files which match, the ETA is computed with the throughput in bytes per second
+func uploadStats(match func(*trackedFile) bool, o *outcome, throughput int64) types.UploadStats {
+	s := types.UploadStats{Throughput: throughput}
+	for _, f := range tracked {
+		if !match(f) {
+			continue
+		}
+		if f.uploading {
+			s.InProgress++
+			if left := f.size - atomic.LoadInt64(&f.sent); left > 0 {
+				s.BytesPending += left
+			}
+			continue
+		}
+		s.Pending++
+		s.BytesPending += f.size
+	}
+	if throughput > 0 {
+		s.ETA = (s.BytesPending + throughput - 1) / throughput
+	}
+	if o == nil {
+		return s
+	}
+	s.Failed = len(o.failed)
+	s.LastError = o.lastError
+	if !o.lastBackup.IsZero() {
+		t := o.lastBackup
+		s.LastBackup = &t
+	}
+	if !o.lastErrorTime.IsZero() {
+		t := o.lastErrorTime
+		s.LastErrorTime = &t
+	}
+	return s
+}
+
+// Statuses returns the state of all implemented storages
+func (m *StorageManager) Statuses() []types.StorageStatus {
+	lastBackupsOnce.Do(readLastBackups)
+	active := GetAll()
+	bandwidth := make(map[string]ratelimit.Status)
+	for _, s := range ratelimit.Statuses() {
+		bandwidth[s.Name] = s
+	}
+	pause := event.ThrottlePause()
+	now := time.Now()
+
+	m.hold.Lock()
+	defer m.hold.Unlock()
+	trackedM.Lock()
+	defer trackedM.Unlock()
+	var result []types.StorageStatus
+	for name := range Registered() {
+		_, ok := active[name]
+		s := types.StorageStatus{
+			Name:   name,
+			Active: ok,
+			Paused: m.hold.paused[name],
+			State:  m.hold.state(name),
+			Held:   len(m.hold.pending[name]),
+			Limit:  bandwidth[name].Limit,
+		}
+		s.UploadStats = uploadStats(func(f *trackedFile) bool { return f.storage == name }, storageOutcomes[name], bandwidth[name].Throughput)
+		switch p := m.hold.policies[name]; {
+		case s.Paused:
+			s.Throttled = ThrottledPaused
+		case s.State == types.StateDraining:
+			s.Throttled = ThrottledDraining
+		case p != nil && p.schedule != nil:
+			s.Throttled = ThrottledSchedule
+		case m.hold.holds(name, now):
+			s.Throttled = ThrottledQuietHours
+		case pause > 0:
+			s.Throttled = ThrottledErrors
+			s.ThrottlePause = int64(pause / time.Second)
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// DirStatuses returns the state of the backups of all watched directories
+func (m *StorageManager) DirStatuses() []types.DirStatus {
+	lastBackupsOnce.Do(readLastBackups)
+	throughput := ratelimit.Throughput()
+
+	trackedM.Lock()
+	defer trackedM.Unlock()
+	result := []types.DirStatus{}
+	for _, d := range config.Current().DirsToWatch {
+		dir := filepath.Clean(d.Path)
+		s := types.DirStatus{
+			Path:     dir,
+			Active:   d.Active,
+			ScanOnly: d.ScanOnly,
+			Missing:  d.Active && config.DirAvailable(d.Path) != nil,
+		}
+		s.UploadStats = uploadStats(func(f *trackedFile) bool {
+			return filepath.Clean(f.dir) == dir || watchdir.Contains(dir, f.path)
+		}, dirOutcomes[dir], throughput)
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
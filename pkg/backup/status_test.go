@@ -0,0 +1,61 @@
+package backup
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/glower/file-watcher/notification"
+)
+
+func TestUploadStats(t *testing.T) {
+	const name = "storage.test"
+	a := &notification.Event{AbsolutePath: "/docs/a.txt", DirectoryPath: "/docs", Size: 100}
+	b := &notification.Event{AbsolutePath: "/docs/b.txt", DirectoryPath: "/docs", Size: 300}
+	c := &notification.Event{AbsolutePath: "/docs/c.txt", DirectoryPath: "/docs", Size: 50}
+
+	trackQueued(a, name)
+	trackQueued(b, name)
+	trackQueued(c, name)
+	sent := trackStarted(b, name, 300)
+	atomic.AddInt64(sent, 100)
+	trackFinished(c, name, fmt.Errorf("quota exceeded"))
+	defer func() {
+		untrack(a, name)
+		untrack(b, name)
+	}()
+
+	tests := []struct {
+		name             string
+		throughput       int64
+		wantBytesPending int64
+		wantETA          int64
+	}{
+		{
+			name:             "Scenario 1: no upload, no ETA",
+			wantBytesPending: 300,
+		},
+		{
+			name:             "Scenario 2: the ETA is rounded up",
+			throughput:       200,
+			wantBytesPending: 300,
+			wantETA:          2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trackedM.Lock()
+			s := uploadStats(func(f *trackedFile) bool { return f.storage == name }, storageOutcomes[name], tt.throughput)
+			trackedM.Unlock()
+			if s.Pending != 1 || s.InProgress != 1 || s.Failed != 1 {
+				t.Errorf("pending=%d inProgress=%d failed=%d, want 1 each", s.Pending, s.InProgress, s.Failed)
+			}
+			if s.BytesPending != tt.wantBytesPending || s.ETA != tt.wantETA {
+				t.Errorf("bytesPending=%d eta=%d, want %d and %d", s.BytesPending, s.ETA, tt.wantBytesPending, tt.wantETA)
+			}
+			if s.LastError != "quota exceeded" || s.LastErrorTime == nil {
+				t.Errorf("last error = %q at %v", s.LastError, s.LastErrorTime)
+			}
+		})
+	}
+}
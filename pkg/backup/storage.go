@@ -1,9 +1,13 @@
 package backup
 
 import (
+	"fmt"
+	"strings"
 	"sync"
 
 	"log"
+
+	"github.com/glower/file-watcher/notification"
 )
 
 const defultFolderName = "bakku-app"
@@ -15,7 +19,8 @@ func DefultFolderName() string {
 
 var (
 	storagesM sync.RWMutex
-	storages  = make(map[string]Storage)
+	storages  = make(map[string]Storage) // all implemented storages
+	active    = make(map[string]Storage) // storages which are configured and ready
 )
 
 // Register a storage implementation by name.
@@ -38,16 +43,63 @@ func Register(name string, s Storage) {
 
 	log.Printf("storage.Register(): storage provider [%s] registered\n", name)
 	storages[name] = s
+	active[name] = s
 }
 
-// GetAll returns a map of all registered backup storages
+// GetAll returns a map of all active backup storages
 func GetAll() map[string]Storage {
-	return storages
+	storagesM.RLock()
+	defer storagesM.RUnlock()
+	result := make(map[string]Storage, len(active))
+	for name, s := range active {
+		result[name] = s
+	}
+	return result
+}
+
+// Registered returns a map of all implemented backup storages, active or not
+func Registered() map[string]Storage {
+	storagesM.RLock()
+	defer storagesM.RUnlock()
+	result := make(map[string]Storage, len(storages))
+	for name, s := range storages {
+		result[name] = s
+	}
+	return result
 }
 
-// Unregister ...
+// Unregister removes a storage from the active storages, it can be activated again later
 func Unregister(name string) {
 	storagesM.Lock()
 	defer storagesM.Unlock()
-	delete(storages, name)
+	delete(active, name)
+}
+
+func activate(name string) {
+	storagesM.Lock()
+	defer storagesM.Unlock()
+	if s, ok := storages[name]; ok {
+		active[name] = s
+	}
+}
+
+// StorageName returns the full name of a storage, "local" and "storage.local" are the same storage
+func StorageName(name string) string {
+	if strings.HasPrefix(name, "storage.") {
+		return name
+	}
+	return "storage." + name
+}
+
+// Restore copies a backuped file from an active storage to the given path
+func Restore(storageName string, event *notification.Event, to string) error {
+	s, ok := GetAll()[storageName]
+	if !ok {
+		return fmt.Errorf("storage [%s] is not active", storageName)
+	}
+	r, ok := s.(Restorer)
+	if !ok {
+		return fmt.Errorf("storage [%s] doesn't support restore", storageName)
+	}
+	return r.Restore(event, to)
 }
@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/glower/file-watcher/notification"
+)
+
+// Upload describes a file which is sent to a storage, storages only read from Reader and
+// don't need the file on disk
+type Upload struct {
+	Reader   io.Reader
+	Size     int64
+	Checksum string
+	// Key is the slash separated destination path in the storage like "Documents/notes/todo.txt",
+	// the first element is the name of the watched directory
+	Key      string
+	MimeType string
+	// Event is the file change which caused the upload, it's used for progress reports
+	Event *notification.Event
+	// RemoteID is set by storages which have an id for the stored file, it's kept in the snapshot
+	RemoteID string
+}
+
+// Key returns the destination path of a file in a storage
+func Key(event *notification.Event) string {
+	return path.Join(filepath.Base(event.DirectoryPath), filepath.ToSlash(event.RelativePath))
+}
+
+// openUpload opens the changed file, the caller has to close the returned file
+func openUpload(event *notification.Event) (*Upload, *os.File, error) {
+	f, err := os.Open(event.AbsolutePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open file [%s]: %v", event.AbsolutePath, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return &Upload{
+		Reader:   f,
+		Size:     info.Size(),
+		Checksum: event.Checksum,
+		Key:      Key(event),
+		MimeType: event.MimeType,
+		Event:    event,
+	}, f, nil
+}
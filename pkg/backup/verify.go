@@ -0,0 +1,78 @@
+package backup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/catalog"
+	"github.com/glower/bakku-app/pkg/checksum"
+	"github.com/glower/bakku-app/pkg/storage"
+)
+
+// VerifyResult is the result of the verification of one backuped file
+type VerifyResult struct {
+	Storage  string `json:"storage"`
+	Path     string `json:"path"`
+	OK       bool   `json:"ok"`
+	Checksum string `json:"checksum,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Verify restores every file with the path prefix to a temporary file and compares it with the snapshot
+// like the scrub does, the verification checksum is used if the record has one
+func Verify(db storage.Storager, storageName, prefix string, restore catalog.RestoreFunc) ([]VerifyResult, error) {
+	entries, err := catalog.List(db, storageName, prefix)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "bakku-verify")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	result := make([]VerifyResult, 0, len(entries))
+	for i := range entries {
+		e := &entries[i]
+		r := VerifyResult{Storage: storageName, Path: e.AbsolutePath}
+		algorithm := e.Algorithm
+		if e.Verify != nil {
+			algorithm = e.Verify.Algorithm
+		}
+		o, err := restoredObject(&e.Event, filepath.Join(dir, fmt.Sprintf("%d", i)), restore, algorithm)
+		if err != nil {
+			r.Error = err.Error()
+			result = append(result, r)
+			continue
+		}
+		r.Checksum = o.Checksum
+		if problem, _ := checkObject(o, e); problem != "" {
+			r.Error = problem
+		} else {
+			r.OK = true
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// restoredObject restores the file to tmp and returns it as an object with the checksum computed with the algorithm
+func restoredObject(event *notification.Event, tmp string, restore catalog.RestoreFunc, algorithm string) (*Object, error) {
+	defer os.Remove(tmp)
+	if err := restore(event, tmp); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := checksum.File(tmp, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{Key: event.RelativePath, Size: info.Size(), Checksum: sum, Algorithm: algorithm}, nil
+}
@@ -0,0 +1,154 @@
+// Package catalog answers questions about backuped files from the snapshot database,
+// it's used by the REST API and by the offline mode of the CLI
+package catalog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/history"
+	"github.com/glower/bakku-app/pkg/storage"
+	"github.com/glower/bakku-app/pkg/storage/record"
+)
+
+// Entry is a file backuped to one storage
+type Entry struct {
+	Storage string `json:"storage"`
+	notification.Event
+	// Algorithm of the checksum of the event
+	Algorithm string `json:"algorithm"`
+	// Verify is the checksum for the verification of the backup, older records don't have it
+	Verify *record.Checksum `json:"verify,omitempty"`
+	// Versions are the former backups kept in the record, the newest first
+	Versions []record.FileVersion `json:"versions,omitempty"`
+}
+
+func newEntry(r *record.Record, storageName string) Entry {
+	return Entry{Storage: storageName, Event: *r.Event(), Algorithm: r.Checksum.Algorithm, Verify: r.Verify, Versions: r.Versions}
+}
+
+// ChecksumOf returns the checksum computed with the algorithm or an empty string if the entry has none
+func (e *Entry) ChecksumOf(algorithm string) string {
+	switch {
+	case e.Verify != nil && e.Verify.Algorithm == algorithm:
+		return e.Verify.Value
+	case e.Algorithm == algorithm:
+		return e.Checksum
+	}
+	return ""
+}
+
+// TargetExistsError is returned if a restore would replace an existing file without overwrite
+type TargetExistsError struct {
+	Path string
+}
+
+func (e TargetExistsError) Error() string {
+	return fmt.Sprintf("[%s] exists, restore it to another path or overwrite it", e.Path)
+}
+
+// RestoreFunc copies the backup copy of a file to the given path
+type RestoreFunc func(event *notification.Event, to string) error
+
+// List returns all files backuped to the storage whose path starts with the prefix, sorted by path
+func List(db storage.Storager, storageName, prefix string) ([]Entry, error) {
+	all, err := db.GetAll(storageName)
+	if storage.IsBucketNotFound(err) {
+		// nothing was backuped to this storage yet
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := []Entry{}
+	for path, value := range all {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		r, err := record.Decode(value, storageName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot entry for [%s]: %v", path, err)
+		}
+		result = append(result, newEntry(r, storageName))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].AbsolutePath < result[j].AbsolutePath })
+	return result, nil
+}
+
+// Versions returns the backuped version of a file in every given storage, every entry has the former
+// versions which are kept in its record
+func Versions(db storage.Storager, storageNames []string, file string) ([]Entry, error) {
+	result := []Entry{}
+	for _, name := range storageNames {
+		e, err := Get(db, name, file)
+		if err != nil {
+			continue
+		}
+		result = append(result, *e)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no backup of [%s] found", file)
+	}
+	return result, nil
+}
+
+// Get returns the backuped version of a file in one storage
+func Get(db storage.Storager, storageName, file string) (*Entry, error) {
+	value, err := db.Get(filepath.Clean(file), storageName)
+	if err != nil || value == "" {
+		return nil, fmt.Errorf("no backup of [%s] in storage [%s]", file, storageName)
+	}
+	r, err := record.Decode(value, storageName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot entry for [%s]: %v", file, err)
+	}
+	e := newEntry(r, storageName)
+	return &e, nil
+}
+
+// Restore copies a backuped file to the given path, an empty path restores the file to its original location
+// and a directory restores it into the directory. An existing file, e.g. the live file at the original
+// location, is only replaced with overwrite.
+func Restore(db storage.Storager, storageName, file, to string, overwrite bool, restore RestoreFunc) (string, error) {
+	started := time.Now()
+	record := history.Record{
+		Action:  history.ActionRestore,
+		File:    file,
+		Storage: storageName,
+		Result:  history.ResultFailed,
+	}
+	defer func() {
+		record.Duration = int64(time.Since(started) / time.Millisecond)
+		history.Add(record)
+	}()
+
+	e, err := Get(db, storageName, file)
+	if err != nil {
+		record.Error = err.Error()
+		return "", err
+	}
+	record.Size = e.Size
+	record.Checksum = e.Checksum
+	if to == "" {
+		to = e.AbsolutePath
+	} else if info, err := os.Stat(to); err == nil && info.IsDir() {
+		to = filepath.Join(to, filepath.Base(e.AbsolutePath))
+	}
+	if _, err := os.Stat(to); err == nil && !overwrite {
+		err := TargetExistsError{Path: to}
+		record.Error = err.Error()
+		return "", err
+	}
+	if err := restore(&e.Event, to); err != nil {
+		record.Error = err.Error()
+		return "", fmt.Errorf("can't restore [%s] from [%s]: %v", file, storageName, err)
+	}
+	record.Result = history.ResultOK
+	return to, nil
+}
@@ -0,0 +1,131 @@
+package catalog
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/storage"
+	"github.com/glower/bakku-app/pkg/storage/memory"
+	"github.com/glower/bakku-app/pkg/storage/record"
+)
+
+// brokenStorage can't read any bucket
+type brokenStorage struct {
+	*memory.Memory
+}
+
+func (s brokenStorage) GetAll(string) (map[string]string, error) {
+	return nil, errors.New("disk error")
+}
+
+func TestList(t *testing.T) {
+	tests := []struct {
+		name    string
+		db      storage.Storager
+		wantErr bool
+	}{
+		{
+			name: "Scenario 1: nothing was backuped to the storage yet",
+			db:   memory.New(),
+		},
+		{
+			name:    "Scenario 2: the snapshot database can't be read",
+			db:      brokenStorage{memory.New()},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := List(tt.db, "storage.local", "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("List() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(entries) != 0 {
+				t.Errorf("List() = %v, want no entries", entries)
+			}
+		})
+	}
+}
+
+// backuped returns a database with two backups of the file, the former one is kept in the versions
+func backuped(t *testing.T, file string) storage.Storager {
+	db := memory.New()
+	former := record.FromEvent(&notification.Event{AbsolutePath: file, Size: 1, Checksum: "a"}, "storage.local")
+	r := record.FromEvent(&notification.Event{AbsolutePath: file, Size: 2, Checksum: "b"}, "storage.local")
+	r.Replace(former)
+	value, err := r.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Add(file, "storage.local", value)
+	return db
+}
+
+func TestVersions(t *testing.T) {
+	db := backuped(t, "/home/test/a.txt")
+	entries, err := Versions(db, []string{"storage.local"}, "/home/test/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || len(entries[0].Versions) != 1 || entries[0].Versions[0].Checksum.Value != "a" {
+		t.Errorf("Versions() = %+v, want the backup with the former version", entries)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakku-catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("BAKKUAPPCONF", os.Getenv("BAKKUAPPCONF"))
+	os.Setenv("BAKKUAPPCONF", dir)
+	live := filepath.Join(dir, "live.txt")
+	deleted := filepath.Join(dir, "deleted.txt")
+	ioutil.WriteFile(live, []byte("live"), 0600)
+	restore := func(e *notification.Event, to string) error {
+		return ioutil.WriteFile(to, []byte("backup"), 0600)
+	}
+
+	tests := []struct {
+		name      string
+		file      string
+		overwrite bool
+		wantErr   bool
+		want      string
+	}{
+		{
+			name:    "Scenario 1: the live file is not overwritten",
+			file:    live,
+			wantErr: true,
+			want:    "live",
+		},
+		{
+			name:      "Scenario 2: the live file is overwritten on request",
+			file:      live,
+			overwrite: true,
+			want:      "backup",
+		},
+		{
+			name: "Scenario 3: a deleted file is restored to its original location",
+			file: deleted,
+			want: "backup",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Restore(backuped(t, tt.file), "storage.local", tt.file, "", tt.overwrite, restore)
+			if _, ok := err.(TargetExistsError); ok != tt.wantErr {
+				t.Fatalf("Restore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if data, _ := ioutil.ReadFile(tt.file); string(data) != tt.want {
+				t.Errorf("the file is [%s], want [%s]", data, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Package checksum computes the checksums of files with the algorithms which can be configured for
+// change detection and for the verification of backups
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	fi "github.com/glower/file-watcher/util"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Supported algorithms
+const (
+	// Default is the checksum of the file watcher, all snapshot records written before the algorithm
+	// could be configured use it
+	Default = "default"
+	// SHA256 is slow but a good choice to verify backups
+	SHA256 = "sha256"
+	// BLAKE2b is BLAKE2b-256, it's secure and faster than SHA-256 on CPUs without SHA instructions
+	BLAKE2b = "blake2b"
+	// XXHash is xxHash64, it's not cryptographic but very fast, a good choice to detect changed files
+	XXHash = "xxhash"
+)
+
+// algorithms are the streaming hashes, Default can only be computed from a file
+var algorithms = map[string]func() hash.Hash{
+	SHA256:  sha256.New,
+	BLAKE2b: newBLAKE2b,
+	XXHash:  func() hash.Hash { return xxhash.New() },
+}
+
+func newBLAKE2b() hash.Hash {
+	// New256 only fails for keys longer than 64 bytes
+	h, _ := blake2b.New256(nil)
+	return h
+}
+
+// Algorithms returns the names of all supported algorithms
+func Algorithms() []string {
+	result := []string{Default}
+	for name := range algorithms {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Valid returns an error for unknown algorithms
+func Valid(algorithm string) error {
+	if _, ok := algorithms[algorithm]; ok || algorithm == Default {
+		return nil
+	}
+	return fmt.Errorf("unknown checksum algorithm [%s], use one of %s", algorithm, strings.Join(Algorithms(), ", "))
+}
+
+// File returns the hex encoded checksum of a file
+func File(path, algorithm string) (string, error) {
+	if algorithm == Default || algorithm == "" {
+		info, err := fi.GetFileInformation(path)
+		if err != nil {
+			return "", err
+		}
+		return info.Checksum()
+	}
+	newHash, ok := algorithms[algorithm]
+	if !ok {
+		return "", Valid(algorithm)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Writer computes the checksums of everything written to it with several algorithms at once, it's used
+// to get the checksums of an upload without reading the file again. Default is not supported and ignored.
+type Writer struct {
+	hashes  map[string]hash.Hash
+	written int64
+}
+
+// NewWriter returns a writer for the algorithms
+func NewWriter(names ...string) *Writer {
+	w := &Writer{hashes: make(map[string]hash.Hash)}
+	for _, name := range names {
+		if newHash, ok := algorithms[name]; ok {
+			w.hashes[name] = newHash()
+		}
+	}
+	return w
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	for _, h := range w.hashes {
+		h.Write(p)
+	}
+	w.written += int64(len(p))
+	return len(p), nil
+}
+
+// Written returns the number of bytes written
+func (w *Writer) Written() int64 {
+	return w.written
+}
+
+// Sums returns the hex encoded checksums by algorithm
+func (w *Writer) Sums() map[string]string {
+	result := make(map[string]string, len(w.hashes))
+	for a, h := range w.hashes {
+		result[a] = hex.EncodeToString(h.Sum(nil))
+	}
+	return result
+}
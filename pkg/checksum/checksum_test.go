@@ -0,0 +1,107 @@
+package checksum
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakku-checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hello.txt")
+	if err := ioutil.WriteFile(path, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		algorithm string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "Scenario 1: sha256",
+			algorithm: SHA256,
+			want:      "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		},
+		{
+			name:      "Scenario 2: blake2b",
+			algorithm: BLAKE2b,
+			want:      "324dcf027dd4a30a932c441f365a25e86b173defa4b8e58948253471b81b72cf",
+		},
+		{
+			name:      "Scenario 3: xxhash",
+			algorithm: XXHash,
+			want:      "26c7827d889f6da3",
+		},
+		{
+			name:      "Scenario 4: unknown algorithm",
+			algorithm: "md4",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := File(path, tt.algorithm)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("File() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("File() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriter(t *testing.T) {
+	w := NewWriter(SHA256, XXHash, Default)
+	w.Write([]byte("hel"))
+	w.Write([]byte("lo"))
+	sums := w.Sums()
+	if len(sums) != 2 || w.Written() != 5 {
+		t.Fatalf("got %d checksums of %d bytes, want 2 of 5", len(sums), w.Written())
+	}
+	if !strings.HasPrefix(sums[SHA256], "2cf24dba") || sums[XXHash] != "26c7827d889f6da3" {
+		t.Errorf("unexpected checksums %v", sums)
+	}
+}
+
+// benchmarkFileSize is the size of the file used by the benchmarks
+const benchmarkFileSize = 256 << 20
+
+func benchmarkFile(b *testing.B, algorithm string) {
+	f, err := ioutil.TempFile("", "bakku-checksum")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	buf := make([]byte, 1<<20)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < benchmarkFileSize/len(buf); i++ {
+		rnd.Read(buf)
+		if _, err := f.Write(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+	f.Close()
+
+	b.SetBytes(benchmarkFileSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := File(f.Name(), algorithm); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFile256MB_SHA256(b *testing.B)  { benchmarkFile(b, SHA256) }
+func BenchmarkFile256MB_BLAKE2b(b *testing.B) { benchmarkFile(b, BLAKE2b) }
+func BenchmarkFile256MB_XXHash(b *testing.B)  { benchmarkFile(b, XXHash) }
+func BenchmarkFile256MB_Default(b *testing.B) { benchmarkFile(b, Default) }
@@ -0,0 +1,317 @@
+// Package client is a client for the REST API of the bakku-app service
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/catalog"
+	"github.com/glower/bakku-app/pkg/history"
+	"github.com/glower/bakku-app/pkg/snapshot"
+	"github.com/glower/bakku-app/pkg/storage/record"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+// Client talks to a running service
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Token is sent as bearer token if it's set
+	Token string
+}
+
+// UnavailableError is returned when the service can't be reached
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("service is not running: %v", e.Err)
+}
+
+// IsUnavailable checks if the error means that the service is not running
+func IsUnavailable(err error) bool {
+	_, ok := err.(*UnavailableError)
+	return ok
+}
+
+// New returns a client for the service listening on the given base URL like http://localhost:8080
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		HTTPClient: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Ping checks if the service is running
+func (c *Client) Ping() error {
+	return c.do("GET", "/ping", nil, nil)
+}
+
+// Status returns the state of all backup storages
+func (c *Client) Status() ([]types.StorageStatus, error) {
+	var result []types.StorageStatus
+	err := c.do("GET", "/api/status", nil, &result)
+	return result, err
+}
+
+// DirStatus returns the state of the backups of all watched directories
+func (c *Client) DirStatus() ([]types.DirStatus, error) {
+	var result []types.DirStatus
+	err := c.do("GET", "/api/status/dirs", nil, &result)
+	return result, err
+}
+
+// Scans returns the state of all scan jobs
+func (c *Client) Scans() ([]snapshot.ScanStatus, error) {
+	var result []snapshot.ScanStatus
+	err := c.do("GET", "/api/scans", nil, &result)
+	return result, err
+}
+
+// Queue returns all files which are uploaded right now or held
+func (c *Client) Queue() ([]types.QueuedFile, error) {
+	var result []types.QueuedFile
+	err := c.do("GET", "/api/queue", nil, &result)
+	return result, err
+}
+
+// List returns all backuped files of a storage with the path prefix
+func (c *Client) List(storageName, prefix string) ([]catalog.Entry, error) {
+	var result []catalog.Entry
+	q := url.Values{"storage": {storageName}, "path": {prefix}}
+	err := c.do("GET", "/api/files?"+q.Encode(), nil, &result)
+	return result, err
+}
+
+// Versions returns the backuped version of a file in every storage
+func (c *Client) Versions(file string) ([]catalog.Entry, error) {
+	var result []catalog.Entry
+	q := url.Values{"path": {file}}
+	err := c.do("GET", "/api/versions?"+q.Encode(), nil, &result)
+	return result, err
+}
+
+// Restore copies a backuped file to the given path and returns the path of the restored file,
+// an existing file is only replaced with overwrite
+func (c *Client) Restore(storageName, file, to string, overwrite bool) (string, error) {
+	result := make(map[string]string)
+	req := map[string]interface{}{"storage": storageName, "path": file, "to": to, "overwrite": overwrite}
+	err := c.do("POST", "/api/restore", req, &result)
+	return result["restored"], err
+}
+
+// Verify compares the backuped files of a storage with the checksums in the snapshot
+func (c *Client) Verify(storageName, prefix string) ([]backup.VerifyResult, error) {
+	var result []backup.VerifyResult
+	req := map[string]string{"storage": storageName, "path": prefix}
+	err := c.do("POST", "/api/verify", req, &result)
+	return result, err
+}
+
+// History returns the logged uploads, deletions and restores which match the filter, the newest first
+func (c *Client) History(f history.Filter) (*history.Page, error) {
+	q := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	set("path", f.Prefix)
+	set("storage", f.Storage)
+	set("action", f.Action)
+	set("status", f.Result)
+	if !f.Since.IsZero() {
+		set("since", f.Since.Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		set("until", f.Until.Format(time.RFC3339))
+	}
+	if f.Offset > 0 {
+		set("offset", strconv.Itoa(f.Offset))
+	}
+	if f.Limit > 0 {
+		set("limit", strconv.Itoa(f.Limit))
+	}
+	result := &history.Page{}
+	err := c.do("GET", "/api/history?"+q.Encode(), nil, result)
+	return result, err
+}
+
+// Scrub starts a verification of a storage, repair uploads missing and corrupted files again
+func (c *Client) Scrub(storageName string, repair bool) (*backup.ScrubReport, error) {
+	result := &backup.ScrubReport{}
+	err := c.do("POST", "/api/storages/"+url.PathEscape(storageName)+"/verify", map[string]bool{"repair": repair}, result)
+	return result, err
+}
+
+// Reindex rebuilds the snapshot records of a storage from its content
+func (c *Client) Reindex(storageName string, opt backup.ReindexOptions) (*backup.ReindexReport, error) {
+	result := &backup.ReindexReport{}
+	err := c.do("POST", "/api/storages/"+url.PathEscape(storageName)+"/reindex", opt, result)
+	return result, err
+}
+
+// Scrubs returns the report of the last verification of every storage
+func (c *Client) Scrubs() ([]backup.ScrubReport, error) {
+	var result []backup.ScrubReport
+	err := c.do("GET", "/api/verifications", nil, &result)
+	return result, err
+}
+
+// Rescan starts a scan of a watched directory
+func (c *Client) Rescan(dir string) (*snapshot.ScanStatus, error) {
+	result := &snapshot.ScanStatus{}
+	err := c.do("POST", "/api/scans", map[string]string{"path": dir}, result)
+	return result, err
+}
+
+// Pause holds all uploads of a storage
+func (c *Client) Pause(storageName string) error {
+	return c.do("POST", "/api/storages/"+url.PathEscape(storageName)+"/pause", nil, nil)
+}
+
+// Resume uploads all held files of a paused storage
+func (c *Client) Resume(storageName string) error {
+	return c.do("POST", "/api/storages/"+url.PathEscape(storageName)+"/resume", nil, nil)
+}
+
+// Drain finishes the running uploads of a storage and pauses it afterwards
+func (c *Client) Drain(storageName string) error {
+	return c.do("POST", "/api/storages/"+url.PathEscape(storageName)+"/drain", nil, nil)
+}
+
+// Cancel stops the upload of a file to a storage
+func (c *Client) Cancel(storageName, path string) error {
+	return c.do("POST", "/api/queue/cancel", map[string]string{"storage": storageName, "path": path}, nil)
+}
+
+// Prioritize uploads a held file now
+func (c *Client) Prioritize(storageName, path string) error {
+	return c.do("POST", "/api/queue/prioritize", map[string]string{"storage": storageName, "path": path}, nil)
+}
+
+// Config returns the configuration as a generic map, so unknown keys of newer versions are kept
+func (c *Client) Config() (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	err := c.do("GET", "/api/config", nil, &result)
+	return result, err
+}
+
+// UpdateConfig validates and applies the configuration
+func (c *Client) UpdateConfig(conf map[string]interface{}) error {
+	return c.do("PUT", "/api/config", conf, nil)
+}
+
+// Export writes the snapshot records which match the filter to w
+func (c *Client) Export(w io.Writer, format string, f record.Filter) error {
+	resp, err := c.send("GET", "/api/snapshot/export?"+exportQuery(format, f).Encode(), nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Import sends exported snapshot records to the service
+func (c *Client) Import(r io.Reader, format string, opt record.ImportOptions) (*record.ImportReport, error) {
+	q := exportQuery(format, opt.Filter)
+	q.Set("overwrite", strconv.FormatBool(opt.Overwrite))
+	q.Set("dry-run", strconv.FormatBool(opt.DryRun))
+	if opt.From != "" {
+		q.Set("from", opt.From)
+		q.Set("to", opt.To)
+	}
+	resp, err := c.send("POST", "/api/snapshot/import?"+q.Encode(), r, "application/octet-stream")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	report := &record.ImportReport{}
+	return report, json.NewDecoder(resp.Body).Decode(report)
+}
+
+func exportQuery(format string, f record.Filter) url.Values {
+	q := url.Values{"format": {format}}
+	if f.Prefix != "" {
+		q.Set("path", f.Prefix)
+	}
+	if len(f.Storages) > 0 {
+		q.Set("storage", strings.Join(f.Storages, ","))
+	}
+	return q
+}
+
+// do sends the request body as JSON and decodes the JSON response into out
+func (c *Client) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+	resp, err := c.send(method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send sends the request, the caller has to close the body of the response
+func (c *Client) send(method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, &UnavailableError{Err: err}
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+	return resp, nil
+}
+
+// decodeError reads the error message and the field errors of an invalid config
+func decodeError(resp *http.Response) error {
+	e := struct {
+		Error  string `json:"error"`
+		Fields []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"fields"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	msg := e.Error
+	for _, f := range e.Fields {
+		msg += fmt.Sprintf("\n  %s: %s", f.Field, f.Message)
+	}
+	return fmt.Errorf("%s", msg)
+}
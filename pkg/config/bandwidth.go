@@ -0,0 +1,28 @@
+package config
+
+// Bandwidth is a configuration of an upload rate limit in bytes per second,
+// profiles override the limit for a time of the day
+type Bandwidth struct {
+	Limit    string             `json:"limit" yaml:"limit" mapstructure:"limit"`
+	Profiles []BandwidthProfile `json:"profiles,omitempty" yaml:"profiles,omitempty" mapstructure:"profiles"`
+}
+
+// BandwidthProfile is a rate limit for a time of the day
+type BandwidthProfile struct {
+	Window string `json:"window" yaml:"window" mapstructure:"window"`
+	Limit  string `json:"limit" yaml:"limit" mapstructure:"limit"`
+}
+
+// BandwidthConf returns the bandwidth limits of a storage like "gdrive" or the global limits for an empty name
+func BandwidthConf(storageName string) *Bandwidth {
+	var conf *Bandwidth
+	if storageName == "" {
+		conf = Current().Bandwidth
+	} else {
+		conf = Current().Storage[storageName].Bandwidth
+	}
+	if conf == nil {
+		return &Bandwidth{}
+	}
+	return conf
+}
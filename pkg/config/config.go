@@ -1,26 +1,39 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	home "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
 )
 
-// Config is an interface for a configuration provider
-type Config interface {
-	ReadDefaultConfig()
-	DirectoriesToWatch() []string
-}
+// defaultFileFilters are file name suffixes which are never backuped
+var defaultFileFilters = []string{".crdownload", ".lock", ".snapshot", ".snapshot.lock"}
 
 const defaultDBFile = "storage.db"
 const defaultConfigName = "config"
 const defaultCofigPath = ".bakkuapp"
+const defaultPort = "8080"
+const defaultBind = "localhost"
+const defaultTokenFile = "tokens.json"
+const defaultCertFile = "server.crt"
+const defaultKeyFile = "server.key"
+const envPrefix = "BAKKU"
+
+const defaultSnapshotStorage = "boltdb"
+const defaultSnapshotBucketName = "snapshot"
+const defaultSnapshotFileName = ".snapshot"
+const defaultChangeChecksum = "xxhash"
+const defaultVerifyChecksum = "sha256"
+const defaultQueuePolicy = "smallest"
+
+const defaultHistoryFile = "history.jsonl"
+const defaultHistoryMaxSize = "10MB"
+const defaultHistoryMaxFiles = 5
 
 func GetStoragePath() string {
 	path := GetConfigPath()
@@ -44,16 +57,29 @@ func GetConfigPath() string {
 	return configPath
 }
 
-// ReadDefaultConfig reads default config from the default path
-func ReadDefaultConfig() {
+// ReadDefaultConfig reads the config file from the default path, on the first run a commented
+// default config is written. All validation errors are returned at once.
+func ReadDefaultConfig() error {
 	path := GetConfigPath()
 	log.Printf("config.ReadDefaultConfig(): read config file [%s] from [%s]\n", defaultConfigName, path)
 	viper.SetConfigName(defaultConfigName) // name of config file (without extension)
 	viper.AddConfigPath(path)
+	setDefaults()
+	setEnv()
+
 	err := viper.ReadInConfig() // Find and read the config file
-	if err != nil {             // Handle errors reading the config file
-		panic(fmt.Errorf("fatal error config file: %s", err))
+	if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+		log.Printf("config.ReadDefaultConfig(): no config file found in [%s], creating a default one\n", path)
+		if err := writeDefaultConfig(path); err != nil {
+			return fmt.Errorf("can't create default config file: %v", err)
+		}
+		err = viper.ReadInConfig()
 	}
+	if err != nil {
+		return fmt.Errorf("can't read config file: %v", err)
+	}
+	_, err = Reload()
+	return err
 }
 
 type WatchConfig struct {
@@ -62,36 +88,48 @@ type WatchConfig struct {
 
 // Watch ...
 type Watch struct {
-	Path   string `json:"path"`
-	Active bool   `json:"active"`
+	Path   string `json:"path" yaml:"path" mapstructure:"path"`
+	Active bool   `json:"active" yaml:"active" mapstructure:"active"`
+	// Schedule is a cron expression for periodic rescans of the directory
+	Schedule string `json:"schedule,omitempty" yaml:"schedule,omitempty" mapstructure:"schedule"`
+	// ScanOnly disables file change notifications, the directory is only scanned on schedule
+	ScanOnly bool `json:"scanOnly,omitempty" yaml:"scanOnly,omitempty" mapstructure:"scanOnly"`
+	// Priority orders the uploads, files of directories with a higher priority are uploaded first
+	Priority int `json:"priority,omitempty" yaml:"priority,omitempty" mapstructure:"priority"`
 }
 
 // DirectoriesToWatch returns a list of directories to watch for the file changes
 func DirectoriesToWatch() (*WatchConfig, error) {
-	result := &WatchConfig{}
+	return &WatchConfig{
+		DirsToWatch: Current().DirsToWatch,
+	}, nil
+}
 
-	err := viper.Unmarshal(&result)
-	if err != nil {
-		return nil, fmt.Errorf("unable to unmarshal config: %v", err)
+// WatchedDirs returns the cleaned paths of all configured directories, see watchdir.Of for the directory of a file
+func WatchedDirs() []string {
+	var dirs []string
+	for _, d := range Current().DirsToWatch {
+		dirs = append(dirs, filepath.Clean(d.Path))
 	}
-
-	return result, nil
+	return dirs
 }
 
-func (c *WatchConfig) ToJSON() (string, error) {
-	jsonConf, err := json.Marshal(c)
-	if err != nil {
-		return "", fmt.Errorf("unable to marshal config: %v", err)
+// FileFilters returns a list of file name suffixes which are not backuped
+func FileFilters() []string {
+	filters := append(append([]string{}, defaultFileFilters...), Current().Filters...)
+	// the snapshot database of a watched directory is not backuped
+	if snapshot := Current().Snapshot; snapshot.SameDir && snapshot.FileName != "" {
+		filters = append(filters, snapshot.FileName)
 	}
-	return string(jsonConf), nil
+	return filters
 }
 
-func FromJSON(input io.ReadCloser) (*WatchConfig, error) {
-	conf := &WatchConfig{}
-	decoder := json.NewDecoder(input)
-	err := decoder.Decode(&conf)
-	if err != nil {
-		return nil, fmt.Errorf("unable to unmarshal config: %v", err)
+// Filtered checks if the file should not be backuped
+func Filtered(path string, filters []string) bool {
+	for _, f := range filters {
+		if strings.HasSuffix(path, f) {
+			return true
+		}
 	}
-	return conf, nil
+	return false
 }
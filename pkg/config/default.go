@@ -0,0 +1,116 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// defaultConfig is written on the first run, every value can also be set with an
+// environment variable like BAKKU_SERVER_PORT for server.port
+const defaultConfig = `# bakku-app configuration
+#
+# Directories to backup, every change is sent to all active storages.
+dirsToWatch: []
+#  - path: "C:\\Users\\John\\Documents\\"
+#    active: true
+#    # rescan the directory with a cron expression
+#    schedule: "0 2 * * *"
+#    # don't watch for changes, only scan on schedule (for network mounts)
+#    scanOnly: false
+#    # files of directories with a higher priority are uploaded first, default is 0
+#    priority: 1
+
+# File name suffixes which are never backuped.
+filters: []
+
+# Global upload limit in bytes per second like 512KB or 2MB, empty means no limit.
+bandwidth:
+  limit: ""
+#  profiles:
+#    - window: "08:00-18:00"
+#      limit: 256KB
+
+storage:
+  local:
+    active: false
+    # directory where the backups are stored
+    path: ""
+#    # hold all changes and upload them on schedule
+#    schedule: "0 3 * * *"
+#    # don't upload during these hours
+#    quietHours:
+#      - "08:00-18:00"
+#    # maximum duration of one upload, default is 1h, 0 disables it
+#    timeout: 10m
+#    # number of parallel uploads, default is 2
+#    concurrency: 4
+#    # compare the backups with the snapshot weekly, repair uploads broken files again
+#    verify: "0 4 * * 0"
+#    repair: true
+  gdrive:
+    active: false
+    # folder in the Google Drive, default is bakku-app
+    path: ""
+    # both files are stored next to this config file
+    tokenFile: "token.json"
+    credentialsFile: "credentials.json"
+
+snapshot:
+  # database of the snapshot, only boltdb is available
+  default: boltdb
+  # keep the snapshot of a watched directory in the file fileName inside of it,
+  # otherwise everything is in storage.db next to this config file
+  sameDir: true
+  # bucket with the schema version
+  bucketName: snapshot
+  fileName: .snapshot
+
+# Checksum algorithms: sha256, blake2b, xxhash or default. Existing snapshot records are
+# migrated by the next scan when the algorithm is changed.
+checksum:
+  # finds changed files on a scan, it should be fast
+  change: xxhash
+  # checks the integrity of the backups
+  verify: sha256
+
+# Order of the uploads inside of a watched directory: smallest (file first) or oldest (change first).
+# Directories with the same priority take turns.
+queue:
+  policy: smallest
+
+server:
+  # use 0.0.0.0 to make the API available on all interfaces
+  bind: localhost
+  # BAKKU_PORT overrides the port as well
+  port: "8080"
+  auth:
+    # every request needs a bearer token, create one with "bakku token create <name>"
+    enabled: true
+    tokenFile: tokens.json
+  tls:
+    # a self-signed certificate is created next to this config file if the files don't exist
+    enabled: false
+    certFile: server.crt
+    keyFile: server.key
+    # only clients with a certificate signed by this CA are accepted
+    # clientCAFile: ca.crt
+  cors:
+    # origins of browser based clients like the Electron UI
+    allowedOrigins: []
+
+# Every upload, deletion and restore is logged, see GET /api/history.
+history:
+  # stored next to this config file
+  file: history.jsonl
+  # the file is rotated when it's bigger, the last maxFiles files are kept
+  maxSize: 10MB
+  maxFiles: 5
+`
+
+// writeDefaultConfig writes a commented default config file to the given directory
+func writeDefaultConfig(path string) error {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(path, defaultConfigName+".yml"), []byte(defaultConfig), 0600)
+}
@@ -0,0 +1,183 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/config"
+	"github.com/glower/bakku-app/pkg/message"
+	"github.com/glower/bakku-app/pkg/ratelimit"
+	"github.com/glower/bakku-app/pkg/scheduler"
+	"github.com/glower/bakku-app/pkg/snapshot"
+	"github.com/glower/bakku-app/pkg/types"
+	"github.com/glower/file-watcher/watcher"
+)
+
+// Manager applies configuration changes to the running service without a restart
+type Manager struct {
+	// the mutex protects current
+	sync.Mutex
+	// applyM serializes the changes, it's held while the scans of removed directories are canceled
+	applyM sync.Mutex
+	ctx    context.Context
+
+	watcher   *watcher.Watch
+	snapshot  *snapshot.Snapshot
+	backup    *backup.StorageManager
+	scheduler *scheduler.Scheduler
+	messageCh chan message.Message
+
+	current *config.Settings
+}
+
+// Setup loads the current settings and starts watching the config file for manual changes
+func Setup(ctx context.Context, res *types.GlobalResources, snapShotManager *snapshot.Snapshot, backupStorageManager *backup.StorageManager, sched *scheduler.Scheduler) (*Manager, error) {
+	current := config.Current()
+	m := &Manager{
+		ctx:       ctx,
+		watcher:   res.FileWatcher,
+		snapshot:  snapShotManager,
+		backup:    backupStorageManager,
+		scheduler: sched,
+		messageCh: res.MessageCh,
+		current:   current,
+	}
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		log.Printf("config.manager: config file [%s] changed\n", e.Name)
+		m.reload()
+	})
+	viper.WatchConfig()
+	return m, nil
+}
+
+// Get returns a copy of the current settings
+func (m *Manager) Get() *config.Settings {
+	m.Lock()
+	defer m.Unlock()
+	s := *m.current
+	return &s
+}
+
+// Update validates and stores the new settings and applies them to the running service
+func (m *Manager) Update(s *config.Settings) error {
+	m.applyM.Lock()
+	defer m.applyM.Unlock()
+	if err := config.Save(s); err != nil {
+		return err
+	}
+	// the settings as they are read from the written file, the reload triggered by the write finds no change
+	m.change(config.Current())
+	return nil
+}
+
+// reload is called when the config file was changed on disk
+func (m *Manager) reload() {
+	m.applyM.Lock()
+	defer m.applyM.Unlock()
+	s, err := config.Reload()
+	if err != nil {
+		log.Printf("[ERROR] config.manager.reload(): %v\n", err)
+		m.messageCh <- message.FormatMessage("ERROR", fmt.Sprintf("config file was not applied: %v", err), "config")
+		return
+	}
+	if m.change(s) {
+		m.messageCh <- message.FormatMessage("INFO", "config file was applied", "config")
+	}
+}
+
+// change applies the settings if they differ from the current ones, the caller must hold applyM.
+// Canceling a scan waits for it to stop, so it's done after the settings are switched and unlocked.
+func (m *Manager) change(s *config.Settings) bool {
+	m.Lock()
+	if reflect.DeepEqual(m.current, s) {
+		m.Unlock()
+		return false
+	}
+	cancel := m.apply(m.current, s)
+	m.current = s
+	m.Unlock()
+
+	for _, path := range cancel {
+		m.snapshot.CancelScan(path)
+	}
+	return true
+}
+
+// apply starts and stops watching directories and sets up storages which were changed,
+// it returns the directories whose scans have to be canceled
+func (m *Manager) apply(from, to *config.Settings) []string {
+	oldDirs := make(map[string]config.Watch)
+	for _, d := range from.DirsToWatch {
+		oldDirs[filepath.Clean(d.Path)] = d
+	}
+	newDirs := make(map[string]config.Watch)
+	for _, d := range to.DirsToWatch {
+		newDirs[filepath.Clean(d.Path)] = d
+	}
+
+	var cancel []string
+	for path, d := range newDirs {
+		o, existed := oldDirs[path]
+		wasActive := existed && o.Active
+		watched := wasActive && !o.ScanOnly
+		shouldWatch := d.Active && !d.ScanOnly
+		// a directory which is not available is skipped, config.Reload warns about it
+		available := config.DirAvailable(d.Path) == nil
+
+		if shouldWatch && !watched && available {
+			log.Printf("config.manager.apply(): start watching [%s]\n", d.Path)
+			go m.watcher.StartWatching(d.Path)
+		}
+		if !shouldWatch && watched {
+			log.Printf("config.manager.apply(): stop watching [%s]\n", d.Path)
+			m.watcher.StopWatching(o.Path)
+		}
+		if d.Active && !wasActive && available {
+			if _, err := m.snapshot.Scan(d.Path); err != nil {
+				log.Printf("[ERROR] config.manager.apply(): %v\n", err)
+			}
+		}
+		if !d.Active && wasActive {
+			cancel = append(cancel, d.Path)
+		}
+	}
+	for path, o := range oldDirs {
+		if _, ok := newDirs[path]; ok || !o.Active {
+			continue
+		}
+		log.Printf("config.manager.apply(): remove [%s]\n", o.Path)
+		if !o.ScanOnly {
+			m.watcher.StopWatching(o.Path)
+		}
+		cancel = append(cancel, o.Path)
+	}
+
+	names := make(map[string]bool)
+	for name := range from.Storage {
+		names[name] = true
+	}
+	for name := range to.Storage {
+		names[name] = true
+	}
+	for name := range names {
+		if reflect.DeepEqual(from.Storage[name], to.Storage[name]) {
+			continue
+		}
+		log.Printf("config.manager.apply(): reload storage [%s]\n", name)
+		if err := m.backup.ReloadStorage("storage." + name); err != nil {
+			log.Printf("[ERROR] config.manager.apply(): storage [%s]: %v\n", name, err)
+		}
+	}
+
+	m.scheduler.Reload()
+	ratelimit.Reload()
+	return cancel
+}
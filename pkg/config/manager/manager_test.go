@@ -0,0 +1,147 @@
+package manager
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/config"
+	"github.com/glower/bakku-app/pkg/message"
+	"github.com/glower/bakku-app/pkg/scheduler"
+	"github.com/glower/bakku-app/pkg/snapshot"
+	"github.com/glower/bakku-app/pkg/storage/memory"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+// newManager returns a manager without a file watcher, the tests use only scan-only directories
+func newManager(ctx context.Context) *Manager {
+	return &Manager{
+		ctx: ctx,
+		snapshot: snapshot.Setup(ctx, &types.GlobalResources{
+			Storage:   memory.New(),
+			MessageCh: make(chan message.Message, 100),
+		}),
+		backup:    &backup.StorageManager{},
+		scheduler: &scheduler.Scheduler{},
+		messageCh: make(chan message.Message, 100),
+		current:   config.Defaults(),
+	}
+}
+
+func TestManager_Update(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakku-manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("BAKKUAPPCONF", os.Getenv("BAKKUAPPCONF"))
+	os.Setenv("BAKKUAPPCONF", dir)
+	file := filepath.Join(dir, "config.yml")
+	viper.SetConfigFile(file)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("Scenario 1: invalid settings are neither written nor applied", func(t *testing.T) {
+		m := newManager(ctx)
+		s := config.Defaults()
+		s.DirsToWatch = []config.Watch{{Path: dir, Active: true, ScanOnly: true, Schedule: "every day"}}
+		err := m.Update(s)
+		if _, ok := err.(config.ValidationErrors); !ok {
+			t.Fatalf("Update() error = %v, want validation errors", err)
+		}
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("the config file was written")
+		}
+		if got := m.Get(); len(got.DirsToWatch) != 0 {
+			t.Errorf("the invalid settings were applied: %+v", got.DirsToWatch)
+		}
+	})
+
+	t.Run("Scenario 2: valid settings are written to the config file", func(t *testing.T) {
+		m := newManager(ctx)
+		s := config.Defaults()
+		s.DirsToWatch = []config.Watch{{Path: dir, Active: true, ScanOnly: true}}
+		if err := m.Update(s); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		written := config.Settings{}
+		if err := yaml.Unmarshal(data, &written); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(written.DirsToWatch, s.DirsToWatch) {
+			t.Errorf("written dirsToWatch = %+v, want %+v", written.DirsToWatch, s.DirsToWatch)
+		}
+	})
+}
+
+func TestManager_Change(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakku-manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newManager(ctx)
+
+	active := config.Defaults()
+	active.DirsToWatch = []config.Watch{{Path: dir, Active: true, ScanOnly: true}}
+	inactive := config.Defaults()
+	inactive.DirsToWatch = []config.Watch{{Path: dir, ScanOnly: true}}
+
+	tests := []struct {
+		name        string
+		settings    *config.Settings
+		wantChanged bool
+		// wantStopped is set if the scan must not run anymore when change returns
+		wantStopped bool
+	}{
+		{
+			name:        "Scenario 1: a new directory is scanned",
+			settings:    active,
+			wantChanged: true,
+		},
+		{
+			name:        "Scenario 2: the same settings are applied only once",
+			settings:    active,
+			wantChanged: false,
+		},
+		{
+			name:        "Scenario 3: the scan of a deactivated directory is stopped",
+			settings:    inactive,
+			wantChanged: true,
+			wantStopped: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.applyM.Lock()
+			changed := m.change(tt.settings)
+			m.applyM.Unlock()
+			if changed != tt.wantChanged {
+				t.Errorf("change() = %v, want %v", changed, tt.wantChanged)
+			}
+			if got := m.Get(); !reflect.DeepEqual(got.DirsToWatch, tt.settings.DirsToWatch) {
+				t.Errorf("current dirsToWatch = %+v, want %+v", got.DirsToWatch, tt.settings.DirsToWatch)
+			}
+			statuses := m.snapshot.ScanStatuses()
+			if len(statuses) != 1 {
+				t.Fatalf("got the scans %+v, want one scan of [%s]", statuses, dir)
+			}
+			if tt.wantStopped && statuses[0].State == snapshot.ScanRunning {
+				t.Errorf("the scan of [%s] is still running", dir)
+			}
+		})
+	}
+}
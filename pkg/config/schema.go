@@ -0,0 +1,100 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Schema returns a JSON schema of the config file generated from the Settings struct
+func Schema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Settings{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "bakku-app configuration"
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": typeSchema(t.Elem()),
+		}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if name := fieldName(f); name != "" {
+				properties[name] = typeSchema(f.Type)
+			}
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// UnknownFields returns an error for every key in the config file which is not part of the schema,
+// a typo like "activ: true" would be silently ignored otherwise
+func UnknownFields() ValidationErrors {
+	var errs ValidationErrors
+	keys := viper.AllKeys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !knownKey(reflect.TypeOf(Settings{}), strings.Split(key, ".")) {
+			errs.add(key, "unknown field")
+		}
+	}
+	return errs
+}
+
+// knownKey walks the settings type along the parts of a key like "storage.gdrive.active"
+func knownKey(t reflect.Type, parts []string) bool {
+	if len(parts) == 0 {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return knownKey(t.Elem(), parts)
+	case reflect.Map:
+		return knownKey(t.Elem(), parts[1:])
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if strings.EqualFold(fieldName(f), parts[0]) {
+				return knownKey(f.Type, parts[1:])
+			}
+		}
+	}
+	return false
+}
+
+func fieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("mapstructure"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
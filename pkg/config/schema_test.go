@@ -0,0 +1,48 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKnownKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		want bool
+	}{
+		{
+			name: "Scenario 1: top level key",
+			key:  "filters",
+			want: true,
+		},
+		{
+			name: "Scenario 2: key in a map of structs, viper keys are lower case",
+			key:  "storage.gdrive.tokenfile",
+			want: true,
+		},
+		{
+			name: "Scenario 3: key in a pointer struct",
+			key:  "bandwidth.limit",
+			want: true,
+		},
+		{
+			name: "Scenario 4: typo in a field name",
+			key:  "storage.local.activ",
+			want: false,
+		},
+		{
+			name: "Scenario 5: unknown section",
+			key:  "database.path",
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := knownKey(reflect.TypeOf(Settings{}), strings.Split(tt.key, ".")); got != tt.want {
+				t.Errorf("knownKey(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,556 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/glower/bakku-app/pkg/checksum"
+	"github.com/glower/bakku-app/pkg/queue"
+	"github.com/glower/bakku-app/pkg/schedule"
+)
+
+// Settings is the complete configuration of the app as it's stored in the config file
+type Settings struct {
+	DirsToWatch []Watch                    `json:"dirsToWatch" yaml:"dirsToWatch" mapstructure:"dirsToWatch"`
+	Storage     map[string]StorageSettings `json:"storage" yaml:"storage" mapstructure:"storage"`
+	// Filters is a list of file name suffixes which are never backuped
+	Filters   []string         `json:"filters,omitempty" yaml:"filters,omitempty" mapstructure:"filters"`
+	Bandwidth *Bandwidth       `json:"bandwidth,omitempty" yaml:"bandwidth,omitempty" mapstructure:"bandwidth"`
+	Snapshot  SnapshotSettings `json:"snapshot" yaml:"snapshot" mapstructure:"snapshot"`
+	Checksum  ChecksumSettings `json:"checksum" yaml:"checksum" mapstructure:"checksum"`
+	Queue     QueueSettings    `json:"queue" yaml:"queue" mapstructure:"queue"`
+	Server    ServerSettings   `json:"server" yaml:"server" mapstructure:"server"`
+	History   HistorySettings  `json:"history" yaml:"history" mapstructure:"history"`
+}
+
+// HistorySettings is the configuration of the backup history log
+type HistorySettings struct {
+	// File is the current log file, a relative path is relative to the config dir
+	File string `json:"file,omitempty" yaml:"file,omitempty" mapstructure:"file"`
+	// MaxSize like 10MB, the file is rotated when it's bigger
+	MaxSize string `json:"maxSize,omitempty" yaml:"maxSize,omitempty" mapstructure:"maxSize"`
+	// MaxFiles is the number of rotated files which are kept
+	MaxFiles int `json:"maxFiles,omitempty" yaml:"maxFiles,omitempty" mapstructure:"maxFiles"`
+}
+
+// ServerSettings is the configuration of the HTTP API
+type ServerSettings struct {
+	// Bind is the address the server listens on, use 0.0.0.0 to listen on all interfaces
+	Bind string       `json:"bind" yaml:"bind" mapstructure:"bind"`
+	Port string       `json:"port" yaml:"port" mapstructure:"port"`
+	Auth AuthSettings `json:"auth" yaml:"auth" mapstructure:"auth"`
+	TLS  TLSSettings  `json:"tls" yaml:"tls" mapstructure:"tls"`
+	CORS CORSSettings `json:"cors" yaml:"cors" mapstructure:"cors"`
+}
+
+// AuthSettings is the configuration of the bearer token authentication
+type AuthSettings struct {
+	Enabled bool `json:"enabled" yaml:"enabled" mapstructure:"enabled"`
+	// TokenFile contains the hashes of all valid tokens, a relative path is relative to the config dir
+	TokenFile string `json:"tokenFile,omitempty" yaml:"tokenFile,omitempty" mapstructure:"tokenFile"`
+}
+
+// TLSSettings is the configuration of HTTPS, a self-signed certificate is created if the files don't exist
+type TLSSettings struct {
+	Enabled  bool   `json:"enabled" yaml:"enabled" mapstructure:"enabled"`
+	CertFile string `json:"certFile,omitempty" yaml:"certFile,omitempty" mapstructure:"certFile"`
+	KeyFile  string `json:"keyFile,omitempty" yaml:"keyFile,omitempty" mapstructure:"keyFile"`
+	// ClientCAFile enables mTLS, only clients with a certificate signed by this CA are accepted
+	ClientCAFile string `json:"clientCAFile,omitempty" yaml:"clientCAFile,omitempty" mapstructure:"clientCAFile"`
+}
+
+// CORSSettings is the configuration of cross origin requests, e.g. from the Electron UI
+type CORSSettings struct {
+	AllowedOrigins []string `json:"allowedOrigins,omitempty" yaml:"allowedOrigins,omitempty" mapstructure:"allowedOrigins"`
+}
+
+// StorageSettings is the configuration of one backup storage, not every storage uses all fields
+type StorageSettings struct {
+	Active     bool     `json:"active" yaml:"active" mapstructure:"active"`
+	Path       string   `json:"path,omitempty" yaml:"path,omitempty" mapstructure:"path"`
+	Schedule   string   `json:"schedule,omitempty" yaml:"schedule,omitempty" mapstructure:"schedule"`
+	QuietHours []string `json:"quietHours,omitempty" yaml:"quietHours,omitempty" mapstructure:"quietHours"`
+	// Verify is a cron expression, the storage is compared with the snapshot on schedule
+	Verify string `json:"verify,omitempty" yaml:"verify,omitempty" mapstructure:"verify"`
+	// Repair uploads missing and corrupted files again after a scheduled verification
+	Repair bool `json:"repair,omitempty" yaml:"repair,omitempty" mapstructure:"repair"`
+	// Timeout is the maximum duration of one upload like 30m, 0 disables it
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout"`
+	// Concurrency is the number of parallel uploads to the storage, 0 uses the default
+	Concurrency     int        `json:"concurrency,omitempty" yaml:"concurrency,omitempty" mapstructure:"concurrency"`
+	Bandwidth       *Bandwidth `json:"bandwidth,omitempty" yaml:"bandwidth,omitempty" mapstructure:"bandwidth"`
+	TokenFile       string     `json:"tokenFile,omitempty" yaml:"tokenFile,omitempty" mapstructure:"tokenFile"`
+	CredentialsFile string     `json:"credentialsFile,omitempty" yaml:"credentialsFile,omitempty" mapstructure:"credentialsFile"`
+	AddLatency      bool       `json:"addLatency,omitempty" yaml:"addLatency,omitempty" mapstructure:"addLatency"`
+}
+
+// SnapshotSettings is the configuration of the snapshot database
+type SnapshotSettings struct {
+	// Default is the database implementation, only boltdb is available
+	Default string `json:"default,omitempty" yaml:"default,omitempty" mapstructure:"default"`
+	// SameDir keeps the snapshot of a watched directory in a database file inside this directory
+	SameDir bool `json:"sameDir" yaml:"sameDir" mapstructure:"sameDir"`
+	// BucketName is the bucket with the metadata of the database like the schema version
+	BucketName string `json:"bucketName,omitempty" yaml:"bucketName,omitempty" mapstructure:"bucketName"`
+	// FileName is the database file in a watched directory
+	FileName string `json:"fileName,omitempty" yaml:"fileName,omitempty" mapstructure:"fileName"`
+}
+
+// ChecksumSettings selects the checksum algorithms: sha256, blake2b, xxhash or default (the one of the file watcher)
+type ChecksumSettings struct {
+	// Change is used by the scan to find changed files, it should be fast
+	Change string `json:"change" yaml:"change" mapstructure:"change"`
+	// Verify is used to check the integrity of the backups
+	Verify string `json:"verify" yaml:"verify" mapstructure:"verify"`
+}
+
+// ChangeAlgorithm returns the algorithm for change detection, the default one if nothing is configured
+func (c ChecksumSettings) ChangeAlgorithm() string {
+	if c.Change == "" {
+		return defaultChangeChecksum
+	}
+	return c.Change
+}
+
+// VerifyAlgorithm returns the algorithm for the verification, the default one if nothing is configured
+func (c ChecksumSettings) VerifyAlgorithm() string {
+	if c.Verify == "" {
+		return defaultVerifyChecksum
+	}
+	return c.Verify
+}
+
+// QueueSettings is the configuration of the order of the uploads
+type QueueSettings struct {
+	// Policy orders the files of a watched directory: smallest or oldest (change) first
+	Policy string `json:"policy" yaml:"policy" mapstructure:"policy"`
+}
+
+// PolicyName returns the configured policy, the default one if nothing is configured
+func (q QueueSettings) PolicyName() string {
+	if q.Policy == "" {
+		return defaultQueuePolicy
+	}
+	return q.Policy
+}
+
+// QueueDirs returns the watched directories with their priorities for the upload queues
+func (s *Settings) QueueDirs() []queue.Dir {
+	dirs := make([]queue.Dir, 0, len(s.DirsToWatch))
+	for _, d := range s.DirsToWatch {
+		dirs = append(dirs, queue.Dir{Path: d.Path, Priority: d.Priority})
+	}
+	return dirs
+}
+
+// FieldError is a validation error of a single config field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors contains all errors found in the config
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, e := range v {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", e.Field, e.Message))
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+func (v *ValidationErrors) add(field, format string, args ...interface{}) {
+	*v = append(*v, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+var (
+	currentM sync.RWMutex
+	current  = Defaults()
+)
+
+// Defaults returns the settings used when nothing is configured
+func Defaults() *Settings {
+	return &Settings{
+		Snapshot: SnapshotSettings{
+			Default:    defaultSnapshotStorage,
+			SameDir:    true,
+			BucketName: defaultSnapshotBucketName,
+			FileName:   defaultSnapshotFileName,
+		},
+		Checksum: ChecksumSettings{
+			Change: defaultChangeChecksum,
+			Verify: defaultVerifyChecksum,
+		},
+		Queue: QueueSettings{
+			Policy: defaultQueuePolicy,
+		},
+		Server: ServerSettings{
+			Bind: defaultBind,
+			Port: defaultPort,
+			Auth: AuthSettings{
+				Enabled:   true,
+				TokenFile: defaultTokenFile,
+			},
+			TLS: TLSSettings{
+				CertFile: defaultCertFile,
+				KeyFile:  defaultKeyFile,
+			},
+		},
+		History: HistorySettings{
+			File:     defaultHistoryFile,
+			MaxSize:  defaultHistoryMaxSize,
+			MaxFiles: defaultHistoryMaxFiles,
+		},
+	}
+}
+
+// Current returns the last valid settings, it's never nil
+func Current() *Settings {
+	currentM.RLock()
+	defer currentM.RUnlock()
+	return current
+}
+
+func setCurrent(s *Settings) {
+	currentM.Lock()
+	defer currentM.Unlock()
+	current = s
+}
+
+// Load reads the settings from the config file with defaults and environment overrides applied
+func Load() (*Settings, error) {
+	s := &Settings{}
+	if err := viper.Unmarshal(s); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal config: %v", err)
+	}
+	return s, nil
+}
+
+// Reload reads and validates the settings, if they are valid they become the current settings
+func Reload() (*Settings, error) {
+	s, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	errs := UnknownFields()
+	if verr, ok := s.Validate().(ValidationErrors); ok {
+		errs = append(errs, verr...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	setCurrent(s)
+	// a missing network share or USB disk doesn't stop the service, the directory is not watched
+	for _, d := range s.DirsToWatch {
+		if err := DirAvailable(d.Path); d.Active && err != nil {
+			log.Printf("[WARNING] config.Reload(): directory [%s] is not available and not watched: %v\n", d.Path, err)
+		}
+	}
+	return s, nil
+}
+
+// DirAvailable returns an error if the directory doesn't exist or is not a directory
+func DirAvailable(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("[%s] is not a directory", path)
+	}
+	return nil
+}
+
+// CheckDirs returns ValidationErrors with every active directory which is not available. It's only checked
+// for changes made with the API, a directory of the config file which is missing is skipped on start.
+func (s *Settings) CheckDirs() error {
+	var errs ValidationErrors
+	for i, d := range s.DirsToWatch {
+		if !d.Active || d.Path == "" {
+			continue
+		}
+		if err := DirAvailable(d.Path); err != nil {
+			errs.add(fmt.Sprintf("dirsToWatch[%d].path", i), "%v", err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// setDefaults registers default values, they are also used for environment overrides of keys not in the config file
+func setDefaults() {
+	viper.SetDefault("server.bind", defaultBind)
+	viper.SetDefault("server.port", defaultPort)
+	viper.SetDefault("server.auth.enabled", true)
+	viper.SetDefault("server.auth.tokenFile", defaultTokenFile)
+	viper.SetDefault("server.tls.certFile", defaultCertFile)
+	viper.SetDefault("server.tls.keyFile", defaultKeyFile)
+	viper.SetDefault("snapshot.default", defaultSnapshotStorage)
+	viper.SetDefault("snapshot.sameDir", true)
+	viper.SetDefault("snapshot.bucketName", defaultSnapshotBucketName)
+	viper.SetDefault("snapshot.fileName", defaultSnapshotFileName)
+	viper.SetDefault("checksum.change", defaultChangeChecksum)
+	viper.SetDefault("checksum.verify", defaultVerifyChecksum)
+	viper.SetDefault("queue.policy", defaultQueuePolicy)
+	viper.SetDefault("history.file", defaultHistoryFile)
+	viper.SetDefault("history.maxSize", defaultHistoryMaxSize)
+	viper.SetDefault("history.maxFiles", defaultHistoryMaxFiles)
+}
+
+// setEnv enables overrides by environment variables like BAKKU_SERVER_PORT for server.port
+func setEnv() {
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+	// BAKKU_PORT is kept for backward compatibility
+	viper.BindEnv("server.port", "BAKKU_PORT")
+}
+
+// Validate checks all settings and returns ValidationErrors with every invalid field
+func (s *Settings) Validate() error {
+	var errs ValidationErrors
+
+	seen := make(map[string]bool)
+	for i, d := range s.DirsToWatch {
+		field := fmt.Sprintf("dirsToWatch[%d]", i)
+		if d.Path == "" {
+			errs.add(field+".path", "must not be empty")
+			continue
+		}
+		clean := filepath.Clean(d.Path)
+		if seen[clean] {
+			errs.add(field+".path", "duplicate directory [%s]", d.Path)
+		}
+		seen[clean] = true
+		if d.Schedule != "" {
+			if _, err := schedule.Parse(d.Schedule); err != nil {
+				errs.add(field+".schedule", "%v", err)
+			}
+		}
+	}
+
+	for name, st := range s.Storage {
+		field := "storage." + name
+		if name == "local" && st.Active && st.Path == "" {
+			errs.add(field+".path", "must not be empty for an active local storage")
+		}
+		if st.Schedule != "" {
+			if _, err := schedule.Parse(st.Schedule); err != nil {
+				errs.add(field+".schedule", "%v", err)
+			}
+		}
+		if st.Verify != "" {
+			if _, err := schedule.Parse(st.Verify); err != nil {
+				errs.add(field+".verify", "%v", err)
+			}
+		}
+		for i, w := range st.QuietHours {
+			if _, err := schedule.ParseWindow(w); err != nil {
+				errs.add(fmt.Sprintf("%s.quietHours[%d]", field, i), "%v", err)
+			}
+		}
+		if st.Timeout != "" {
+			if d, err := time.ParseDuration(st.Timeout); err != nil || d < 0 {
+				errs.add(field+".timeout", "invalid duration [%s]", st.Timeout)
+			}
+		}
+		if st.Concurrency < 0 {
+			errs.add(field+".concurrency", "must not be negative")
+		}
+		st.Bandwidth.validate(field+".bandwidth", &errs)
+	}
+
+	s.Bandwidth.validate("bandwidth", &errs)
+
+	for i, f := range s.Filters {
+		if strings.TrimSpace(f) == "" {
+			errs.add(fmt.Sprintf("filters[%d]", i), "must not be empty")
+		}
+	}
+
+	if s.Server.Port != "" {
+		if port, err := strconv.Atoi(s.Server.Port); err != nil || port <= 0 || port > 65535 {
+			errs.add("server.port", "invalid port [%s]", s.Server.Port)
+		}
+	}
+
+	if s.Server.Bind != "" && net.ParseIP(s.Server.Bind) == nil && !validHostname(s.Server.Bind) {
+		errs.add("server.bind", "invalid address [%s]", s.Server.Bind)
+	}
+	if s.Server.TLS.Enabled && (s.Server.TLS.CertFile == "") != (s.Server.TLS.KeyFile == "") {
+		errs.add("server.tls", "certFile and keyFile must be set together")
+	}
+	if f := s.Server.TLS.ClientCAFile; f != "" {
+		if !s.Server.TLS.Enabled {
+			errs.add("server.tls.clientCAFile", "needs TLS to be enabled")
+		} else if _, err := os.Stat(ConfigDirPath(f)); err != nil {
+			errs.add("server.tls.clientCAFile", "%v", err)
+		}
+	}
+	for i, o := range s.Server.CORS.AllowedOrigins {
+		if o == "" || strings.ContainsAny(o, " ,") {
+			errs.add(fmt.Sprintf("server.cors.allowedOrigins[%d]", i), "invalid origin [%s]", o)
+		}
+	}
+
+	if s.Snapshot.SameDir && s.Snapshot.FileName == "" {
+		errs.add("snapshot.fileName", "must not be empty with sameDir")
+	}
+	if f := s.Snapshot.FileName; f != "" && (filepath.Base(f) != f || f == "." || f == "..") {
+		errs.add("snapshot.fileName", "[%s] must be a file name without directory", f)
+	}
+
+	if a := s.Checksum.Change; a != "" {
+		if err := checksum.Valid(a); err != nil {
+			errs.add("checksum.change", "%v", err)
+		}
+	}
+	if a := s.Checksum.Verify; a != "" {
+		if err := checksum.Valid(a); err != nil {
+			errs.add("checksum.verify", "%v", err)
+		}
+	}
+
+	if p := s.Queue.Policy; p != "" {
+		if err := queue.Valid(p); err != nil {
+			errs.add("queue.policy", "%v", err)
+		}
+	}
+
+	if size, err := ParseSize(s.History.MaxSize); err != nil {
+		errs.add("history.maxSize", "%v", err)
+	} else if s.History.MaxSize != "" && size <= 0 {
+		errs.add("history.maxSize", "must be greater than 0")
+	}
+	if s.History.MaxFiles < 0 {
+		errs.add("history.maxFiles", "must not be negative")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validHostname(host string) bool {
+	for _, part := range strings.Split(host, ".") {
+		if part == "" || len(part) > 63 || strings.Trim(part, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Bandwidth) validate(field string, errs *ValidationErrors) {
+	if b == nil {
+		return
+	}
+	if _, err := ParseSize(b.Limit); err != nil {
+		errs.add(field+".limit", "%v", err)
+	}
+	for i, p := range b.Profiles {
+		if _, err := schedule.ParseWindow(p.Window); err != nil {
+			errs.add(fmt.Sprintf("%s.profiles[%d].window", field, i), "%v", err)
+		}
+		if _, err := ParseSize(p.Limit); err != nil {
+			errs.add(fmt.Sprintf("%s.profiles[%d].limit", field, i), "%v", err)
+		}
+	}
+}
+
+// ConfigFile returns the path to the config file in use
+func ConfigFile() string {
+	if f := viper.ConfigFileUsed(); f != "" {
+		return f
+	}
+	return filepath.Join(GetConfigPath(), defaultConfigName+".yml")
+}
+
+// ConfigDirPath returns the path of a file in the config dir, an absolute path is returned as it is
+func ConfigDirPath(fileName string) string {
+	if filepath.IsAbs(fileName) {
+		return fileName
+	}
+	return filepath.Join(GetConfigPath(), fileName)
+}
+
+// savedHeader is written at the top of a config file which was saved by the service
+const savedHeader = `# bakku-app configuration, written by the service. Comments and formatting are not kept,
+# the file before the last change is %s.
+`
+
+// Save validates the settings, writes them atomically to the config file and reads them in again. The file
+// is rewritten without comments, the previous file is kept with the suffix .bak.
+func Save(s *Settings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("unable to marshal config: %v", err)
+	}
+	file := ConfigFile()
+	backup := file + ".bak"
+	if old, err := ioutil.ReadFile(file); err == nil {
+		if err := WriteFileAtomic(backup, old, 0600); err != nil {
+			return fmt.Errorf("can't keep the previous config file: %v", err)
+		}
+	}
+	data = append([]byte(fmt.Sprintf(savedHeader, filepath.Base(backup))), data...)
+	if err := WriteFileAtomic(file, data, 0600); err != nil {
+		return err
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		return err
+	}
+	// the file watch reads the settings the same way, so they compare equal
+	if saved, err := Load(); err == nil {
+		s = saved
+	}
+	setCurrent(s)
+	return nil
+}
+
+// WriteFileAtomic writes data to a temporary file in the same directory and renames it to the file name,
+// readers see either the old or the new content but never a partial write
+func WriteFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(fileName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
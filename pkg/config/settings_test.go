@@ -0,0 +1,135 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSettings_Validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		settings   Settings
+		wantFields []string
+	}{
+		{
+			name: "Scenario 1: valid settings",
+			settings: Settings{
+				DirsToWatch: []Watch{{Path: os.TempDir(), Active: true, Schedule: "@daily"}},
+				Storage: map[string]StorageSettings{
+					"local": {Active: true, Path: os.TempDir(), QuietHours: []string{"22:00-06:00"}},
+				},
+				Bandwidth: &Bandwidth{Limit: "1MB"},
+			},
+		},
+		{
+			name: "Scenario 2: all errors are reported at once",
+			settings: Settings{
+				DirsToWatch: []Watch{
+					{Path: "", Active: true},
+					{Path: "/does/not/exist", Active: true, Schedule: "every day"},
+				},
+				Storage: map[string]StorageSettings{
+					"local":  {Active: true},
+					"gdrive": {Active: true, QuietHours: []string{"late"}, Bandwidth: &Bandwidth{Limit: "fast"}},
+				},
+				Checksum: ChecksumSettings{Change: "md5"},
+				Queue:    QueueSettings{Policy: "random"},
+			},
+			wantFields: []string{
+				"dirsToWatch[0].path",
+				"dirsToWatch[1].schedule",
+				"storage.local.path",
+				"storage.gdrive.quietHours[0]",
+				"storage.gdrive.bandwidth.limit",
+				"checksum.change",
+				"queue.policy",
+			},
+		},
+		{
+			name: "Scenario 3: duplicate directories",
+			settings: Settings{
+				DirsToWatch: []Watch{{Path: os.TempDir()}, {Path: os.TempDir() + "/"}},
+			},
+			wantFields: []string{"dirsToWatch[1].path"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Validate()
+			if len(tt.wantFields) == 0 {
+				if err != nil {
+					t.Errorf("Settings.Validate(): error was not expected: %v", err)
+				}
+				return
+			}
+			verr, ok := err.(ValidationErrors)
+			if !ok {
+				t.Fatalf("Settings.Validate(): ValidationErrors expected, got %v", err)
+			}
+			got := make(map[string]bool)
+			for _, e := range verr {
+				got[e.Field] = true
+			}
+			for _, f := range tt.wantFields {
+				if !got[f] {
+					t.Errorf("Settings.Validate(): error for [%s] expected, got %v", f, verr)
+				}
+			}
+			if len(verr) != len(tt.wantFields) {
+				t.Errorf("Settings.Validate(): %d errors, want %d: %v", len(verr), len(tt.wantFields), verr)
+			}
+		})
+	}
+}
+
+func TestSettings_CheckDirs(t *testing.T) {
+	s := Settings{
+		DirsToWatch: []Watch{
+			{Path: os.TempDir(), Active: true},
+			{Path: "/does/not/exist", Active: true},
+			{Path: "/does/not/exist/either"},
+		},
+	}
+	if err := s.Validate(); err != nil {
+		t.Errorf("Settings.Validate(): a missing directory must not stop the service, got %v", err)
+	}
+	verr, ok := s.CheckDirs().(ValidationErrors)
+	if !ok || len(verr) != 1 || verr[0].Field != "dirsToWatch[1].path" {
+		t.Errorf("Settings.CheckDirs() = %v, want an error for the missing active directory", verr)
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakku-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("BAKKUAPPCONF", os.Getenv("BAKKUAPPCONF"))
+	os.Setenv("BAKKUAPPCONF", dir)
+	file := filepath.Join(dir, defaultConfigName+".yml")
+	viper.SetConfigFile(file)
+	if err := writeDefaultConfig(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Save(Defaults()); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := ioutil.ReadFile(file + ".bak")
+	if err != nil || string(backup) != defaultConfig {
+		t.Errorf("the commented config file was not kept: %v", err)
+	}
+	saved, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(saved), "# bakku-app configuration, written by the service") {
+		t.Errorf("the saved config file doesn't say that it was rewritten:\n%s", saved)
+	}
+}
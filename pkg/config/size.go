@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var units = []struct {
+	suffix string
+	factor int64
+}{
+	{"GB", 1024 * 1024 * 1024},
+	{"MB", 1024 * 1024},
+	{"KB", 1024},
+	{"G", 1024 * 1024 * 1024},
+	{"M", 1024 * 1024},
+	{"K", 1024},
+	{"B", 1},
+}
+
+// ParseSize parses a size like "512KB", "2MB" or "1048576" into bytes, an empty string is 0
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(s, "/S")
+	factor := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			factor = u.factor
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size [%s]", s)
+	}
+	return int64(v * float64(factor)), nil
+}
+
+// FormatSize formats bytes into a human readable size
+func FormatSize(n int64) string {
+	for _, u := range units[:3] {
+		if n >= u.factor {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(u.factor), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
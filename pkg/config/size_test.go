@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{name: "Scenario 1: empty size", size: "", want: 0},
+		{name: "Scenario 2: bytes", size: "1024", want: 1024},
+		{name: "Scenario 3: kilobytes", size: "512KB", want: 512 * 1024},
+		{name: "Scenario 4: megabytes per second", size: "2 MB/s", want: 2 * 1024 * 1024},
+		{name: "Scenario 5: fractions", size: "1.5M", want: 1536 * 1024},
+		{name: "Scenario 6: wrong size", size: "fast", wantErr: true},
+		{name: "Scenario 7: negative size", size: "-1KB", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSize(%q) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,25 +1,11 @@
 package storage
 
-import (
-	"log"
-
-	"github.com/spf13/viper"
-)
-
 type FakeConfig struct {
 	Active bool
 }
 
 func FakeDriverConfig() *FakeConfig {
-	c := &FakeConfig{}
-
-	storageActiveTpl := "storage.fake.active"
-	active, ok := viper.Get(storageActiveTpl).(bool)
-	if !ok {
-		log.Printf("ProviderConf(): can't find [%s]\n", storageActiveTpl)
-		active = false
+	return &FakeConfig{
+		Active: ProviderConf("fake").Active,
 	}
-	c.Active = active
-
-	return c
 }
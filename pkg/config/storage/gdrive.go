@@ -1,10 +1,6 @@
 package storage
 
-import (
-	"fmt"
-
-	"github.com/spf13/viper"
-)
+import "github.com/glower/bakku-app/pkg/config"
 
 // Must be the same as in pkg/backup/storage/gdrive/gdrive.go
 // const storageName = "gdrive
@@ -19,29 +15,10 @@ type GDriveConfig struct {
 
 // GoogleDriveConfig ...
 func GoogleDriveConfig() *GDriveConfig {
-	gConfig := &GDriveConfig{}
-	conf := ProviderConf(configName)
-	if !conf.Active {
-		gConfig.Config = *conf
-		return gConfig
-	}
-
-	gConfig.Config = *conf
-
-	tokenFileTpl := fmt.Sprintf("storage.%s.tokenFile", configName)
-	tokenFile, ok := viper.Get(tokenFileTpl).(string)
-	if !ok {
-		// log.Printf("GoogleDriveConfig(): can't find [%s] in the config\n", tokenFileTpl)
-		tokenFile = ""
-	}
-	gConfig.TokenFile = tokenFile
-
-	credentialsFileTpl := fmt.Sprintf("storage.%s.credentialsFile", configName)
-	credentialsFile, ok := viper.Get(credentialsFileTpl).(string)
-	if !ok {
-		// log.Printf("GoogleDriveConfig(): can't find [%s] in the config\n", credentialsFile)
-		credentialsFile = ""
+	settings := config.Current().Storage[configName]
+	return &GDriveConfig{
+		Config:          *ProviderConf(configName),
+		TokenFile:       settings.TokenFile,
+		CredentialsFile: settings.CredentialsFile,
 	}
-	gConfig.CredentialsFile = credentialsFile
-	return gConfig
 }
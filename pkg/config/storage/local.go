@@ -1,6 +1,6 @@
 package storage
 
-import "github.com/spf13/viper"
+import "github.com/glower/bakku-app/pkg/config"
 
 // LDriveConfig is a struct for local drive storage configuration
 type LDriveConfig struct {
@@ -10,17 +10,8 @@ type LDriveConfig struct {
 
 // LocalDriveConfig ...
 func LocalDriveConfig() *LDriveConfig {
-	localConf := &LDriveConfig{}
-	conf := ProviderConf("local")
-
-	localConf.Config = *conf
-
-	addLatencyTpl := "storage.local.addLatency"
-	addLatency, ok := viper.Get(addLatencyTpl).(bool)
-	if !ok {
-		addLatency = false
+	return &LDriveConfig{
+		Config:     *ProviderConf("local"),
+		AddLatency: config.Current().Storage["local"].AddLatency,
 	}
-	localConf.AddLatency = addLatency
-
-	return localConf
 }
@@ -3,8 +3,9 @@ package storage
 import (
 	"fmt"
 	"log"
+	"time"
 
-	"github.com/spf13/viper"
+	"github.com/glower/bakku-app/pkg/config"
 )
 
 // Config is a struct for basic storage configuration
@@ -12,39 +13,62 @@ type Config struct {
 	Name   string
 	Path   string
 	Active bool
+	// Schedule is a cron expression, if set all changes are held and uploaded on schedule
+	Schedule string
+	// QuietHours is a list of windows like "22:00-06:00" during which nothing is uploaded
+	QuietHours []string
+	// Verify is a cron expression for scheduled verifications, Repair uploads broken files again
+	Verify string
+	Repair bool
+	// Timeout is the maximum duration of one upload, 0 means no limit
+	Timeout time.Duration
+	// Concurrency is the number of parallel uploads
+	Concurrency int
 }
 
-// ProviderConf ...
+// defaultConcurrency is used if no concurrency is configured
+const defaultConcurrency = 2
+
+// defaultTimeout is used if no timeout is configured, a stuck upload blocks an upload slot until then
+const defaultTimeout = 1 * time.Hour
+
+// ProviderConf returns the basic configuration of a storage like "local" or "gdrive"
 func ProviderConf(name string) *Config {
-	var conf Config
-	storagePathTpl := fmt.Sprintf("storage.%s.path", name)
-	path, ok := viper.Get(storagePathTpl).(string)
+	settings, ok := config.Current().Storage[name]
 	if !ok {
-		log.Printf("config.storage.ProviderConf(): can't find [%s]\n", storagePathTpl)
-		path = ""
+		log.Printf("config.storage.ProviderConf(): can't find [storage.%s]\n", name)
 	}
-	conf.Path = path
-
-	storageActiveTpl := fmt.Sprintf("storage.%s.active", name)
-	active, ok := viper.Get(storageActiveTpl).(bool)
-	if !ok {
-		log.Printf("ProviderConf(): can't find [%s]\n", storageActiveTpl)
-		active = false
+	timeout := defaultTimeout
+	if settings.Timeout != "" {
+		// the value is validated with the config
+		timeout, _ = time.ParseDuration(settings.Timeout)
+	}
+	concurrency := settings.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	return &Config{
+		Name:        name,
+		Path:        settings.Path,
+		Active:      settings.Active,
+		Schedule:    settings.Schedule,
+		QuietHours:  settings.QuietHours,
+		Verify:      settings.Verify,
+		Repair:      settings.Repair,
+		Timeout:     timeout,
+		Concurrency: concurrency,
 	}
-	conf.Active = active
-	return &conf
 }
 
 // Active returns a list of all active storages
 func Active() ([]string, error) {
 	var result []string
-	storages, ok := viper.Get("storage").(map[string]interface{})
-	if !ok {
+	storages := config.Current().Storage
+	if len(storages) == 0 {
 		log.Printf("[ERROR] config.storage.Active(): can't find storage configuration\n")
 		return result, fmt.Errorf("can't find storage configuration")
 	}
-	for name := range storages {
-		storage := ProviderConf(name)
+	for name, storage := range storages {
 		if storage.Active {
 			result = append(result, "storage."+name)
 		}
@@ -0,0 +1,34 @@
+// Package watchdir finds the watched directory of a path. It has no dependencies, so it can be used by
+// every package, also by the ones the config depends on.
+package watchdir
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Contains checks if path is dir or inside of dir, a root like / or C:\ contains every path of its volume
+func Contains(dir, path string) bool {
+	dir = filepath.Clean(dir)
+	path = filepath.Clean(path)
+	if path == dir {
+		return true
+	}
+	// only the root of a volume ends with a separator after Clean
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
+
+// Of returns the longest of the directories which contains the path, an empty string if none does
+func Of(dirs []string, path string) string {
+	found := ""
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if Contains(dir, path) && (found == "" || len(dir) > len(found)) {
+			found = dir
+		}
+	}
+	return found
+}
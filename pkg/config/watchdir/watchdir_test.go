@@ -0,0 +1,48 @@
+package watchdir
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	p := filepath.FromSlash
+	tests := []struct {
+		name string
+		dirs []string
+		path string
+		want string
+	}{
+		{
+			name: "Scenario 1: the longest directory wins",
+			dirs: []string{p("/home"), p("/home/test/docs"), p("/home/test")},
+			path: p("/home/test/docs/a.txt"),
+			want: p("/home/test/docs"),
+		},
+		{
+			name: "Scenario 2: a directory with the same prefix doesn't contain the path",
+			dirs: []string{p("/home/test/doc")},
+			path: p("/home/test/docs/a.txt"),
+			want: "",
+		},
+		{
+			name: "Scenario 3: the root contains every path",
+			dirs: []string{p("/")},
+			path: p("/home/test/a.txt"),
+			want: p("/"),
+		},
+		{
+			name: "Scenario 4: the directory itself",
+			dirs: []string{p("/home/test/")},
+			path: p("/home/test"),
+			want: p("/home/test"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Of(tt.dirs, tt.path); got != tt.want {
+				t.Errorf("Of(%v, %s) = %q, want %q", tt.dirs, tt.path, got, tt.want)
+			}
+		})
+	}
+}
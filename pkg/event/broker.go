@@ -0,0 +1,188 @@
+package event
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/glower/bakku-app/pkg/config/watchdir"
+)
+
+// Types of the published events, the schema of their data is described in events.md
+const (
+	TypeProgress   = "progress"
+	TypeStatus     = "status"
+	TypeMessage    = "message"
+	TypeCompletion = "completion"
+	TypeState      = "state"
+	TypePing       = "ping"
+)
+
+// replaySize is the number of events kept for clients which reconnect
+const replaySize = 1000
+
+// subscriberBuffer is the number of events queued for a slow client before it's disconnected,
+// it can reconnect and get the missed events from the replay buffer
+const subscriberBuffer = 256
+
+// Event is one published event, Data depends on the type
+type Event struct {
+	// ID grows with every event, pings don't have one
+	ID      uint64    `json:"id,omitempty"`
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Storage string    `json:"storage,omitempty"`
+	// Path is the file or the directory the event is about
+	Path string          `json:"path,omitempty"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Filter selects events, empty fields match everything. Events without storage or path are about the
+// whole service, they match every storage and directory.
+type Filter struct {
+	Types    []string
+	Storages []string
+	// Dirs match events of files inside of them
+	Dirs []string
+}
+
+// Match checks if the event is selected by the filter
+func (f *Filter) Match(e *Event) bool {
+	if len(f.Types) > 0 && !containsString(f.Types, e.Type) {
+		return false
+	}
+	if len(f.Storages) > 0 && e.Storage != "" && !containsString(f.Storages, e.Storage) {
+		return false
+	}
+	if len(f.Dirs) == 0 || e.Path == "" {
+		return true
+	}
+	return watchdir.Of(f.Dirs, e.Path) != ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives the published events which match its filter, C is closed when the subscription
+// is canceled or the client is too slow
+type Subscription struct {
+	C      chan Event
+	filter Filter
+	broker *Broker
+}
+
+// Cancel stops the subscription
+func (s *Subscription) Cancel() {
+	s.broker.unsubscribe(s)
+}
+
+// Broker numbers the events, keeps the last of them for replay and sends them to all subscribers
+type Broker struct {
+	sync.Mutex
+	lastID      uint64
+	ring        []Event
+	next        int
+	subscribers map[*Subscription]bool
+}
+
+// NewBroker returns a broker which keeps the last size events
+func NewBroker(size int) *Broker {
+	return &Broker{
+		ring:        make([]Event, 0, size),
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+var defaultBroker = NewBroker(replaySize)
+
+// Publish sends an event to all subscribers of the service
+func Publish(eventType, storage, path string, data interface{}) (Event, error) {
+	return defaultBroker.Publish(eventType, storage, path, data)
+}
+
+// Subscribe returns the events of the service published after lastID and a subscription for new ones
+func Subscribe(lastID uint64, f Filter) ([]Event, *Subscription) {
+	return defaultBroker.Subscribe(lastID, f)
+}
+
+// Publish numbers the event and sends it to all subscribers
+func (b *Broker) Publish(eventType, storage, path string, data interface{}) (Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.lastID++
+	e := Event{
+		ID:      b.lastID,
+		Type:    eventType,
+		Time:    time.Now(),
+		Storage: storage,
+		Path:    path,
+		Data:    raw,
+	}
+	if len(b.ring) < cap(b.ring) {
+		b.ring = append(b.ring, e)
+	} else if cap(b.ring) > 0 {
+		b.ring[b.next] = e
+		b.next = (b.next + 1) % cap(b.ring)
+	}
+	for s := range b.subscribers {
+		if !s.filter.Match(&e) {
+			continue
+		}
+		select {
+		case s.C <- e:
+		default:
+			// the client doesn't keep up, it gets the missed events on reconnect
+			delete(b.subscribers, s)
+			close(s.C)
+		}
+	}
+	return e, nil
+}
+
+// Subscribe returns the kept events after lastID which match the filter and a subscription for new events,
+// no events are lost between both
+func (b *Broker) Subscribe(lastID uint64, f Filter) ([]Event, *Subscription) {
+	b.Lock()
+	defer b.Unlock()
+	var replay []Event
+	if lastID > 0 {
+		for i := range b.ring {
+			e := &b.ring[(b.next+i)%len(b.ring)]
+			if e.ID > lastID && f.Match(e) {
+				replay = append(replay, *e)
+			}
+		}
+	}
+	s := &Subscription{C: make(chan Event, subscriberBuffer), filter: f, broker: b}
+	b.subscribers[s] = true
+	return replay, s
+}
+
+// cancelAll closes all subscriptions
+func (b *Broker) cancelAll() {
+	b.Lock()
+	defer b.Unlock()
+	for s := range b.subscribers {
+		delete(b.subscribers, s)
+		close(s.C)
+	}
+}
+
+func (b *Broker) unsubscribe(s *Subscription) {
+	b.Lock()
+	defer b.Unlock()
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.C)
+	}
+}
@@ -0,0 +1,72 @@
+package event
+
+import (
+	"testing"
+)
+
+func TestBrokerSubscribe(t *testing.T) {
+	b := NewBroker(3)
+	b.Publish(TypeProgress, "storage.local", "/docs/a.txt", 1)
+	b.Publish(TypeProgress, "storage.s3", "/docs/b.txt", 2)
+	b.Publish(TypeStatus, "", "", 3)
+	b.Publish(TypeCompletion, "storage.local", "/photos/c.jpg", 4)
+
+	tests := []struct {
+		name    string
+		lastID  uint64
+		filter  Filter
+		wantIDs []uint64
+	}{
+		{
+			name:   "Scenario 1: no replay without last id",
+			lastID: 0,
+		},
+		{
+			name:    "Scenario 2: only the kept events are replayed",
+			lastID:  1,
+			wantIDs: []uint64{2, 3, 4},
+		},
+		{
+			name:    "Scenario 3: global events match every storage",
+			lastID:  1,
+			filter:  Filter{Storages: []string{"storage.local"}},
+			wantIDs: []uint64{3, 4},
+		},
+		{
+			name:    "Scenario 4: filter by type and directory",
+			lastID:  1,
+			filter:  Filter{Types: []string{TypeProgress, TypeCompletion}, Dirs: []string{"/docs/"}},
+			wantIDs: []uint64{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay, sub := b.Subscribe(tt.lastID, tt.filter)
+			defer sub.Cancel()
+			if len(replay) != len(tt.wantIDs) {
+				t.Fatalf("got %d events, want %v", len(replay), tt.wantIDs)
+			}
+			for i, e := range replay {
+				if e.ID != tt.wantIDs[i] {
+					t.Errorf("event %d has id %d, want %d", i, e.ID, tt.wantIDs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	b := NewBroker(1)
+	_, sub := b.Subscribe(0, Filter{})
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.Publish(TypeStatus, "", "", i)
+	}
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("got %d events before the subscription was closed, want %d", n, subscriberBuffer)
+	}
+	sub.Cancel()
+}
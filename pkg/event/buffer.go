@@ -7,17 +7,34 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/glower/bakku-app/pkg/config"
+	"github.com/glower/bakku-app/pkg/metrics"
+	"github.com/glower/bakku-app/pkg/queue"
+	"github.com/glower/bakku-app/pkg/ratelimit"
 	"github.com/glower/bakku-app/pkg/types"
 	"github.com/glower/file-watcher/notification"
 	"github.com/paulbellamy/ratecounter"
 )
 
 var (
-	eventsM       sync.RWMutex
-	events        = make(map[string]notification.Event)
-	inProgress    int32 // int64?
+	eventsM sync.Mutex
+	// pending are the changed files in the order they are sent
+	pending = queue.New(queue.Default, nil)
+	// sent are the files which were sent and are not uploaded yet, they are sent again with the next batch
+	sent = make(map[string]notification.Event)
+	// held are sent files which wait for their storages, they are not sent again
+	held = make(map[string]bool)
+	// inProgress are the files which were sent and have no result of all their storages yet,
+	// it's only accessed with sync/atomic
+	inProgress    int32
 	done          int32
 	maxInProgress = 5
+	// throttlePause is the current pause in nanoseconds after upload errors
+	throttlePause int64
+
+	progressM sync.Mutex
+	// progress returns the bytes of the files which were sent, it's set by the backup
+	progress func() (done, left int64, storages int)
 
 	// 0, 1, 1, 2, 3, 5, 8, 13, 21, 34
 	throttlingRates = []time.Duration{
@@ -42,12 +59,20 @@ type Buffer struct {
 	evenInCh              chan notification.Event
 	timeout               time.Duration
 
+	// EvenOutCh is unbuffered, so a file which is not taken by the backup stays in the queue
 	EvenOutCh        chan notification.Event
 	BackupCompleteCh chan types.BackupComplete
 	BackupStatusCh   chan types.BackupStatus
 
 	errorsRate  *ratecounter.RateCounter
 	successRate *ratecounter.RateCounter
+	lastStatus  atomic.Value
+
+	// stop ends processEvents and a running send, stopped is closed when processEvents returned
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+	sending  sync.WaitGroup
 }
 
 // NewBuffer ...
@@ -57,41 +82,50 @@ func NewBuffer(ctx context.Context, res *types.GlobalResources) *Buffer {
 		maxElementsInBuffer:   1000,
 		maxElementsInProgress: int32(maxInProgress),
 		timeout:               11 * time.Second,
-		EvenOutCh:             make(chan notification.Event, 3),
+		EvenOutCh:             make(chan notification.Event),
 		BackupStatusCh:        make(chan types.BackupStatus),
 		r:                     res,
 		errorsRate:            ratecounter.NewRateCounter(60 * time.Second),
 		successRate:           ratecounter.NewRateCounter(60 * time.Second),
+		stop:                  make(chan struct{}),
+		stopped:               make(chan struct{}),
 	}
+	configureQueue()
 	go b.processEvents()
 	return b
 }
 
+// configureQueue applies the queue policy and the priorities of the watched directories from the config
+func configureQueue() {
+	conf := config.Current()
+	pending.Configure(conf.Queue.PolicyName(), conf.QueueDirs())
+}
+
 func (b *Buffer) processEvents() {
+	defer close(b.stopped)
 	throttlingOffset := 0
 	checkErrorRate := time.Tick(5 * time.Second)
 	sendBufferTicker := time.Tick(b.timeout)
-	quit := make(chan bool)
+	// quit stops a running send, it's buffered because send may be done already
+	quit := make(chan bool, 1)
 
 	for {
 		select {
 		case <-b.Ctx.Done():
 			return
+		case <-b.stop:
+			return
 		case e := <-b.r.FileWatcher.EventCh:
 			b.setStatus("scanning")
 			b.addEvent(e.AbsolutePath, e)
 		case c := <-b.r.BackupCompleteCh:
-			if c.Success {
-				atomic.AddInt32(&inProgress, -1)
-				atomic.AddInt32(&done, 1)
-				b.successRate.Incr(1)
-				removeEvent(c.FilePath)
-				b.setStatus("uploading")
-			} else {
-				fmt.Printf("[ERROR] buffer: error uploading %s\n", c.FilePath)
-				b.errorsRate.Incr(1)
-			}
+			b.complete(c)
 		case <-checkErrorRate:
+			errors, success := b.errorsRate.Rate(), b.successRate.Rate()
+			// keep the client updated about the throughput while uploading
+			if status, ok := b.lastStatus.Load().(string); ok && ratelimit.Throughput() > 0 {
+				b.setStatus(status)
+			}
 			if b.errorsRate.Rate() == 0 && b.successRate.Rate() > 0 && throttlingOffset > 0 {
 				throttlingOffset = 0
 				newTimeout := throttlingRates[throttlingOffset]
@@ -99,11 +133,12 @@ func (b *Buffer) processEvents() {
 				b.errorsRate = ratecounter.NewRateCounter(newTimeout)
 				sendBufferTicker = time.Tick(b.timeout)
 
-			} else if b.errorsRate.Rate() > 0 && inProgress > 0 {
-				for i := 0; i < len(b.EvenOutCh); i++ {
-					<-b.EvenOutCh
+			} else if b.errorsRate.Rate() > 0 && atomic.LoadInt32(&inProgress) > 0 {
+				// the sent files which are not uploaded are queued again with the next send
+				select {
+				case quit <- true:
+				default:
 				}
-				quit <- true
 
 				if throttlingOffset < len(throttlingRates)-1 {
 					throttlingOffset++
@@ -114,25 +149,109 @@ func (b *Buffer) processEvents() {
 				b.successRate = ratecounter.NewRateCounter(2 * newTimeout)
 				b.errorsRate = ratecounter.NewRateCounter(2 * newTimeout)
 			}
+			updateMetrics(throttlingOffset, errors, success)
 		case <-sendBufferTicker:
-			if len(events) != 0 && inProgress == 0 {
-				go b.send(quit)
+			if totalEvents() != 0 && atomic.LoadInt32(&inProgress) == 0 {
+				b.sending.Add(1)
+				go func() {
+					defer b.sending.Done()
+					b.send(quit)
+				}()
 			}
 		}
 	}
 }
 
-// TODO: we can have multiple BackupDone events for the same file (different backup provider!)
-// so the `done` counter needs to be fixed somehow!
+// complete counts the result of an upload, a file is only done when all its storages have it.
+// A file whose attempt is finished without being done is sent again with the next batch.
+func (b *Buffer) complete(c types.BackupComplete) {
+	switch {
+	case c.StorageName == "":
+		// the file was not uploaded, e.g. it's held by all storages
+	case c.Success:
+		b.successRate.Incr(1)
+	default:
+		fmt.Printf("[ERROR] buffer: error uploading %s to %s\n", c.FilePath, c.StorageName)
+		b.errorsRate.Incr(1)
+	}
+	if c.Finished && atomic.AddInt32(&inProgress, -1) < 0 {
+		// the counter was reset by the throttling
+		atomic.StoreInt32(&inProgress, 0)
+	}
+	if c.Done {
+		atomic.AddInt32(&done, 1)
+		removeEvent(c.FilePath)
+	} else if c.Finished && c.Held {
+		holdEvent(c.FilePath)
+	}
+	if c.Success || c.Finished || c.Done {
+		b.setStatus("uploading")
+	}
+}
+
+// OnProgress sets the function which returns the bytes uploaded since the queue was empty, the bytes left of
+// the files which were sent and the number of active storages
+func OnProgress(fn func() (done, left int64, storages int)) {
+	progressM.Lock()
+	defer progressM.Unlock()
+	progress = fn
+}
+
+// queueBytes returns the uploaded bytes and all bytes of the queue, the files which were not sent yet
+// are counted once per storage
+func queueBytes() (int64, int64) {
+	progressM.Lock()
+	fn := progress
+	progressM.Unlock()
+	if fn == nil {
+		return 0, 0
+	}
+	done, left, storages := fn()
+	return done, done + left + pending.Bytes()*int64(storages)
+}
+
+// updateMetrics exports the throttling state, the buffer is throttled after the first error
+func updateMetrics(throttlingOffset int, errors, success int64) {
+	throttled, pause := 0.0, 0.0
+	if throttlingOffset > 0 {
+		throttled = 1
+		pause = throttlingRates[throttlingOffset].Seconds()
+	}
+	atomic.StoreInt64(&throttlePause, int64(pause*float64(time.Second)))
+	metrics.Gauge(metrics.Throttled).Update(throttled)
+	metrics.Gauge(metrics.ThrottlePause).Update(pause)
+	metrics.Gauge(metrics.UploadErrorRate).Update(float64(errors))
+	metrics.Gauge(metrics.UploadRate).Update(float64(success))
+}
+
+// ThrottlePause returns how long the buffer pauses sending files after upload errors, 0 if it's not throttled
+func ThrottlePause() time.Duration {
+	return time.Duration(atomic.LoadInt64(&throttlePause))
+}
+
+// send sends the queued files to the backup, the files which were sent before and are not done are sent again
 func (b *Buffer) send(quit chan bool) {
-	for _, e := range events {
+	// a quit for the last batch which was done already
+	select {
+	case <-quit:
+	default:
+	}
+	configureQueue()
+	requeueSent()
+	for {
+		e, ok := nextEvent()
+		if !ok {
+			break
+		}
 		select {
 		case <-quit:
 			fmt.Println("[INFO] buffer: stopped sending")
 			atomic.StoreInt32(&inProgress, 0)
 			return
-		default:
-			b.EvenOutCh <- e
+		case <-b.stop:
+			// the file is kept as sent and returned by Stop
+			return
+		case b.EvenOutCh <- e:
 			fmt.Printf("[INFO] buffer: send to backup: %s\n", e.AbsolutePath)
 			atomic.AddInt32(&inProgress, 1)
 		}
@@ -140,24 +259,105 @@ func (b *Buffer) send(quit chan bool) {
 	b.setStatus("waiting")
 }
 
+// Stop ends the processing of events and returns the files which are not uploaded yet: the queued ones and
+// the sent ones without a result of all their storages. The buffer is empty afterwards.
+func (b *Buffer) Stop() []notification.Event {
+	b.stopOnce.Do(func() { close(b.stop) })
+	<-b.stopped
+	b.sending.Wait()
+
+	eventsM.Lock()
+	defer eventsM.Unlock()
+	var result []notification.Event
+	for {
+		e, ok := pending.Pop()
+		if !ok {
+			break
+		}
+		result = append(result, e)
+	}
+	for path, e := range sent {
+		result = append(result, e)
+		delete(sent, path)
+		delete(held, path)
+	}
+	return result
+}
+
+// Bump sends a changed file before all others, it returns false if the file is not waiting
+func Bump(path string) bool {
+	return pending.Bump(path)
+}
+
 func (b *Buffer) setStatus(status string) {
-	b.BackupStatusCh <- types.BackupStatus{
-		FilesDone:       int(done),
-		FilesInProgress: int(inProgress),
-		TotalFiles:      len(events),
+	b.lastStatus.Store(status)
+	s := types.BackupStatus{
+		FilesDone:       int(atomic.LoadInt32(&done)),
+		FilesInProgress: int(atomic.LoadInt32(&inProgress)),
+		TotalFiles:      totalEvents(),
 		Status:          status,
+		Bandwidth:       ratelimit.Statuses(),
+		Throughput:      ratelimit.Throughput(),
 	}
+	s.BytesDone, s.BytesTotal = queueBytes()
+	if s.Throughput > 0 {
+		s.ETA = (s.BytesTotal - s.BytesDone + s.Throughput - 1) / s.Throughput
+	}
+	b.BackupStatusCh <- s
 }
 
-// addEvent adds an event to the internal cache
+// addEvent adds an event to the queue, a file which was sent already is sent again after it changed
 func (b *Buffer) addEvent(path string, e notification.Event) {
 	eventsM.Lock()
 	defer eventsM.Unlock()
-	events[path] = e
+	delete(sent, path)
+	delete(held, path)
+	pending.Push(e)
+}
+
+// nextEvent takes the next file from the queue, it's kept as sent until it is uploaded
+func nextEvent() (notification.Event, bool) {
+	eventsM.Lock()
+	defer eventsM.Unlock()
+	e, ok := pending.Pop()
+	if ok {
+		sent[e.AbsolutePath] = e
+	}
+	return e, ok
+}
+
+// requeueSent puts the files which were sent but not uploaded back into the queue, held files are not sent again
+func requeueSent() {
+	eventsM.Lock()
+	defer eventsM.Unlock()
+	for path, e := range sent {
+		if held[path] {
+			continue
+		}
+		pending.Push(e)
+		delete(sent, path)
+	}
 }
 
 func removeEvent(path string) {
 	eventsM.Lock()
 	defer eventsM.Unlock()
-	delete(events, path)
+	delete(sent, path)
+	delete(held, path)
+}
+
+// holdEvent keeps a sent file until its storages upload it
+func holdEvent(path string) {
+	eventsM.Lock()
+	defer eventsM.Unlock()
+	if _, ok := sent[path]; ok {
+		held[path] = true
+	}
+}
+
+// totalEvents returns the number of files which are not uploaded yet
+func totalEvents() int {
+	eventsM.Lock()
+	defer eventsM.Unlock()
+	return pending.Len() + len(sent)
 }
@@ -4,65 +4,203 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/glower/bakku-app/pkg/message"
 	"github.com/glower/bakku-app/pkg/types"
 	"github.com/glower/file-watcher/notification"
 	"github.com/gorilla/mux"
-	"github.com/r3labs/sse"
 )
 
-var streams = []string{"files", "messages", "ping", "status"}
+// HeartbeatInterval is the time between two pings, it keeps proxies from closing idle connections
+const HeartbeatInterval = 15 * time.Second
 
-// SSE ...
+// legacyStreams are the streams of the first version of /events, they send only the data of one event type
+var legacyStreams = map[string]string{
+	"files":    TypeProgress,
+	"messages": TypeMessage,
+	"status":   TypeStatus,
+	"ping":     TypePing,
+}
+
+// SSE publishes the events of the service as server-sent events on /events
 type SSE struct {
 	ctx context.Context
 
-	streams []string
-	server  *sse.Server
-	router  *mux.Router
+	router *mux.Router
+	broker *Broker
+	// done is closed by StopSSE to end all connections
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
 // NewSSE ...
 func NewSSE(ctx context.Context, router *mux.Router, backupProgressCh chan types.BackupProgress, res *types.GlobalResources, eventBuffer *Buffer) *SSE {
-	events := sse.New()
-	for _, name := range streams {
-		events.CreateStream(name)
-	}
-
 	s := &SSE{
-		ctx:     ctx,
-		streams: streams,
-		server:  events,
-		router:  router,
+		ctx:    ctx,
+		router: router,
+		broker: defaultBroker,
+		done:   make(chan struct{}),
 	}
 
 	s.addEventRoute()
 
-	// backupProgressCh chan types.BackupProgress
-	// errorCh chan notification.Error
-	// messageCh chan message.Message
-	// fileBackupCompleteBCh broadcast.Broadcast
 	go s.processBackupStatus(eventBuffer.BackupStatusCh)
 	go s.processErrors(res.FileWatcher.ErrorCh, res.MessageCh)
 	go s.processProgressCallback(backupProgressCh)
-	// go s.ping()
 
 	return s
 }
 
-// StopSSE stops SSE service
+// StopSSE closes all connections, the subscriptions of other streams like the WebSocket are closed as well
 func (s *SSE) StopSSE() {
-	for _, name := range s.streams {
-		s.server.RemoveStream(name)
-	}
-	s.server.Close()
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.broker.cancelAll()
+	})
 }
 
 func (s *SSE) addEventRoute() {
-	s.router.Methods("GET").Path("/events").HandlerFunc(s.server.HTTPHandler)
+	s.router.Methods("GET").Path("/events").HandlerFunc(s.ServeHTTP)
+}
+
+// ServeHTTP streams the events which match the query parameters type, storage and dir (all comma separated).
+// A client which reconnects with the header Last-Event-ID gets the events it missed first.
+func (s *SSE) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	f := FilterFromQuery(r.URL.Query())
+	stream := r.URL.Query().Get("stream")
+	if stream != "" {
+		eventType, ok := legacyStreams[stream]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown stream [%s]", stream), http.StatusNotFound)
+			return
+		}
+		f.Types = []string{eventType}
+	}
+	lastID, err := LastEventID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	replay, sub := s.broker.Subscribe(lastID, f)
+	defer sub.Cancel()
+	legacy := stream != ""
+	for i := range replay {
+		writeEvent(w, &replay[i], legacy)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		case <-s.done:
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			writeEvent(w, &e, legacy)
+		case now := <-heartbeat.C:
+			ping := Ping(now)
+			if f.Match(&ping) {
+				writeEvent(w, &ping, legacy)
+			} else {
+				io.WriteString(w, ": heartbeat\n\n")
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes an event in the SSE format, the legacy streams only get the data
+func writeEvent(w io.Writer, e *Event, legacy bool) {
+	if e.ID > 0 {
+		fmt.Fprintf(w, "id: %d\n", e.ID)
+	}
+	data := []byte(e.Data)
+	if !legacy {
+		fmt.Fprintf(w, "event: %s\n", e.Type)
+		data, _ = json.Marshal(e)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// Ping is the heartbeat, it's not kept for replay
+func Ping(now time.Time) Event {
+	data, _ := json.Marshal(message.Message{
+		Message: "ping",
+		Type:    "INFO",
+		Source:  "main",
+		Time:    now,
+	})
+	return Event{Type: TypePing, Time: now, Data: data}
+}
+
+// FilterFromQuery reads the filter from the parameters type, storage and dir, all of them are comma separated lists
+func FilterFromQuery(q url.Values) Filter {
+	return Filter{
+		Types:    splitList(q.Get("type")),
+		Storages: storageNames(splitList(q.Get("storage"))),
+		Dirs:     splitList(q.Get("dir")),
+	}
+}
+
+// LastEventID reads the id of the last event the client got, browsers send it in the header on reconnect
+func LastEventID(r *http.Request) (uint64, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("lastEventId")
+	}
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid event id [%s]", value)
+	}
+	return id, nil
+}
+
+func splitList(value string) []string {
+	var result []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// storageNames accepts the names of the config like local as well as storage.local
+func storageNames(names []string) []string {
+	for i, name := range names {
+		if !strings.HasPrefix(name, "storage.") {
+			names[i] = "storage." + name
+		}
+	}
+	return names
 }
 
 func (s *SSE) processBackupStatus(status chan types.BackupStatus) {
@@ -76,13 +214,7 @@ func (s *SSE) processBackupStatus(status chan types.BackupStatus) {
 			} else {
 				fmt.Printf("[SSE] processBackupStatus(): [%s] [%d to sync] (%d done)\n", bs.Status, bs.TotalFiles, bs.FilesDone)
 			}
-			stautsJSON, err := json.Marshal(bs)
-			if err != nil {
-				stautsJSON = []byte(fmt.Sprintf(`{"message": "%s", "type": "error"}`, err.Error()))
-			}
-			s.server.Publish("status", &sse.Event{
-				Data: stautsJSON,
-			})
+			s.publish(TypeStatus, "", "", bs)
 		}
 	}
 }
@@ -98,15 +230,7 @@ func (s *SSE) processProgressCallback(backupProgressCh chan types.BackupProgress
 			if strings.Contains(progress.FileName, ".snapshot") {
 				continue
 			}
-			// fmt.Printf("[SSE] ProcessProgressCallback(): [%s] [%s]\t%.2f%%\n", progress.StorageName, progress.FileName, progress.Percent)
-			progressJSON, err := json.Marshal(progress)
-			if err != nil {
-				progressJSON = []byte(fmt.Sprintf(`{"message": "%s", "type": "error"}`, err.Error()))
-			}
-			// file fotification for the frontend client over the SSE
-			s.server.Publish("files", &sse.Event{
-				Data: progressJSON,
-			})
+			s.publish(TypeProgress, progress.StorageName, progress.AbsolutePath, progress)
 		}
 	}
 }
@@ -122,37 +246,26 @@ func (s *SSE) processErrors(errorCh chan notification.Error, messageCh chan mess
 					Type:    err.Level,
 					Message: err.Message,
 					Source:  "watcher",
-				}, "messages")
+					Time:    time.Now(),
+				})
 			}
 		case msg := <-messageCh:
-			s.publishEventMessage(msg, "messages")
+			s.publishEventMessage(msg)
 		}
 	}
 }
 
-func (s *SSE) ping() {
-	for {
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-time.After(60 * time.Second):
-			s.publishEventMessage(message.Message{
-				Message: "ping",
-				Type:    "INFO",
-				Source:  "main",
-				Time:    time.Now(),
-			}, "ping")
-		}
+// publishEventMessage publishes a message, messages of a storage have its name as source
+func (s *SSE) publishEventMessage(msg message.Message) {
+	storage := ""
+	if strings.HasPrefix(msg.Source, "storage.") {
+		storage = msg.Source
 	}
+	s.publish(TypeMessage, storage, "", msg)
 }
 
-func (s *SSE) publishEventMessage(msg message.Message, channel string) {
-	messageJSON, err := json.Marshal(msg)
-	if err != nil {
-		messageJSON = []byte(fmt.Sprintf(`{"message": "%s", "type": "error"}`, err.Error()))
+func (s *SSE) publish(eventType, storage, path string, data interface{}) {
+	if _, err := s.broker.Publish(eventType, storage, path, data); err != nil {
+		fmt.Printf("[ERROR] SSE.publish(): can't publish the %s event: %v\n", eventType, err)
 	}
-	// fmt.Printf("[SSE] [%s] %s: %s\n", msg.Type, msg.Source, msg.Message)
-	s.server.Publish(channel, &sse.Event{
-		Data: messageJSON,
-	})
 }
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/glower/bakku-app/pkg/config"
+	"github.com/glower/bakku-app/pkg/ratelimit"
+)
+
+// BandwidthRequest is the body of a request to change a limit, an empty limit resets it to the configured value
+type BandwidthRequest struct {
+	Limit string `json:"limit"`
+}
+
+// Bandwidth returns current limits and throughput
+func Bandwidth(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, ratelimit.Statuses())
+}
+
+// UpdateBandwidth changes the limit of a storage or the global limit at runtime
+func UpdateBandwidth(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	req := &BandwidthRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		Error(w, http.StatusBadRequest, "unable to decode request: "+err.Error())
+		return
+	}
+
+	var err error
+	if req.Limit == "" {
+		err = ratelimit.Reset(name)
+	} else {
+		var limit int64
+		limit, err = config.ParseSize(req.Limit)
+		if err != nil {
+			Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		err = ratelimit.Set(name, limit)
+	}
+	if err != nil {
+		Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, ratelimit.Statuses())
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/storage/record"
+)
+
+var contentTypes = map[string]string{
+	record.FormatJSONL: "application/x-ndjson",
+	record.FormatCSV:   "text/csv",
+}
+
+// ExportSnapshot writes the snapshot records as JSON lines or CSV, the query parameters are format
+// (jsonl or csv), storage (comma separated) and path (prefix)
+func (res *Resources) ExportSnapshot(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := exportFormat(q)
+	if err := record.ValidFormat(format); err != nil {
+		Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.Header().Set("Content-Disposition", "attachment; filename=snapshot."+format)
+	if _, err := record.Export(res.Storage, w, format, exportFilter(q)); err != nil {
+		// the status is already sent, the client gets a truncated export
+		log.Printf("[ERROR] handlers.ExportSnapshot(): %v\n", err)
+	}
+}
+
+// ImportSnapshot reads exported snapshot records from the body, besides the query parameters of the
+// export it accepts overwrite, dry-run and from and to to replace a path prefix
+func (res *Resources) ImportSnapshot(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := exportFormat(q)
+	if err := record.ValidFormat(format); err != nil {
+		Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opt := record.ImportOptions{
+		Filter:    exportFilter(q),
+		Overwrite: q.Get("overwrite") == "true",
+		DryRun:    q.Get("dry-run") == "true",
+		From:      q.Get("from"),
+		To:        q.Get("to"),
+	}
+	report, err := record.Import(res.Storage, r.Body, format, opt)
+	if err != nil {
+		Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, report)
+}
+
+func exportFormat(q url.Values) string {
+	if f := q.Get("format"); f != "" {
+		return f
+	}
+	return record.FormatJSONL
+}
+
+func exportFilter(q url.Values) record.Filter {
+	f := record.Filter{Prefix: q.Get("path")}
+	if s := q.Get("storage"); s != "" {
+		for _, name := range strings.Split(s, ",") {
+			f.Storages = append(f.Storages, backup.StorageName(name))
+		}
+	}
+	return f
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/catalog"
+
+	"github.com/glower/file-watcher/notification"
+)
+
+// RestoreRequest is the body of a restore request, an empty target restores the file to its original location.
+// An existing file is only replaced with overwrite.
+type RestoreRequest struct {
+	Storage   string `json:"storage"`
+	Path      string `json:"path"`
+	To        string `json:"to,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
+
+// VerifyRequest is the body of a verify request, all files with the path prefix are verified
+type VerifyRequest struct {
+	Storage string `json:"storage"`
+	Path    string `json:"path,omitempty"`
+}
+
+// Files returns all backuped files of a storage, the query parameter path filters by a path prefix
+func (res *Resources) Files(w http.ResponseWriter, r *http.Request) {
+	storageName := r.URL.Query().Get("storage")
+	if storageName == "" {
+		Error(w, http.StatusBadRequest, "storage is missing")
+		return
+	}
+	entries, err := catalog.List(res.Storage, backup.StorageName(storageName), r.URL.Query().Get("path"))
+	if err != nil {
+		ServerError(w, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, entries)
+}
+
+// Versions returns the backuped version of a file in every storage
+func (res *Resources) Versions(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		Error(w, http.StatusBadRequest, "path is missing")
+		return
+	}
+	var names []string
+	for name := range backup.Registered() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries, err := catalog.Versions(res.Storage, names, path)
+	if err != nil {
+		Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, entries)
+}
+
+// Restore copies a backuped file from a storage to the local disk
+func (res *Resources) Restore(w http.ResponseWriter, r *http.Request) {
+	req := &RestoreRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		Error(w, http.StatusBadRequest, "unable to decode request: "+err.Error())
+		return
+	}
+	if req.Storage == "" || req.Path == "" {
+		Error(w, http.StatusBadRequest, "storage and path are required")
+		return
+	}
+	storageName := backup.StorageName(req.Storage)
+	to, err := catalog.Restore(res.Storage, storageName, req.Path, req.To, req.Overwrite, func(e *notification.Event, to string) error {
+		return backup.Restore(storageName, e, to)
+	})
+	if _, ok := err.(catalog.TargetExistsError); ok {
+		Error(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		ServerError(w, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, map[string]string{"restored": to})
+}
+
+// Verify compares the backuped files of a storage with the checksums in the snapshot
+func (res *Resources) Verify(w http.ResponseWriter, r *http.Request) {
+	req := &VerifyRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		Error(w, http.StatusBadRequest, "unable to decode request: "+err.Error())
+		return
+	}
+	if req.Storage == "" {
+		Error(w, http.StatusBadRequest, "storage is missing")
+		return
+	}
+	storageName := backup.StorageName(req.Storage)
+	results, err := backup.Verify(res.Storage, storageName, req.Path, func(e *notification.Event, to string) error {
+		return backup.Restore(storageName, e, to)
+	})
+	if err != nil {
+		ServerError(w, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, results)
+}
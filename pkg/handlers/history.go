@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/history"
+)
+
+// defaultHistoryLimit and maxHistoryLimit are the page sizes of the history
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// History returns the logged uploads, deletions and restores, the newest first. The records can be
+// filtered with the query parameters path (prefix), storage, action, status, since and until (RFC 3339),
+// offset and limit select the page.
+func (res *Resources) History(w http.ResponseWriter, r *http.Request) {
+	filter, err := historyFilter(r.URL.Query())
+	if err != nil {
+		Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := history.Default().Query(*filter)
+	if err != nil {
+		ServerError(w, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, page)
+}
+
+func historyFilter(q url.Values) (*history.Filter, error) {
+	f := &history.Filter{
+		Prefix: q.Get("path"),
+		Action: q.Get("action"),
+		Result: q.Get("status"),
+		Limit:  defaultHistoryLimit,
+	}
+	if s := q.Get("storage"); s != "" {
+		f.Storage = backup.StorageName(s)
+	}
+	var err error
+	if f.Since, err = parseTime(q, "since"); err != nil {
+		return nil, err
+	}
+	if f.Until, err = parseTime(q, "until"); err != nil {
+		return nil, err
+	}
+	if f.Offset, err = parseInt(q, "offset", 0); err != nil {
+		return nil, err
+	}
+	if f.Limit, err = parseInt(q, "limit", defaultHistoryLimit); err != nil {
+		return nil, err
+	}
+	if f.Limit == 0 || f.Limit > maxHistoryLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxHistoryLimit)
+	}
+	return f, nil
+}
+
+func parseTime(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s [%s], expected a time like 2019-06-30T15:04:05Z", name, v)
+	}
+	return t, nil
+}
+
+func parseInt(q url.Values, name string, def int) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid %s [%s]", name, v)
+	}
+	return i, nil
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/glower/bakku-app/pkg/auth"
+	"github.com/glower/bakku-app/pkg/config"
+)
+
+// publicPaths are available without a token, they are used for health checks and the test UI
+var publicPaths = map[string]bool{
+	"/":       true,
+	"/health": true,
+	"/ping":   true,
+}
+
+// streamPaths are the SSE stream and the WebSocket, browsers can't set headers for them so the token can be
+// passed as a query parameter
+var streamPaths = map[string]bool{
+	"/events": true,
+	"/ws":     true,
+}
+
+var (
+	tokensM sync.Mutex
+	tokens  *auth.Tokens
+	// tokenFile is the file tokens was created for, it changes when the config changes
+	tokenFile string
+)
+
+// Secure wraps the router with CORS and bearer token authentication. It has to wrap the whole router and
+// not be a mux middleware, otherwise CORS preflight requests and the SSE route would not be covered.
+// The settings are read on every request, so config changes apply without a restart.
+func Secure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settings := config.Current().Server
+		if cors(w, r, settings.CORS) {
+			return
+		}
+		if settings.Auth.Enabled && !publicPaths[r.URL.Path] && !authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bakku-app"`)
+			Error(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Tokens returns the token store of the configured token file
+func Tokens() *auth.Tokens {
+	file := config.ConfigDirPath(config.Current().Server.Auth.TokenFile)
+	tokensM.Lock()
+	defer tokensM.Unlock()
+	if tokens == nil || tokenFile != file {
+		tokens = auth.NewTokens(file)
+		tokenFile = file
+	}
+	return tokens
+}
+
+func authorized(r *http.Request) bool {
+	token := ""
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	} else if streamPaths[r.URL.Path] {
+		token = r.URL.Query().Get("access_token")
+	}
+	return Tokens().Valid(token)
+}
+
+// cors sets the CORS headers for allowed origins and answers preflight requests, it returns true
+// if the request was answered
+func cors(w http.ResponseWriter, r *http.Request, s config.CORSSettings) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !allowedOrigin(origin, s.AllowedOrigins) {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Add("Vary", "Origin")
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.Header().Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	}
+	return false
+}
+
+func allowedOrigin(origin string, allowed []string) bool {
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestSecure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakku-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("BAKKUAPPCONF", dir)
+	defer os.Unsetenv("BAKKUAPPCONF")
+
+	token, err := Tokens().Create("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(Secure(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{
+			name: "Scenario 1: public path without token",
+			path: "/ping",
+			want: http.StatusOK,
+		},
+		{
+			name: "Scenario 2: API without token",
+			path: "/api/status",
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:   "Scenario 3: API with a valid token",
+			path:   "/api/status",
+			header: "Bearer " + token,
+			want:   http.StatusOK,
+		},
+		{
+			name:   "Scenario 4: API with an invalid token",
+			path:   "/api/status",
+			header: "Bearer bakku_invalid",
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name: "Scenario 5: SSE stream with the token as query parameter",
+			path: "/events?stream=files&access_token=" + token,
+			want: http.StatusOK,
+		},
+		{
+			name: "Scenario 6: token as query parameter is only accepted for the SSE stream",
+			path: "/api/status?access_token=" + token,
+			want: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", ts.URL+tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Errorf("Status code for %s is wrong. Have: %d, want: %d.", tt.path, res.StatusCode, tt.want)
+			}
+		})
+	}
+}
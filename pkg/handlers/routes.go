@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 
+	"github.com/glower/bakku-app/pkg/backup"
 	"github.com/glower/bakku-app/pkg/config"
+	"github.com/glower/bakku-app/pkg/config/manager"
+	"github.com/glower/bakku-app/pkg/metrics"
+	"github.com/glower/bakku-app/pkg/snapshot"
+	"github.com/glower/bakku-app/pkg/storage"
 	"github.com/glower/file-watcher/watcher"
 
 	"github.com/gorilla/mux"
@@ -14,10 +20,11 @@ import (
 // Resources ...
 type Resources struct {
 	FileWatcher *watcher.Watch
-	// TODO: need here
-	// 1. file watcher object
-	// 2. snapshot manager here
-	// OR some sort of config manager
+	Snapshot    *snapshot.Snapshot
+	Config      *manager.Manager
+	Backup      *backup.StorageManager
+	// Storage is the snapshot database
+	Storage storage.Storager
 }
 
 // Router register necessary routes and returns an instance of a router.
@@ -26,9 +33,40 @@ func (res *Resources) Router() *mux.Router {
 	r.Methods("GET").Path("/").HandlerFunc(Index)
 	r.Methods("GET").Path("/health").HandlerFunc(StatusOK)
 	r.Methods("GET").Path("/ping").HandlerFunc(Ping)
+	r.Methods("GET").Path("/metrics").HandlerFunc(metrics.Handler)
+	r.Methods("GET").Path("/ws").HandlerFunc(res.WebSocket)
 
-	r.Methods("GET").Path("/api/config").HandlerFunc(Config)
-	r.Methods("PSOT").Path("/api/config").HandlerFunc(res.UpdateConfig)
+	r.Methods("GET").Path("/api/config").HandlerFunc(res.GetConfig)
+	r.Methods("PUT", "POST").Path("/api/config").HandlerFunc(res.UpdateConfig)
+	r.Methods("GET").Path("/api/config/schema").HandlerFunc(ConfigSchema)
+
+	r.Methods("GET").Path("/api/scans").HandlerFunc(res.Scans)
+	r.Methods("POST").Path("/api/scans").HandlerFunc(res.Rescan)
+	r.Methods("DELETE").Path("/api/scans").HandlerFunc(res.CancelScan)
+
+	r.Methods("GET").Path("/api/status").HandlerFunc(res.Status)
+	r.Methods("GET").Path("/api/status/storages/{name}").HandlerFunc(res.StorageStatus)
+	r.Methods("GET").Path("/api/status/dirs").HandlerFunc(res.DirStatus)
+	r.Methods("GET").Path("/api/queue").HandlerFunc(res.Queue)
+	r.Methods("POST").Path("/api/queue/cancel").HandlerFunc(res.CancelUpload)
+	r.Methods("POST").Path("/api/queue/prioritize").HandlerFunc(res.PrioritizeUpload)
+	r.Methods("POST").Path("/api/storages/{name}/pause").HandlerFunc(res.PauseStorage)
+	r.Methods("POST").Path("/api/storages/{name}/resume").HandlerFunc(res.ResumeStorage)
+	r.Methods("POST").Path("/api/storages/{name}/drain").HandlerFunc(res.DrainStorage)
+	r.Methods("POST").Path("/api/storages/{name}/verify").HandlerFunc(res.ScrubStorage)
+	r.Methods("GET").Path("/api/verifications").HandlerFunc(res.Scrubs)
+	r.Methods("POST").Path("/api/storages/{name}/reindex").HandlerFunc(res.ReindexStorage)
+
+	r.Methods("GET").Path("/api/files").HandlerFunc(res.Files)
+	r.Methods("GET").Path("/api/versions").HandlerFunc(res.Versions)
+	r.Methods("POST").Path("/api/restore").HandlerFunc(res.Restore)
+	r.Methods("POST").Path("/api/verify").HandlerFunc(res.Verify)
+	r.Methods("GET").Path("/api/history").HandlerFunc(res.History)
+	r.Methods("GET").Path("/api/snapshot/export").HandlerFunc(res.ExportSnapshot)
+	r.Methods("POST").Path("/api/snapshot/import").HandlerFunc(res.ImportSnapshot)
+
+	r.Methods("GET").Path("/api/bandwidth").HandlerFunc(Bandwidth)
+	r.Methods("PUT").Path("/api/bandwidth/{name}").HandlerFunc(UpdateBandwidth)
 
 	return r
 }
@@ -50,42 +88,66 @@ func Index(res http.ResponseWriter, _ *http.Request) {
 	fmt.Fprintf(res, "%s", body)
 }
 
-// Config returns local configuration
-func Config(w http.ResponseWriter, r *http.Request) {
-	// TODO: return complete configuration
-	conf, err := config.DirectoriesToWatch()
-	if err != nil {
-		ServerError(w, err.Error())
+// GetConfig returns the complete configuration
+func (res *Resources) GetConfig(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, res.Config.Get())
+}
+
+// ConfigSchema returns the JSON schema of the configuration
+func ConfigSchema(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, config.Schema())
+}
+
+// UpdateConfig validates the new configuration, writes it to the config file and applies it. The file is
+// rewritten without comments, the previous one is kept as config.yml.bak.
+func (res *Resources) UpdateConfig(w http.ResponseWriter, r *http.Request) {
+	conf := &config.Settings{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(conf); err != nil {
+		Error(w, http.StatusBadRequest, "unable to decode config: "+err.Error())
+		return
+	}
+	// a missing directory is only rejected here, in the config file it's skipped so the service still starts
+	err := conf.CheckDirs()
+	if err == nil {
+		err = res.Config.Update(conf)
+	}
+	if verr, ok := err.(config.ValidationErrors); ok {
+		JSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":  "invalid config",
+			"fields": verr,
+		})
 		return
 	}
-	json, err := conf.ToJSON()
 	if err != nil {
 		ServerError(w, err.Error())
 		return
 	}
+	JSON(w, http.StatusOK, res.Config.Get())
+}
+
+// ServerError returns the status code 500 with an error message
+func ServerError(w http.ResponseWriter, m string) {
+	Error(w, http.StatusInternalServerError, m)
+}
+
+// Error returns the given status code with an error message
+func Error(w http.ResponseWriter, code int, m string) {
+	body, _ := json.Marshal(map[string]string{"error": m})
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(json))
+	w.WriteHeader(code)
+	w.Write(body)
 }
 
-func (res *Resources) UpdateConfig(w http.ResponseWriter, r *http.Request) {
-	// TODO:
-	// 1. Add/Remove directories to file watcher
-	// 	  watcher.Add(dir)/watcher.Remove(dir)
-	// 2. Scan new dir:
-	//    snapshot.Add(dir)
-	// 3. Update the config
-	// OR call some config manager and let the manager manage all this
-
-	conf, err := config.FromJSON(r.Body)
+// JSON writes v as JSON response with the given status code
+func JSON(w http.ResponseWriter, code int, v interface{}) {
+	body, err := json.Marshal(v)
 	if err != nil {
 		ServerError(w, err.Error())
 		return
 	}
-	fmt.Printf("api.UpdateConfig(): %v\n", conf)
-	w.WriteHeader(201)
-}
-
-func ServerError(w http.ResponseWriter, m string) {
-	w.WriteHeader(500)
-	w.Write([]byte(fmt.Sprintf(`{"error", "%s"}`, m)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
 }
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/glower/bakku-app/pkg/config"
+)
+
+// ScanRequest is the body of a rescan request
+type ScanRequest struct {
+	Path string `json:"path"`
+}
+
+// Scans returns the status of all scan jobs
+func (res *Resources) Scans(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, res.Snapshot.ScanStatuses())
+}
+
+// Rescan triggers a scan of one watched directory
+func (res *Resources) Rescan(w http.ResponseWriter, r *http.Request) {
+	path, ok := res.watchedPath(w, r)
+	if !ok {
+		return
+	}
+	status, err := res.Snapshot.Scan(path)
+	if err != nil {
+		Error(w, http.StatusConflict, err.Error())
+		return
+	}
+	JSON(w, http.StatusAccepted, status)
+}
+
+// CancelScan stops a running scan of one watched directory
+func (res *Resources) CancelScan(w http.ResponseWriter, r *http.Request) {
+	path, ok := res.watchedPath(w, r)
+	if !ok {
+		return
+	}
+	if err := res.Snapshot.CancelScan(path); err != nil {
+		Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// watchedPath reads the directory from the request and checks that it is watched
+func (res *Resources) watchedPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	req := &ScanRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		Error(w, http.StatusBadRequest, "unable to decode request: "+err.Error())
+		return "", false
+	}
+	path, err := watchedDir(req.Path)
+	if err != nil {
+		Error(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	return path, true
+}
+
+// watchedDir returns the active watched directory with the path as it is configured
+func watchedDir(path string) (string, error) {
+	conf, err := config.DirectoriesToWatch()
+	if err != nil {
+		return "", err
+	}
+	for _, d := range conf.DirsToWatch {
+		if d.Active && filepath.Clean(d.Path) == filepath.Clean(path) {
+			return d.Path, nil
+		}
+	}
+	return "", fmt.Errorf("directory is not watched: %s", path)
+}
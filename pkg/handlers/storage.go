@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/event"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+// Status returns the state of all backup storages
+func (res *Resources) Status(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, res.Backup.Statuses())
+}
+
+// StorageStatus returns the state of one backup storage
+func (res *Resources) StorageStatus(w http.ResponseWriter, r *http.Request) {
+	name := backup.StorageName(mux.Vars(r)["name"])
+	for _, s := range res.Backup.Statuses() {
+		if s.Name == name {
+			JSON(w, http.StatusOK, s)
+			return
+		}
+	}
+	Error(w, http.StatusNotFound, fmt.Sprintf("unknown storage [%s]", name))
+}
+
+// DirStatus returns the state of the backups of all watched directories, the query parameter path
+// selects one directory
+func (res *Resources) DirStatus(w http.ResponseWriter, r *http.Request) {
+	scans := make(map[string]string)
+	if res.Snapshot != nil {
+		for _, s := range res.Snapshot.ScanStatuses() {
+			scans[filepath.Clean(s.Path)] = s.State
+		}
+	}
+	path := r.URL.Query().Get("path")
+	result := []types.DirStatus{}
+	for _, s := range res.Backup.DirStatuses() {
+		if path != "" && s.Path != filepath.Clean(path) {
+			continue
+		}
+		s.Scan = scans[s.Path]
+		result = append(result, s)
+	}
+	if path != "" && len(result) == 0 {
+		Error(w, http.StatusNotFound, fmt.Sprintf("[%s] is not a watched directory", path))
+		return
+	}
+	JSON(w, http.StatusOK, result)
+}
+
+// Queue returns all files which are uploaded right now or held
+func (res *Resources) Queue(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, res.Backup.Queue())
+}
+
+// PauseStorage stops the running uploads of a storage and holds all files until it is resumed
+func (res *Resources) PauseStorage(w http.ResponseWriter, r *http.Request) {
+	if err := res.Backup.Pause(backup.StorageName(mux.Vars(r)["name"])); err != nil {
+		Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, res.Backup.Statuses())
+}
+
+// ResumeStorage uploads all files held while the storage was paused
+func (res *Resources) ResumeStorage(w http.ResponseWriter, r *http.Request) {
+	if err := res.Backup.Resume(backup.StorageName(mux.Vars(r)["name"])); err != nil {
+		Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, res.Backup.Statuses())
+}
+
+// DrainStorage finishes the running uploads of a storage and pauses it afterwards
+func (res *Resources) DrainStorage(w http.ResponseWriter, r *http.Request) {
+	if err := res.Backup.Drain(backup.StorageName(mux.Vars(r)["name"])); err != nil {
+		Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, res.Backup.Statuses())
+}
+
+// QueueRequest selects a file in the queue of a storage
+type QueueRequest struct {
+	Path    string `json:"path"`
+	Storage string `json:"storage"`
+}
+
+// CancelUpload stops the upload of a queued or running file, it's uploaded again when it changes
+func (res *Resources) CancelUpload(w http.ResponseWriter, r *http.Request) {
+	res.queueCommand(w, r, res.Backup.Cancel)
+}
+
+// PrioritizeUpload uploads a file before all others, a held file is uploaded even if its storage is paused
+func (res *Resources) PrioritizeUpload(w http.ResponseWriter, r *http.Request) {
+	res.queueCommand(w, r, res.prioritize)
+}
+
+// prioritize moves a waiting file to the front of the queue and releases it if it's held for the storage
+func (res *Resources) prioritize(path, storageName string) error {
+	bumped := event.Bump(path)
+	if err := res.Backup.Prioritize(path, storageName); err != nil && !bumped {
+		return err
+	}
+	return nil
+}
+
+func (res *Resources) queueCommand(w http.ResponseWriter, r *http.Request, fn func(path, storageName string) error) {
+	req := &QueueRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		Error(w, http.StatusBadRequest, "unable to decode request: "+err.Error())
+		return
+	}
+	if err := fn(req.Path, backup.StorageName(req.Storage)); err != nil {
+		Error(w, http.StatusConflict, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, res.Backup.Queue())
+}
+
+// ScrubRequest is the body of a verification request of a storage
+type ScrubRequest struct {
+	// Repair uploads missing and corrupted files again
+	Repair bool `json:"repair"`
+}
+
+// ScrubStorage starts a verification which compares all files in the storage with the snapshot,
+// the result is published as SSE message
+func (res *Resources) ScrubStorage(w http.ResponseWriter, r *http.Request) {
+	req := &ScrubRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			Error(w, http.StatusBadRequest, "unable to decode request: "+err.Error())
+			return
+		}
+	}
+	report, err := res.Backup.Scrub(backup.StorageName(mux.Vars(r)["name"]), req.Repair)
+	if err != nil {
+		Error(w, http.StatusConflict, err.Error())
+		return
+	}
+	JSON(w, http.StatusAccepted, report)
+}
+
+// ReindexStorage rebuilds the snapshot records of a storage from its content, the body are the ReindexOptions
+func (res *Resources) ReindexStorage(w http.ResponseWriter, r *http.Request) {
+	opt := backup.ReindexOptions{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+			Error(w, http.StatusBadRequest, "unable to decode request: "+err.Error())
+			return
+		}
+	}
+	report, err := res.Backup.Reindex(r.Context(), backup.StorageName(mux.Vars(r)["name"]), opt)
+	if err != nil {
+		ServerError(w, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, report)
+}
+
+// Scrubs returns the report of the last verification of every storage
+func (res *Resources) Scrubs(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, backup.ScrubReports())
+}
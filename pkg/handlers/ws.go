@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/config"
+	"github.com/glower/bakku-app/pkg/event"
+)
+
+// Commands which can be sent over the WebSocket
+const (
+	CommandPause      = "pause"
+	CommandResume     = "resume"
+	CommandDrain      = "drain"
+	CommandRetry      = "retry"
+	CommandRescan     = "rescan"
+	CommandCancel     = "cancel"
+	CommandPrioritize = "prioritize"
+)
+
+// TypeResponse is the type of the answers to commands, they are sent between the events
+const TypeResponse = "response"
+
+// Command is sent by a WebSocket client, the response has the same request id
+type Command struct {
+	RequestID string `json:"request_id"`
+	Command   string `json:"command"`
+	Storage   string `json:"storage,omitempty"`
+	// Path is the file for retry, cancel and prioritize and the watched directory for rescan
+	Path string `json:"path,omitempty"`
+}
+
+// CommandResponse is the answer to a command
+type CommandResponse struct {
+	Type      string      `json:"type"`
+	RequestID string      `json:"request_id"`
+	OK        bool        `json:"ok"`
+	Error     string      `json:"error,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// WebSocket streams the same events as /events and executes the commands sent by the client,
+// the events are filtered with the same query parameters
+func (res *Resources) WebSocket(w http.ResponseWriter, r *http.Request) {
+	f := event.FilterFromQuery(r.URL.Query())
+	lastID, err := event.LastEventID(r)
+	if err != nil {
+		Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s := websocket.Server{
+		Handshake: checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			res.serveWebSocket(ws, f, lastID)
+		},
+	}
+	s.ServeHTTP(w, r)
+}
+
+func (res *Resources) serveWebSocket(ws *websocket.Conn, f event.Filter, lastID uint64) {
+	defer ws.Close()
+	replay, sub := event.Subscribe(lastID, f)
+	defer sub.Cancel()
+	for i := range replay {
+		if err := websocket.JSON.Send(ws, &replay[i]); err != nil {
+			return
+		}
+	}
+
+	// the commands are read in their own goroutine, Send is safe to be used by both
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			cmd := Command{}
+			if err := websocket.JSON.Receive(ws, &cmd); err != nil {
+				return
+			}
+			if err := websocket.JSON.Send(ws, res.execute(&cmd)); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(event.HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-closed:
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			err = websocket.JSON.Send(ws, &e)
+		case now := <-heartbeat.C:
+			if ping := event.Ping(now); f.Match(&ping) {
+				err = websocket.JSON.Send(ws, &ping)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// execute runs a command and returns its response
+func (res *Resources) execute(cmd *Command) *CommandResponse {
+	resp := &CommandResponse{Type: TypeResponse, RequestID: cmd.RequestID}
+	storageName := backup.StorageName(cmd.Storage)
+	var err error
+	switch cmd.Command {
+	case CommandPause:
+		err = res.Backup.Pause(storageName)
+	case CommandResume:
+		err = res.Backup.Resume(storageName)
+	case CommandDrain:
+		err = res.Backup.Drain(storageName)
+	case CommandRetry:
+		err = res.Backup.Retry(cmd.Path, storageName)
+	case CommandCancel:
+		err = res.Backup.Cancel(cmd.Path, storageName)
+	case CommandPrioritize:
+		err = res.prioritize(cmd.Path, storageName)
+	case CommandRescan:
+		var path string
+		if path, err = watchedDir(cmd.Path); err == nil {
+			resp.Data, err = res.Snapshot.Scan(path)
+		}
+	default:
+		err = fmt.Errorf("unknown command [%s]", cmd.Command)
+	}
+	if err != nil {
+		log.Printf("[ERROR] handlers.execute(): command [%s] failed: %v\n", cmd.Command, err)
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.OK = true
+	return resp
+}
+
+// checkOrigin allows clients without origin like the CLI, the same host and the origins allowed by CORS
+func checkOrigin(c *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if u.Host == r.Host || allowedOrigin(origin, config.Current().Server.CORS.AllowedOrigins) {
+		c.Origin = u
+		return nil
+	}
+	return fmt.Errorf("origin [%s] is not allowed", origin)
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/event"
+)
+
+func TestWebSocket(t *testing.T) {
+	re := Resources{Backup: &backup.StorageManager{}}
+	ts := httptest.NewServer(re.Router())
+	defer ts.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?type=message", "", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	tests := []struct {
+		name    string
+		cmd     Command
+		wantErr string
+	}{
+		{
+			name:    "Scenario 1: unknown command",
+			cmd:     Command{RequestID: "1", Command: "format"},
+			wantErr: "unknown command [format]",
+		},
+		{
+			name:    "Scenario 2: pause an unknown storage",
+			cmd:     Command{RequestID: "2", Command: CommandPause, Storage: "floppy"},
+			wantErr: "unknown storage [storage.floppy]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := websocket.JSON.Send(ws, &tt.cmd); err != nil {
+				t.Fatal(err)
+			}
+			resp := CommandResponse{}
+			if err := websocket.JSON.Receive(ws, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Type != TypeResponse || resp.RequestID != tt.cmd.RequestID || resp.OK || resp.Error != tt.wantErr {
+				t.Errorf("got %+v, want the error %q for request %s", resp, tt.wantErr, tt.cmd.RequestID)
+			}
+		})
+	}
+
+	event.Publish(event.TypeStatus, "", "", "filtered")
+	event.Publish(event.TypeMessage, "", "", "hello")
+	e := event.Event{}
+	if err := websocket.JSON.Receive(ws, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != event.TypeMessage || string(e.Data) != `"hello"` {
+		t.Errorf("got event %s with %s, want the message", e.Type, e.Data)
+	}
+}
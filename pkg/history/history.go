@@ -0,0 +1,271 @@
+// Package history is an append-only log of all uploads, deletions and restores. The log is a file
+// with one JSON record per line, it's rotated when it gets too big.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glower/bakku-app/pkg/config"
+)
+
+// Actions which are logged
+const (
+	ActionStore   = "store"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+)
+
+// Results of an action
+const (
+	ResultOK       = "ok"
+	ResultFailed   = "failed"
+	ResultCanceled = "canceled"
+	ResultSkipped  = "skipped"
+)
+
+// defaultMaxSize is used when the max size is not configured
+const defaultMaxSize = 10 * 1024 * 1024
+
+// Record is one attempt to store, delete or restore a file
+type Record struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	File     string    `json:"file"`
+	Storage  string    `json:"storage"`
+	Size     int64     `json:"size,omitempty"`
+	Checksum string    `json:"checksum,omitempty"`
+	// Duration is in milliseconds
+	Duration int64  `json:"duration_ms"`
+	Result   string `json:"result"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Log is a history file with its rotated files file.1 (the newest) to file.<maxFiles>
+type Log struct {
+	// the mutex serializes the writers
+	sync.Mutex
+	// filesM is locked for reading by queries, the files are only renamed and maxFiles is only changed
+	// while it's locked for writing. Appends don't wait for queries unless the log is rotated.
+	filesM   sync.RWMutex
+	file     string
+	maxSize  int64
+	maxFiles int
+}
+
+// New returns the log for the given file, rotated files beyond maxFiles are deleted
+func New(file string, maxSize int64, maxFiles int) *Log {
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	return &Log{
+		file:     file,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+}
+
+var (
+	defaultLogM sync.Mutex
+	defaultLog  *Log
+)
+
+// Default returns the log configured in the config file, it changes when the config changes
+func Default() *Log {
+	s := config.Current().History
+	file := config.ConfigDirPath(s.File)
+	maxSize, _ := config.ParseSize(s.MaxSize)
+
+	defaultLogM.Lock()
+	defer defaultLogM.Unlock()
+	if defaultLog == nil || defaultLog.file != file {
+		defaultLog = New(file, maxSize, s.MaxFiles)
+	}
+	defaultLog.configure(maxSize, s.MaxFiles)
+	return defaultLog
+}
+
+func (l *Log) configure(maxSize int64, maxFiles int) {
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.maxSize = maxSize
+	l.filesM.Lock()
+	l.maxFiles = maxFiles
+	l.filesM.Unlock()
+}
+
+// Add writes a record to the default log, errors are only logged because the history must never stop a backup
+func Add(r Record) {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	if err := Default().Append(r); err != nil {
+		log.Printf("[ERROR] history.Add(): %v\n", err)
+	}
+}
+
+// Append writes the record at the end of the log and rotates the log if it gets too big
+func (l *Log) Append(r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.Lock()
+	defer l.Unlock()
+	if info, err := os.Stat(l.file); err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("can't rotate [%s]: %v", l.file, err)
+		}
+	}
+	f, err := os.OpenFile(l.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate renames file to file.1, file.1 to file.2 and so on, the oldest file is deleted
+func (l *Log) rotate() error {
+	l.filesM.Lock()
+	defer l.filesM.Unlock()
+	if l.maxFiles <= 0 {
+		return os.Remove(l.file)
+	}
+	os.Remove(l.rotated(l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(l.rotated(i), l.rotated(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.file, l.rotated(1))
+}
+
+func (l *Log) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", l.file, i)
+}
+
+// files returns all log files from the oldest to the newest
+func (l *Log) files() []string {
+	var result []string
+	for i := l.maxFiles; i >= 1; i-- {
+		result = append(result, l.rotated(i))
+	}
+	return append(result, l.file)
+}
+
+// Filter selects records, empty fields match everything
+type Filter struct {
+	// Prefix of the file path
+	Prefix  string
+	Storage string
+	Action  string
+	Result  string
+	Since   time.Time
+	Until   time.Time
+	Offset  int
+	Limit   int
+}
+
+func (f *Filter) match(r *Record) bool {
+	switch {
+	case f.Prefix != "" && !strings.HasPrefix(r.File, f.Prefix):
+		return false
+	case f.Storage != "" && r.Storage != f.Storage:
+		return false
+	case f.Action != "" && r.Action != f.Action:
+		return false
+	case f.Result != "" && r.Result != f.Result:
+		return false
+	case !f.Since.IsZero() && r.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !r.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// Page is one page of the records found by a query, Total is the number of all matching records
+type Page struct {
+	Total   int      `json:"total"`
+	Offset  int      `json:"offset"`
+	Limit   int      `json:"limit"`
+	Records []Record `json:"records"`
+}
+
+// Query returns the matching records, the newest first. A limit of 0 returns all records.
+// Records which are appended during the query may be missing.
+func (l *Log) Query(f Filter) (*Page, error) {
+	l.filesM.RLock()
+	defer l.filesM.RUnlock()
+
+	// only the newest offset+limit matches are kept, the files are read from the oldest to the newest
+	keep := f.Offset + f.Limit
+	var last []Record
+	total := 0
+	for _, file := range l.files() {
+		err := readFile(file, func(r *Record) {
+			if !f.match(r) {
+				return
+			}
+			if f.Limit <= 0 || len(last) < keep {
+				last = append(last, *r)
+			} else {
+				last[total%keep] = *r
+			}
+			total++
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	page := &Page{
+		Total:   total,
+		Offset:  f.Offset,
+		Limit:   f.Limit,
+		Records: []Record{},
+	}
+	// the newest match is the one before the next free slot of the ring
+	for i := f.Offset; i < total && i < len(last); i++ {
+		if f.Limit > 0 && len(page.Records) == f.Limit {
+			break
+		}
+		page.Records = append(page.Records, last[(total-1-i)%len(last)])
+	}
+	return page, nil
+}
+
+// readFile calls fn for every record in the file, invalid lines like a partly written last line are skipped
+func readFile(file string, fn func(*Record)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		r := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		fn(&r)
+	}
+	return scanner.Err()
+}
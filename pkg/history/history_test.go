@@ -0,0 +1,146 @@
+package history
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakku-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// every record is ~200 bytes, so the log is rotated after a few records
+	l := New(filepath.Join(dir, "history.jsonl"), 1024, 2)
+	start := time.Date(2019, 6, 30, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		r := Record{
+			Time:    start.Add(time.Duration(i) * time.Minute),
+			Action:  ActionStore,
+			File:    fmt.Sprintf("/home/test/docs/%d.txt", i),
+			Storage: "storage.local",
+			Result:  ResultOK,
+		}
+		if i%2 == 1 {
+			r.Storage = "storage.gdrive"
+			r.Result = ResultFailed
+			r.Error = "timeout"
+		}
+		if err := l.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(l.rotated(3)); !os.IsNotExist(err) {
+		t.Fatalf("only 2 rotated files are expected, got %v", err)
+	}
+
+	all, err := l.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newest := all.Records[0]
+	if newest.File != "/home/test/docs/19.txt" {
+		t.Fatalf("newest record is expected first, got [%s]", newest.File)
+	}
+
+	tests := []struct {
+		name      string
+		filter    Filter
+		wantTotal int
+		wantFirst string
+		wantLen   int
+	}{
+		{
+			name:      "Scenario 1: failed uploads to one storage",
+			filter:    Filter{Storage: "storage.gdrive", Result: ResultFailed},
+			wantTotal: (all.Total + 1) / 2,
+			wantFirst: "/home/test/docs/19.txt",
+			wantLen:   (all.Total + 1) / 2,
+		},
+		{
+			name:      "Scenario 2: path prefix",
+			filter:    Filter{Prefix: "/home/test/docs/18"},
+			wantTotal: 1,
+			wantFirst: "/home/test/docs/18.txt",
+			wantLen:   1,
+		},
+		{
+			name:      "Scenario 3: time range",
+			filter:    Filter{Since: start.Add(15 * time.Minute), Until: start.Add(17 * time.Minute)},
+			wantTotal: 2,
+			wantFirst: "/home/test/docs/16.txt",
+			wantLen:   2,
+		},
+		{
+			name:      "Scenario 4: second page",
+			filter:    Filter{Offset: 2, Limit: 3},
+			wantTotal: all.Total,
+			wantFirst: "/home/test/docs/17.txt",
+			wantLen:   3,
+		},
+		{
+			name:      "Scenario 5: last page which is not full",
+			filter:    Filter{Offset: all.Total - 2, Limit: 3},
+			wantTotal: all.Total,
+			wantFirst: all.Records[all.Total-2].File,
+			wantLen:   2,
+		},
+		{
+			name:      "Scenario 6: offset after the last record",
+			filter:    Filter{Offset: 100, Limit: 3},
+			wantTotal: all.Total,
+			wantLen:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := l.Query(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != tt.wantTotal || len(page.Records) != tt.wantLen {
+				t.Fatalf("Query() total=%d len=%d, want total=%d len=%d", page.Total, len(page.Records), tt.wantTotal, tt.wantLen)
+			}
+			if tt.wantLen > 0 && page.Records[0].File != tt.wantFirst {
+				t.Errorf("Query() first record is [%s], want [%s]", page.Records[0].File, tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestLog_AppendDuringQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakku-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l := New(filepath.Join(dir, "history.jsonl"), 1024*1024, 2)
+
+	// a running query holds the files
+	l.filesM.RLock()
+	done := make(chan error)
+	go func() { done <- l.Append(Record{Action: ActionStore, File: "/home/test/a.txt", Result: ResultOK}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Append() waits for the query")
+	}
+	l.filesM.RUnlock()
+
+	page, err := l.Query(Filter{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(page.Records) != 1 {
+		t.Errorf("Query() = %+v, want the appended record", page)
+	}
+}
@@ -0,0 +1,175 @@
+// Package metrics collects the internal metrics of the service and exports them in the Prometheus text format
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// Names of all metrics of the service
+const (
+	FilesQueued      = "bakku_files_queued_total"
+	FilesUploaded    = "bakku_files_uploaded_total"
+	FilesFailed      = "bakku_files_failed_total"
+	BytesTransferred = "bakku_bytes_transferred_total"
+	UploadDuration   = "bakku_upload_duration_seconds"
+	ScanDuration     = "bakku_scan_duration_seconds"
+	QueueDepth       = "bakku_queue_depth"
+	Throttled        = "bakku_throttled"
+	ThrottlePause    = "bakku_throttle_pause_seconds"
+	UploadErrorRate  = "bakku_upload_errors_per_interval"
+	UploadRate       = "bakku_uploads_per_interval"
+	LastBackup       = "bakku_last_backup_timestamp_seconds"
+	Throughput       = "bakku_throughput_bytes_per_second"
+)
+
+// Types of the exported metrics
+const (
+	counterType = "counter"
+	gaugeType   = "gauge"
+	summaryType = "summary"
+)
+
+type description struct {
+	kind string
+	help string
+}
+
+var descriptions = map[string]description{
+	FilesQueued:      {counterType, "Files queued for the upload per storage."},
+	FilesUploaded:    {counterType, "Files uploaded successfully per storage."},
+	FilesFailed:      {counterType, "Failed uploads per storage."},
+	BytesTransferred: {counterType, "Bytes sent to the storage."},
+	UploadDuration:   {summaryType, "Duration of one upload per storage."},
+	ScanDuration:     {summaryType, "Duration of a scan of a watched directory."},
+	QueueDepth:       {gaugeType, "Files waiting for the upload per storage, state is uploading or held."},
+	Throttled:        {gaugeType, "1 if the event buffer paused sending files because of upload errors."},
+	ThrottlePause:    {gaugeType, "Duration of the current throttling pause of the event buffer."},
+	UploadErrorRate:  {gaugeType, "Upload errors counted by the event buffer in the current throttling interval."},
+	UploadRate:       {gaugeType, "Successful uploads counted by the event buffer in the current throttling interval."},
+	LastBackup:       {gaugeType, "Unix time of the last successful upload per watched directory."},
+	Throughput:       {gaugeType, "Current upload throughput of all storages."},
+}
+
+var (
+	registry = gometrics.NewRegistry()
+
+	// summaries are not stored in the registry, it only accepts its own metric types
+	summariesM sync.Mutex
+	summaries  = make(map[string]*Durations)
+
+	collectorsM sync.Mutex
+	// collectors update computed metrics before they are exported
+	collectors []func()
+)
+
+// Counter returns the counter with the given name and label pairs like "storage", "local"
+func Counter(name string, labels ...string) gometrics.Counter {
+	return gometrics.GetOrRegisterCounter(key(name, labels), registry)
+}
+
+// Gauge returns the gauge with the given name and label pairs
+func Gauge(name string, labels ...string) gometrics.GaugeFloat64 {
+	return gometrics.GetOrRegisterGaugeFloat64(key(name, labels), registry)
+}
+
+// Summary returns the summary with the given name and label pairs
+func Summary(name string, labels ...string) *Durations {
+	k := key(name, labels)
+	summariesM.Lock()
+	defer summariesM.Unlock()
+	d, ok := summaries[k]
+	if !ok {
+		d = &Durations{
+			histogram: gometrics.NewHistogram(gometrics.NewExpDecaySample(1028, 0.015)),
+		}
+		summaries[k] = d
+	}
+	return d
+}
+
+// OnCollect registers a function which updates computed metrics like the queue depth, it's called before every export
+func OnCollect(f func()) {
+	collectorsM.Lock()
+	defer collectorsM.Unlock()
+	collectors = append(collectors, f)
+}
+
+func collect() {
+	collectorsM.Lock()
+	defer collectorsM.Unlock()
+	for _, f := range collectors {
+		f()
+	}
+}
+
+// Durations is a histogram of durations, the sum is kept separately because the histogram only
+// sums its sample and Prometheus expects the sum of all observations
+type Durations struct {
+	sync.Mutex
+	histogram gometrics.Histogram
+	sum       float64
+}
+
+// Observe adds a duration
+func (d *Durations) Observe(duration time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+	d.histogram.Update(int64(duration))
+	d.sum += duration.Seconds()
+}
+
+// snapshot returns the count, the sum in seconds and the quantiles in seconds
+func (d *Durations) snapshot(quantiles []float64) (int64, float64, []float64) {
+	d.Lock()
+	defer d.Unlock()
+	h := d.histogram.Snapshot()
+	values := h.Percentiles(quantiles)
+	for i := range values {
+		values[i] = values[i] / float64(time.Second)
+	}
+	return h.Count(), d.sum, values
+}
+
+// key builds the registry name of a metric like `name{storage="local"}`
+func key(name string, labels []string) string {
+	if len(labels) < 2 {
+		return name
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+escape(labels[i+1])+`"`)
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escape escapes a label value, paths on windows contain backslashes
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// split returns the metric name and the labels without the braces
+func split(key string) (string, string) {
+	i := strings.Index(key, "{")
+	if i < 0 {
+		return key, ""
+	}
+	return key[:i], strings.TrimSuffix(key[i+1:], "}")
+}
+
+// all returns all metrics by their registry name
+func all() map[string]interface{} {
+	result := make(map[string]interface{})
+	registry.Each(func(name string, m interface{}) {
+		result[name] = m
+	})
+	summariesM.Lock()
+	defer summariesM.Unlock()
+	for name, d := range summaries {
+		result[name] = d
+	}
+	return result
+}
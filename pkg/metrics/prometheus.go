@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// quantiles are exported for every summary
+var quantiles = []float64{0.5, 0.9, 0.99}
+
+// contentType is the Prometheus text format
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler exports all metrics in the Prometheus text format
+func Handler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	if err := WritePrometheus(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WritePrometheus writes all metrics in the Prometheus text format, metrics with the same name
+// are grouped under one HELP and TYPE line
+func WritePrometheus(out io.Writer) error {
+	collect()
+
+	metrics := all()
+	groups := make(map[string][]string)
+	for k := range metrics {
+		name, _ := split(k)
+		groups[name] = append(groups[name], k)
+	}
+	var names []string
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := bufio.NewWriter(out)
+	for _, name := range names {
+		d, ok := descriptions[name]
+		if !ok {
+			d = description{kind: "untyped"}
+		}
+		if d.help != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, d.help)
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, d.kind)
+
+		keys := groups[name]
+		sort.Strings(keys)
+		for _, k := range keys {
+			_, labels := split(k)
+			switch m := metrics[k].(type) {
+			case gometrics.Counter:
+				sample(w, name, labels, float64(m.Count()))
+			case gometrics.GaugeFloat64:
+				sample(w, name, labels, m.Value())
+			case *Durations:
+				count, sum, values := m.snapshot(quantiles)
+				for i, q := range quantiles {
+					sample(w, name, join(labels, `quantile="`+format(q)+`"`), values[i])
+				}
+				sample(w, name+"_sum", labels, sum)
+				sample(w, name+"_count", labels, float64(count))
+			}
+		}
+	}
+	return w.Flush()
+}
+
+func sample(w io.Writer, name, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s %s\n", name, format(value))
+}
+
+func join(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func format(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
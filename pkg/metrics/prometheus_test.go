@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	Counter(FilesUploaded, "storage", "local").Inc(2)
+	Counter(FilesUploaded, "storage", "gdrive").Inc(1)
+	Gauge(LastBackup, "dir", `C:\Users\test`).Update(1561000000)
+	Summary(UploadDuration, "storage", "local").Observe(2 * time.Second)
+	Summary(UploadDuration, "storage", "local").Observe(4 * time.Second)
+
+	var out bytes.Buffer
+	if err := WritePrometheus(&out); err != nil {
+		t.Fatal(err)
+	}
+	body := out.String()
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{
+			name: "Scenario 1: counter with type",
+			want: "# TYPE bakku_files_uploaded_total counter\n",
+		},
+		{
+			name: "Scenario 2: counters are grouped and sorted by labels",
+			want: "bakku_files_uploaded_total{storage=\"gdrive\"} 1\nbakku_files_uploaded_total{storage=\"local\"} 2\n",
+		},
+		{
+			name: "Scenario 3: backslashes in label values are escaped",
+			want: `bakku_last_backup_timestamp_seconds{dir="C:\\Users\\test"} 1.561e+09`,
+		},
+		{
+			name: "Scenario 4: summary quantile",
+			want: `bakku_upload_duration_seconds{storage="local",quantile="0.99"} 4`,
+		},
+		{
+			name: "Scenario 5: summary sum and count",
+			want: "bakku_upload_duration_seconds_sum{storage=\"local\"} 6\nbakku_upload_duration_seconds_count{storage=\"local\"} 2\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("WritePrometheus() output doesn't contain %q:\n%s", tt.want, body)
+			}
+		})
+	}
+}
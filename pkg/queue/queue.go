@@ -0,0 +1,406 @@
+package queue
+
+import (
+	"container/heap"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/config/watchdir"
+)
+
+// Policies decide the order of the files of one watched directory
+const (
+	// Smallest sends small files first, so a big video doesn't block hundreds of documents
+	Smallest = "smallest"
+	// Oldest sends the files in the order they were changed
+	Oldest = "oldest"
+)
+
+// Default is the policy used when nothing is configured
+const Default = Smallest
+
+const (
+	// agingTurns: every agingTurns-th file of a directory is its longest waiting one, so a big file is not
+	// starved by a steady stream of small files
+	agingTurns = 8
+	// starvingTurns is the number of turns a directory waits at most for directories with a higher priority
+	starvingTurns = 8
+)
+
+// Valid returns an error if the policy is unknown
+func Valid(policy string) error {
+	if policy == Smallest || policy == Oldest {
+		return nil
+	}
+	return fmt.Errorf("unknown queue policy [%s], use %s or %s", policy, Smallest, Oldest)
+}
+
+// Dir is a watched directory, the files of directories with a higher priority are sent first
+type Dir struct {
+	Path     string
+	Priority int
+}
+
+type item struct {
+	event notification.Event
+	dir   string
+	// seq is the order of arrival, it breaks ties
+	seq   uint64
+	added time.Time
+	index int
+	// ageIndex is the index in the age heap of the directory queue
+	ageIndex int
+}
+
+// changed returns the time of the change, the time it was queued if the event has none
+func (i *item) changed() time.Time {
+	if i.event.Timestamp.IsZero() {
+		return i.added
+	}
+	return i.event.Timestamp
+}
+
+// dirQueue are the queued files of one watched directory ordered by the policy, it implements heap.Interface
+type dirQueue struct {
+	path     string
+	priority int
+	items    []*item
+	less     func(a, b *item) bool
+	// byAge are the same files in the order of arrival
+	byAge ageHeap
+	// served is the number of files taken from the directory
+	served uint64
+	// lastTurn is the turn the directory was served the last time or started to wait
+	lastTurn uint64
+}
+
+func (d *dirQueue) Len() int           { return len(d.items) }
+func (d *dirQueue) Less(i, j int) bool { return d.less(d.items[i], d.items[j]) }
+func (d *dirQueue) Swap(i, j int) {
+	d.items[i], d.items[j] = d.items[j], d.items[i]
+	d.items[i].index = i
+	d.items[j].index = j
+}
+
+func (d *dirQueue) Push(x interface{}) {
+	it := x.(*item)
+	it.index = len(d.items)
+	d.items = append(d.items, it)
+}
+
+func (d *dirQueue) Pop() interface{} {
+	last := len(d.items) - 1
+	it := d.items[last]
+	d.items[last] = nil
+	d.items = d.items[:last]
+	return it
+}
+
+// add queues a file
+func (d *dirQueue) add(it *item) {
+	heap.Push(d, it)
+	heap.Push(&d.byAge, it)
+}
+
+// remove takes a file out of the queue
+func (d *dirQueue) remove(it *item) {
+	heap.Remove(d, it.index)
+	heap.Remove(&d.byAge, it.ageIndex)
+}
+
+// take removes the next file, it's the first one by the policy or on every agingTurns-th turn the oldest one
+func (d *dirQueue) take() *item {
+	d.served++
+	it := d.items[0]
+	if d.served%agingTurns == 0 {
+		it = d.byAge[0]
+	}
+	d.remove(it)
+	return it
+}
+
+// ageHeap orders files by their arrival, it implements heap.Interface
+type ageHeap []*item
+
+func (h ageHeap) Len() int           { return len(h) }
+func (h ageHeap) Less(i, j int) bool { return h[i].seq < h[j].seq }
+func (h ageHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].ageIndex = i
+	h[j].ageIndex = j
+}
+
+func (h *ageHeap) Push(x interface{}) {
+	it := x.(*item)
+	it.ageIndex = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *ageHeap) Pop() interface{} {
+	old := *h
+	last := len(old) - 1
+	it := old[last]
+	old[last] = nil
+	*h = old[:last]
+	return it
+}
+
+func lessFunc(policy string) func(a, b *item) bool {
+	if policy == Oldest {
+		return func(a, b *item) bool {
+			if ta, tb := a.changed(), b.changed(); !ta.Equal(tb) {
+				return ta.Before(tb)
+			}
+			return a.seq < b.seq
+		}
+	}
+	return func(a, b *item) bool {
+		if a.event.Size != b.event.Size {
+			return a.event.Size < b.event.Size
+		}
+		return a.seq < b.seq
+	}
+}
+
+// Queue orders the changed files before they are sent to the storages. Bumped files are sent first, then the
+// files of the directory with the highest priority. Directories with the same priority take turns, so one
+// directory with many files doesn't block the others. A directory which waited starvingTurns turns for
+// directories with a higher priority gets the next turn.
+type Queue struct {
+	sync.Mutex
+	policy string
+	dirs   []Dir
+	queues map[string]*dirQueue
+	items  map[string]*item
+	// bumped are the paths of the bumped files in the order they were bumped
+	bumped []string
+	seq    uint64
+	turn   uint64
+}
+
+// New returns an empty queue, an unknown policy is replaced by the default one
+func New(policy string, dirs []Dir) *Queue {
+	if Valid(policy) != nil {
+		policy = Default
+	}
+	return &Queue{
+		policy: policy,
+		dirs:   cleanDirs(dirs),
+		queues: make(map[string]*dirQueue),
+		items:  make(map[string]*item),
+	}
+}
+
+func cleanDirs(dirs []Dir) []Dir {
+	result := make([]Dir, 0, len(dirs))
+	for _, d := range dirs {
+		result = append(result, Dir{Path: filepath.Clean(d.Path), Priority: d.Priority})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// Configure changes the policy and the directories, the queued files are ordered again if they changed
+func (q *Queue) Configure(policy string, dirs []Dir) {
+	if Valid(policy) != nil {
+		policy = Default
+	}
+	dirs = cleanDirs(dirs)
+	q.Lock()
+	defer q.Unlock()
+	if policy == q.policy && equalDirs(dirs, q.dirs) {
+		return
+	}
+	q.policy = policy
+	q.dirs = dirs
+	turns := make(map[string]uint64)
+	for path, d := range q.queues {
+		turns[path] = d.lastTurn
+	}
+	q.queues = make(map[string]*dirQueue)
+	for _, it := range q.items {
+		it.dir = q.dirOf(&it.event)
+		d := q.dirQueue(it.dir)
+		d.Push(it)
+		d.byAge.Push(it)
+	}
+	for path, d := range q.queues {
+		d.lastTurn = turns[path]
+		heap.Init(d)
+		heap.Init(&d.byAge)
+	}
+}
+
+func equalDirs(a, b []Dir) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// dirOf returns the watched directory of the file, the caller must hold the lock
+func (q *Queue) dirOf(e *notification.Event) string {
+	paths := make([]string, 0, len(q.dirs))
+	for _, d := range q.dirs {
+		paths = append(paths, d.Path)
+	}
+	found := watchdir.Of(paths, e.AbsolutePath)
+	if found == "" && e.DirectoryPath != "" {
+		return filepath.Clean(e.DirectoryPath)
+	}
+	return found
+}
+
+// dirQueue returns the queue of a directory, the caller must hold the lock
+func (q *Queue) dirQueue(path string) *dirQueue {
+	d, ok := q.queues[path]
+	if !ok {
+		d = &dirQueue{path: path, less: lessFunc(q.policy)}
+		for _, dir := range q.dirs {
+			if dir.Path == path {
+				d.priority = dir.Priority
+			}
+		}
+		q.queues[path] = d
+	}
+	return d
+}
+
+// Push adds a file, a file which is already queued is updated and keeps its place in the order of arrival.
+// It returns false if the file was queued already.
+func (q *Queue) Push(e notification.Event) bool {
+	q.Lock()
+	defer q.Unlock()
+	if it, ok := q.items[e.AbsolutePath]; ok {
+		it.event = e
+		heap.Fix(q.queues[it.dir], it.index)
+		return false
+	}
+	q.seq++
+	it := &item{event: e, seq: q.seq, added: time.Now()}
+	it.dir = q.dirOf(&e)
+	q.items[e.AbsolutePath] = it
+	d := q.dirQueue(it.dir)
+	if d.Len() == 0 {
+		// the directory waits from now on
+		d.lastTurn = q.turn
+	}
+	d.add(it)
+	return true
+}
+
+// Pop removes and returns the next file
+func (q *Queue) Pop() (notification.Event, bool) {
+	q.Lock()
+	defer q.Unlock()
+	for len(q.bumped) > 0 {
+		path := q.bumped[0]
+		q.bumped = q.bumped[1:]
+		if it, ok := q.items[path]; ok {
+			q.remove(it)
+			return it.event, true
+		}
+	}
+	var next, starving *dirQueue
+	for _, d := range q.queues {
+		if d.Len() == 0 {
+			continue
+		}
+		if next == nil || d.priority > next.priority ||
+			(d.priority == next.priority && waitedLonger(d, next)) {
+			next = d
+		}
+		if q.turn-d.lastTurn >= starvingTurns && (starving == nil || waitedLonger(d, starving)) {
+			starving = d
+		}
+	}
+	if next == nil {
+		return notification.Event{}, false
+	}
+	if starving != nil {
+		next = starving
+	}
+	q.turn++
+	next.lastTurn = q.turn
+	it := next.take()
+	delete(q.items, it.event.AbsolutePath)
+	return it.event, true
+}
+
+// waitedLonger returns true if the directory a waits longer than b, the path breaks ties
+func waitedLonger(a, b *dirQueue) bool {
+	return a.lastTurn < b.lastTurn || (a.lastTurn == b.lastTurn && a.path < b.path)
+}
+
+// Bump moves a file to the front of the queue, it returns false if the file is not queued
+func (q *Queue) Bump(path string) bool {
+	q.Lock()
+	defer q.Unlock()
+	if _, ok := q.items[path]; !ok {
+		return false
+	}
+	for _, p := range q.bumped {
+		if p == path {
+			return true
+		}
+	}
+	q.bumped = append(q.bumped, path)
+	return true
+}
+
+// Remove takes a file out of the queue, it returns false if the file is not queued
+func (q *Queue) Remove(path string) bool {
+	q.Lock()
+	defer q.Unlock()
+	it, ok := q.items[path]
+	if ok {
+		q.remove(it)
+	}
+	return ok
+}
+
+// remove takes the item out of its directory queue, the caller must hold the lock
+func (q *Queue) remove(it *item) {
+	q.queues[it.dir].remove(it)
+	delete(q.items, it.event.AbsolutePath)
+}
+
+// Paths returns the paths of all queued files
+func (q *Queue) Paths() []string {
+	q.Lock()
+	defer q.Unlock()
+	result := make([]string, 0, len(q.items))
+	for path := range q.items {
+		result = append(result, path)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Bytes returns the size of all queued files
+func (q *Queue) Bytes() int64 {
+	q.Lock()
+	defer q.Unlock()
+	var size int64
+	for _, it := range q.items {
+		size += it.event.Size
+	}
+	return size
+}
+
+// Len returns the number of queued files
+func (q *Queue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.items)
+}
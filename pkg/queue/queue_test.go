@@ -0,0 +1,138 @@
+package queue
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+)
+
+func TestQueue(t *testing.T) {
+	now := time.Now()
+	docs := []notification.Event{
+		{AbsolutePath: "/docs/video.mp4", Size: 5 << 30, Timestamp: now.Add(-3 * time.Minute)},
+		{AbsolutePath: "/docs/a.txt", Size: 10, Timestamp: now.Add(-1 * time.Minute)},
+		{AbsolutePath: "/docs/b.txt", Size: 20, Timestamp: now.Add(-2 * time.Minute)},
+	}
+	photos := []notification.Event{
+		{AbsolutePath: "/photos/1.jpg", Size: 100},
+		{AbsolutePath: "/photos/2.jpg", Size: 200},
+	}
+
+	tests := []struct {
+		name   string
+		policy string
+		dirs   []Dir
+		events []notification.Event
+		bump   string
+		want   []string
+	}{
+		{
+			name:   "Scenario 1: smallest first",
+			policy: Smallest,
+			events: docs,
+			want:   []string{"/docs/a.txt", "/docs/b.txt", "/docs/video.mp4"},
+		},
+		{
+			name:   "Scenario 2: oldest change first",
+			policy: Oldest,
+			events: docs,
+			want:   []string{"/docs/video.mp4", "/docs/b.txt", "/docs/a.txt"},
+		},
+		{
+			name:   "Scenario 3: directories with the same priority take turns",
+			policy: Smallest,
+			dirs:   []Dir{{Path: "/docs"}, {Path: "/photos"}},
+			events: append(append([]notification.Event{}, docs...), photos...),
+			want:   []string{"/docs/a.txt", "/photos/1.jpg", "/docs/b.txt", "/photos/2.jpg", "/docs/video.mp4"},
+		},
+		{
+			name:   "Scenario 4: higher priority first",
+			policy: Smallest,
+			dirs:   []Dir{{Path: "/docs"}, {Path: "/photos/", Priority: 1}},
+			events: append(append([]notification.Event{}, docs...), photos...),
+			want:   []string{"/photos/1.jpg", "/photos/2.jpg", "/docs/a.txt", "/docs/b.txt", "/docs/video.mp4"},
+		},
+		{
+			name:   "Scenario 5: bumped files first",
+			policy: Smallest,
+			dirs:   []Dir{{Path: "/docs"}, {Path: "/photos", Priority: 1}},
+			events: append(append([]notification.Event{}, docs...), photos...),
+			bump:   "/docs/video.mp4",
+			want:   []string{"/docs/video.mp4", "/photos/1.jpg", "/photos/2.jpg", "/docs/a.txt", "/docs/b.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := New(tt.policy, tt.dirs)
+			for _, e := range tt.events {
+				q.Push(e)
+			}
+			if tt.bump != "" && !q.Bump(tt.bump) {
+				t.Fatalf("Bump(%s) = false", tt.bump)
+			}
+			var got []string
+			for e, ok := q.Pop(); ok; e, ok = q.Pop() {
+				got = append(got, e.AbsolutePath)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	q := New(Smallest, nil)
+	q.Push(notification.Event{AbsolutePath: "/docs/big", Size: 2, Timestamp: time.Unix(1, 0)})
+	q.Push(notification.Event{AbsolutePath: "/docs/small", Size: 1, Timestamp: time.Unix(2, 0)})
+	q.Configure(Oldest, nil)
+	if e, _ := q.Pop(); e.AbsolutePath != "/docs/big" {
+		t.Errorf("got %s first after the policy changed to %s", e.AbsolutePath, Oldest)
+	}
+}
+
+func TestQueue_Starvation(t *testing.T) {
+	tests := []struct {
+		name    string
+		dirs    []Dir
+		waiting notification.Event
+		// stream is the directory of the files which keep coming
+		stream   string
+		maxTurns int
+	}{
+		{
+			name:     "Scenario 1: a big file is not starved by small files",
+			waiting:  notification.Event{AbsolutePath: "/docs/video.mp4", Size: 5 << 30},
+			stream:   "/docs",
+			maxTurns: agingTurns,
+		},
+		{
+			name:     "Scenario 2: a directory is not starved by a directory with a higher priority",
+			dirs:     []Dir{{Path: "/docs"}, {Path: "/photos", Priority: 1}},
+			waiting:  notification.Event{AbsolutePath: "/docs/a.txt", Size: 1},
+			stream:   "/photos",
+			maxTurns: starvingTurns + 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := New(Smallest, tt.dirs)
+			q.Push(tt.waiting)
+			for turn := 1; turn <= 10*tt.maxTurns; turn++ {
+				q.Push(notification.Event{AbsolutePath: fmt.Sprintf("%s/%d", tt.stream, turn), Size: 1})
+				e, _ := q.Pop()
+				if e.AbsolutePath != tt.waiting.AbsolutePath {
+					continue
+				}
+				if turn > tt.maxTurns {
+					t.Errorf("[%s] was sent after %d turns, want at most %d", e.AbsolutePath, turn, tt.maxTurns)
+				}
+				return
+			}
+			t.Errorf("[%s] was never sent", tt.waiting.AbsolutePath)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket limiting the number of bytes per second.
+// The burst size is the rate itself, a rate of 0 means no limit.
+type Bucket struct {
+	sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a bucket with the given rate in bytes per second
+func NewBucket(rate int64) *Bucket {
+	return &Bucket{
+		rate:   rate,
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// Rate returns the current rate in bytes per second
+func (b *Bucket) Rate() int64 {
+	b.Lock()
+	defer b.Unlock()
+	return b.rate
+}
+
+// SetRate changes the rate, it's safe to call it while the bucket is in use
+func (b *Bucket) SetRate(rate int64) {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(time.Now())
+	b.rate = rate
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+}
+
+// WaitN blocks until n bytes are allowed to pass or the context is done
+func (b *Bucket) WaitN(ctx context.Context, n int) error {
+	wait := b.reserve(n)
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		// give the reserved tokens back, nothing was transferred
+		b.Lock()
+		b.tokens += float64(n)
+		b.Unlock()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// reserve takes n tokens from the bucket and returns how long the caller must wait for them
+func (b *Bucket) reserve(n int) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+}
+
+func (b *Bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last)
+	b.last = now
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens += elapsed.Seconds() * float64(b.rate)
+	if b.tokens > float64(b.rate) {
+		b.tokens = float64(b.rate)
+	}
+}
@@ -0,0 +1,308 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulbellamy/ratecounter"
+
+	"github.com/glower/bakku-app/pkg/config"
+	configstorage "github.com/glower/bakku-app/pkg/config/storage"
+	"github.com/glower/bakku-app/pkg/metrics"
+	"github.com/glower/bakku-app/pkg/schedule"
+)
+
+// Global is the name of the limit shared by all storages
+const Global = "global"
+
+// maxChunk is the largest chunk read at once, it keeps the traffic smooth for low limits
+const maxChunk = 32 * 1024
+
+// Status represents the current limit and throughput of a storage or the global limit
+type Status struct {
+	Name       string `json:"name"`
+	Limit      int64  `json:"limit"`
+	Override   bool   `json:"override"`
+	Profile    string `json:"profile,omitempty"`
+	Throughput int64  `json:"throughput"`
+}
+
+type profile struct {
+	window schedule.Window
+	limit  int64
+}
+
+// limiter holds the bucket and the configuration of one storage or the global limit
+type limiter struct {
+	sync.Mutex
+	name       string
+	bucket     *Bucket
+	limit      int64 // configured default limit
+	profiles   []profile
+	override   *int64 // set at runtime over the API
+	profile    string // window of the active profile
+	throughput *ratecounter.RateCounter
+}
+
+var (
+	limitersM sync.RWMutex
+	limiters  = make(map[string]*limiter)
+)
+
+// Setup reads the global limit and the limits of all active storages and applies time of day profiles
+func Setup(ctx context.Context) {
+	get(Global)
+	storages, err := configstorage.Active()
+	if err != nil {
+		log.Printf("[ERROR] ratelimit.Setup(): %v\n", err)
+	}
+	for _, name := range storages {
+		get(name)
+	}
+	metrics.OnCollect(func() {
+		metrics.Gauge(metrics.Throughput).Update(float64(Throughput()))
+	})
+	go applyProfiles(ctx)
+}
+
+// Reload reads the configured limits of all known limiters again, runtime overrides are kept.
+// Storages which were activated since Setup get their limiter.
+func Reload() {
+	storages, err := configstorage.Active()
+	if err != nil {
+		log.Printf("[ERROR] ratelimit.Reload(): %v\n", err)
+	}
+	for _, name := range storages {
+		get(name)
+	}
+	limitersM.RLock()
+	defer limitersM.RUnlock()
+	for _, l := range limiters {
+		l.configure()
+		l.apply(time.Now())
+	}
+}
+
+// NewReader wraps r so that reading from it is limited by the global and the storage limit
+func NewReader(ctx context.Context, storageName string, r io.Reader) io.Reader {
+	return &reader{
+		ctx:     ctx,
+		r:       r,
+		global:  get(Global),
+		storage: get(storageName),
+	}
+}
+
+// Set overrides the limit of a storage or the global limit at runtime, 0 disables the limit
+func Set(name string, limit int64) error {
+	if limit < 0 {
+		return fmt.Errorf("limit must not be negative")
+	}
+	l, err := find(name)
+	if err != nil {
+		return err
+	}
+	l.Lock()
+	l.override = &limit
+	l.Unlock()
+	l.apply(time.Now())
+	return nil
+}
+
+// Reset removes the runtime override, the configured limit is used again
+func Reset(name string) error {
+	l, err := find(name)
+	if err != nil {
+		return err
+	}
+	l.Lock()
+	l.override = nil
+	l.Unlock()
+	l.apply(time.Now())
+	return nil
+}
+
+// Statuses returns limits and throughput of all known limiters, the global one first
+func Statuses() []Status {
+	limitersM.RLock()
+	defer limitersM.RUnlock()
+	result := []Status{}
+	if l, ok := limiters[Global]; ok {
+		result = append(result, l.status())
+	}
+	for name, l := range limiters {
+		if name != Global {
+			result = append(result, l.status())
+		}
+	}
+	return result
+}
+
+// Throughput returns the total number of bytes per second transferred over the last second
+func Throughput() int64 {
+	limitersM.RLock()
+	defer limitersM.RUnlock()
+	if l, ok := limiters[Global]; ok {
+		return l.throughput.Rate()
+	}
+	return 0
+}
+
+// find returns the limiter of the global limit or a configured storage, the limiter of a storage
+// which was added after Setup is created here
+func find(name string) (*limiter, error) {
+	limitersM.RLock()
+	l, ok := limiters[name]
+	limitersM.RUnlock()
+	if ok {
+		return l, nil
+	}
+	if name != Global && !configured(name) {
+		return nil, fmt.Errorf("unknown storage [%s]", name)
+	}
+	return get(name), nil
+}
+
+// configured returns true if the storage like "storage.local" is in the config
+func configured(name string) bool {
+	if !strings.HasPrefix(name, "storage.") {
+		return false
+	}
+	_, ok := config.Current().Storage[strings.TrimPrefix(name, "storage.")]
+	return ok
+}
+
+// get returns the limiter for the name and creates it from the config if needed
+func get(name string) *limiter {
+	limitersM.Lock()
+	defer limitersM.Unlock()
+	if l, ok := limiters[name]; ok {
+		return l
+	}
+	l := &limiter{
+		name:       name,
+		bucket:     NewBucket(0),
+		throughput: ratecounter.NewRateCounter(1 * time.Second),
+	}
+	l.configure()
+	l.apply(time.Now())
+	limiters[name] = l
+	return l
+}
+
+// configure reads the limit and the profiles from "bandwidth" or "storage.<name>.bandwidth"
+func (l *limiter) configure() {
+	key := "bandwidth"
+	var conf *config.Bandwidth
+	if l.name == Global {
+		conf = config.BandwidthConf("")
+	} else {
+		key = l.name + ".bandwidth"
+		conf = config.BandwidthConf(strings.TrimPrefix(l.name, "storage."))
+	}
+
+	limit, err := config.ParseSize(conf.Limit)
+	if err != nil {
+		log.Printf("[ERROR] ratelimit.configure(): [%s.limit]: %v\n", key, err)
+	}
+	var profiles []profile
+	for _, p := range conf.Profiles {
+		w, err := schedule.ParseWindow(p.Window)
+		if err != nil {
+			log.Printf("[ERROR] ratelimit.configure(): [%s.profiles]: %v\n", key, err)
+			continue
+		}
+		pl, err := config.ParseSize(p.Limit)
+		if err != nil {
+			log.Printf("[ERROR] ratelimit.configure(): [%s.profiles]: %v\n", key, err)
+			continue
+		}
+		profiles = append(profiles, profile{window: w, limit: pl})
+	}
+
+	l.Lock()
+	l.limit = limit
+	l.profiles = profiles
+	l.Unlock()
+}
+
+// apply sets the rate of the bucket: a runtime override wins over a profile, a profile over the default
+func (l *limiter) apply(now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	rate := l.limit
+	l.profile = ""
+	for _, p := range l.profiles {
+		if p.window.Contains(now) {
+			rate = p.limit
+			l.profile = p.window.String()
+			break
+		}
+	}
+	if l.override != nil {
+		rate = *l.override
+	}
+	if l.bucket.Rate() != rate {
+		log.Printf("ratelimit.apply(): [%s] limit is %s/s\n", l.name, config.FormatSize(rate))
+		l.bucket.SetRate(rate)
+	}
+}
+
+func (l *limiter) status() Status {
+	l.Lock()
+	defer l.Unlock()
+	return Status{
+		Name:       l.name,
+		Limit:      l.bucket.Rate(),
+		Override:   l.override != nil,
+		Profile:    l.profile,
+		Throughput: l.throughput.Rate(),
+	}
+}
+
+func applyProfiles(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			limitersM.RLock()
+			for _, l := range limiters {
+				l.apply(now)
+			}
+			limitersM.RUnlock()
+		}
+	}
+}
+
+// reader is an io.Reader limited by the global and the storage bucket
+type reader struct {
+	ctx     context.Context
+	r       io.Reader
+	global  *limiter
+	storage *limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+	n, err := r.r.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+	for _, l := range []*limiter{r.storage, r.global} {
+		if waitErr := l.bucket.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+		l.throughput.Incr(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,32 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBucket_WaitN(t *testing.T) {
+	b := NewBucket(100 * 1024)
+	start := time.Now()
+	// the first 100KB are the burst, the next 50KB take about half a second
+	for i := 0; i < 15; i++ {
+		if err := b.WaitN(context.Background(), 10*1024); err != nil {
+			t.Fatalf("Bucket.WaitN() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Bucket.WaitN() took %s, want about 500ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.WaitN(ctx, 1024*1024); err == nil {
+		t.Errorf("Bucket.WaitN() error was expected for a canceled context")
+	}
+
+	b.SetRate(0)
+	if err := b.WaitN(context.Background(), 1024*1024*1024); err != nil {
+		t.Errorf("Bucket.WaitN() error = %v for an unlimited bucket", err)
+	}
+}
@@ -0,0 +1,123 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{name: "Scenario 1: every minute", expr: "* * * * *"},
+		{name: "Scenario 2: nightly", expr: "0 2 * * *"},
+		{name: "Scenario 3: lists, ranges and steps", expr: "*/15 8-18 1,15 jan-jun mon-fri"},
+		{name: "Scenario 4: descriptor", expr: "@daily"},
+		{name: "Scenario 5: sunday as 7", expr: "0 0 * * 7"},
+		{name: "Scenario 6: too few fields", expr: "0 2 * *", wantErr: true},
+		{name: "Scenario 7: out of range", expr: "60 * * * *", wantErr: true},
+		{name: "Scenario 8: wrong range", expr: "0 10-5 * * *", wantErr: true},
+		{name: "Scenario 9: wrong step", expr: "*/0 * * * *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.expr); (err != nil) != tt.wantErr {
+				t.Errorf("Parse(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	from := time.Date(2019, time.June, 28, 13, 37, 20, 0, time.UTC) // friday
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	tests := []struct {
+		name string
+		expr string
+		// from is the start of the search if it's not the default
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "Scenario 1: every minute",
+			expr: "* * * * *",
+			want: time.Date(2019, time.June, 28, 13, 38, 0, 0, time.UTC),
+		},
+		{
+			name: "Scenario 2: nightly at 02:00",
+			expr: "0 2 * * *",
+			want: time.Date(2019, time.June, 29, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "Scenario 3: next monday",
+			expr: "30 9 * * mon",
+			want: time.Date(2019, time.July, 1, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "Scenario 4: first of the month",
+			expr: "@monthly",
+			want: time.Date(2019, time.July, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "Scenario 5: every 15 minutes",
+			expr: "*/15 * * * *",
+			want: time.Date(2019, time.June, 28, 13, 45, 0, 0, time.UTC),
+		},
+		{
+			name: "Scenario 6: full hour in a zone with a half hour offset",
+			expr: "0 15 * * *",
+			from: time.Date(2019, time.June, 28, 13, 37, 20, 0, kolkata),
+			want: time.Date(2019, time.June, 28, 15, 0, 0, 0, kolkata),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+			}
+			start := from
+			if !tt.from.IsZero() {
+				start = tt.from
+			}
+			if got := s.Next(start); !got.Equal(tt.want) {
+				t.Errorf("Schedule.Next() = %v, want %v", got, tt.want)
+			}
+			if !s.Match(tt.want) {
+				t.Errorf("Schedule.Match(%v) = false, want true", tt.want)
+			}
+		})
+	}
+}
+
+func TestWindow_Contains(t *testing.T) {
+	tests := []struct {
+		name   string
+		window string
+		at     string
+		want   bool
+	}{
+		{name: "Scenario 1: inside a day window", window: "09:00-17:00", at: "12:00", want: true},
+		{name: "Scenario 2: end is exclusive", window: "09:00-17:00", at: "17:00", want: false},
+		{name: "Scenario 3: before midnight", window: "22:00-06:00", at: "23:30", want: true},
+		{name: "Scenario 4: after midnight", window: "22:00-06:00", at: "05:59", want: true},
+		{name: "Scenario 5: outside of a night window", window: "22:00-06:00", at: "12:00", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ParseWindow(tt.window)
+			if err != nil {
+				t.Fatalf("ParseWindow(%q) error = %v", tt.window, err)
+			}
+			at, _ := time.Parse("15:04", tt.at)
+			if got := w.Contains(at); got != tt.want {
+				t.Errorf("Window.Contains(%s) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
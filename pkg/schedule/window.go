@@ -0,0 +1,80 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a daily time window like "22:00-06:00", it can span midnight
+type Window struct {
+	From time.Duration // offset from midnight
+	To   time.Duration
+}
+
+// ParseWindow parses a window in the form "HH:MM-HH:MM"
+func ParseWindow(s string) (Window, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 2 {
+		return Window{}, fmt.Errorf("schedule.ParseWindow(): invalid window [%s], expected HH:MM-HH:MM", s)
+	}
+	from, err := parseClock(parts[0])
+	if err != nil {
+		return Window{}, fmt.Errorf("schedule.ParseWindow(): %v", err)
+	}
+	to, err := parseClock(parts[1])
+	if err != nil {
+		return Window{}, fmt.Errorf("schedule.ParseWindow(): %v", err)
+	}
+	return Window{From: from, To: to}, nil
+}
+
+// ParseWindows parses a list of windows
+func ParseWindows(list []string) ([]Window, error) {
+	var result []Window
+	for _, s := range list {
+		w, err := ParseWindow(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, w)
+	}
+	return result, nil
+}
+
+// Contains checks if the time of day of t is inside of the window
+func (w Window) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if w.From <= w.To {
+		return offset >= w.From && offset < w.To
+	}
+	// the window spans midnight
+	return offset >= w.From || offset < w.To
+}
+
+// String returns the window in the form "HH:MM-HH:MM"
+func (w Window) String() string {
+	return fmt.Sprintf("%s-%s", clock(w.From), clock(w.To))
+}
+
+// InAny checks if t is inside of any of the windows
+func InAny(windows []Window, t time.Time) bool {
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time [%s]", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func clock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glower/bakku-app/pkg/backup"
+	"github.com/glower/bakku-app/pkg/config"
+	conf "github.com/glower/bakku-app/pkg/config/storage"
+	"github.com/glower/bakku-app/pkg/schedule"
+	"github.com/glower/bakku-app/pkg/snapshot"
+)
+
+// defaultScanOnlySchedule is used for scan-only directories without an own schedule
+const defaultScanOnlySchedule = "@hourly"
+
+// Scheduler runs scheduled rescans of watched directories and scheduled uploads to storages
+type Scheduler struct {
+	ctx context.Context
+
+	snapshot *snapshot.Snapshot
+	backup   *backup.StorageManager
+
+	sync.RWMutex
+	dirs     map[string]*schedule.Schedule // watched directory -> rescan schedule
+	storages map[string]*schedule.Schedule // storage name -> upload schedule
+	verify   map[string]*schedule.Schedule // storage name -> verification schedule
+}
+
+// Setup reads all schedules from the config and starts the scheduler
+func Setup(ctx context.Context, snapShotManager *snapshot.Snapshot, backupStorageManager *backup.StorageManager) *Scheduler {
+	s := &Scheduler{
+		ctx:      ctx,
+		snapshot: snapShotManager,
+		backup:   backupStorageManager,
+	}
+	s.Reload()
+	go s.run()
+	return s
+}
+
+// Reload reads all schedules from the config again
+func (s *Scheduler) Reload() {
+	dirs := make(map[string]*schedule.Schedule)
+	storages := make(map[string]*schedule.Schedule)
+	verify := make(map[string]*schedule.Schedule)
+
+	watchConfig, err := config.DirectoriesToWatch()
+	if err != nil {
+		log.Printf("[ERROR] scheduler.Reload(): %v\n", err)
+	} else {
+		for _, d := range watchConfig.DirsToWatch {
+			if !d.Active {
+				continue
+			}
+			expr := d.Schedule
+			if expr == "" && d.ScanOnly {
+				expr = defaultScanOnlySchedule
+			}
+			if expr == "" {
+				continue
+			}
+			sched, err := schedule.Parse(expr)
+			if err != nil {
+				log.Printf("[ERROR] scheduler.Reload(): directory [%s]: %v\n", d.Path, err)
+				continue
+			}
+			dirs[d.Path] = sched
+		}
+	}
+
+	for name := range backup.GetAll() {
+		c := conf.ProviderConf(strings.TrimPrefix(name, "storage."))
+		if c.Verify != "" {
+			if sched, err := schedule.Parse(c.Verify); err != nil {
+				log.Printf("[ERROR] scheduler.Reload(): storage [%s]: %v\n", name, err)
+			} else {
+				verify[name] = sched
+			}
+		}
+		if c.Schedule == "" {
+			continue
+		}
+		sched, err := schedule.Parse(c.Schedule)
+		if err != nil {
+			log.Printf("[ERROR] scheduler.Reload(): storage [%s]: %v\n", name, err)
+			continue
+		}
+		storages[name] = sched
+	}
+
+	s.Lock()
+	s.dirs = dirs
+	s.storages = storages
+	s.verify = verify
+	s.Unlock()
+	log.Printf("scheduler.Reload(): %d directory, %d storage and %d verification schedules\n", len(dirs), len(storages), len(verify))
+}
+
+func (s *Scheduler) run() {
+	// align the ticks to the beginning of a minute
+	now := time.Now()
+	select {
+	case <-s.ctx.Done():
+		return
+	case <-time.After(now.Truncate(time.Minute).Add(time.Minute).Sub(now)):
+	}
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+	s.tick(time.Now())
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.tick(now)
+		}
+	}
+}
+
+func (s *Scheduler) tick(now time.Time) {
+	s.RLock()
+	defer s.RUnlock()
+	for path, sched := range s.dirs {
+		if sched.Match(now) {
+			log.Printf("scheduler.tick(): scheduled scan of [%s]\n", path)
+			if _, err := s.snapshot.Scan(path); err != nil {
+				log.Printf("[ERROR] scheduler.tick(): %v\n", err)
+			}
+		}
+	}
+	for name, sched := range s.storages {
+		if sched.Match(now) {
+			log.Printf("scheduler.tick(): scheduled upload to [%s]\n", name)
+			go s.backup.Release(name)
+		}
+	}
+	for name, sched := range s.verify {
+		if sched.Match(now) {
+			log.Printf("scheduler.tick(): scheduled verification of [%s]\n", name)
+			repair := conf.ProviderConf(strings.TrimPrefix(name, "storage.")).Repair
+			if _, err := s.backup.Scrub(name, repair); err != nil {
+				log.Printf("[ERROR] scheduler.tick(): %v\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/glower/bakku-app/pkg/config"
+	configstorage "github.com/glower/bakku-app/pkg/config/storage"
+	"github.com/glower/bakku-app/pkg/message"
+	"github.com/glower/bakku-app/pkg/metrics"
+	"github.com/glower/bakku-app/pkg/storage/record"
+
+	"github.com/glower/file-watcher/notification"
+)
+
+// checkpointBucket is the name of the bucket where scan checkpoints are stored
+const checkpointBucket = "snapshot.scan"
+
+// checkpointEvery defines after how many files the scan progress is persisted, it is also the number of files
+// whose snapshot records are read at once
+const checkpointEvery = 100
+
+// Possible states of a scan job
+const (
+	ScanRunning  = "running"
+	ScanDone     = "done"
+	ScanFailed   = "failed"
+	ScanCanceled = "canceled"
+)
+
+// ScanStatus represents the state of a scan job for one watched directory
+type ScanStatus struct {
+	Path       string    `json:"path"`
+	State      string    `json:"state"`
+	Seen       int64     `json:"seen"`
+	Changed    int64     `json:"changed"`
+	Queued     int64     `json:"queued"`
+	Errors     int64     `json:"errors"`
+	Checkpoint string    `json:"checkpoint,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	// FinishedAt is nil while the scan is running
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// scanJob is a running scan of one watched directory
+type scanJob struct {
+	sync.RWMutex
+	status ScanStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Scan starts a tracked scan job for the given directory. If the previous scan of
+// the same directory was interrupted, the job resumes from the last checkpoint.
+func (s *Snapshot) Scan(path string) (*ScanStatus, error) {
+	j, err := s.startScan(path)
+	if err != nil {
+		return nil, err
+	}
+	result := j.snapshot()
+	return &result, nil
+}
+
+// startScan starts the scan job and returns it, callers can wait for j.done
+func (s *Snapshot) startScan(path string) (*scanJob, error) {
+	path = filepath.Clean(path)
+
+	s.jobsM.Lock()
+	defer s.jobsM.Unlock()
+	if j, ok := s.jobs[path]; ok && j.state() == ScanRunning {
+		return nil, fmt.Errorf("scan of [%s] is already running", path)
+	}
+
+	status := ScanStatus{
+		Path:      path,
+		State:     ScanRunning,
+		StartedAt: time.Now(),
+	}
+	if last, err := s.loadCheckpoint(path); err == nil && last.State == ScanRunning {
+		log.Printf("[INFO] snapshot.Scan(): resume scan of [%s] after [%s]\n", path, last.Checkpoint)
+		status = *last
+		// checkpoints written before FinishedAt was a pointer have a zero time
+		status.FinishedAt = nil
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	j := &scanJob{
+		status: status,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	s.jobs[path] = j
+
+	go s.run(ctx, j)
+	return j, nil
+}
+
+// CancelScan stops a running scan job, the checkpoint is kept so the next scan resumes
+func (s *Snapshot) CancelScan(path string) error {
+	s.jobsM.RLock()
+	j, ok := s.jobs[filepath.Clean(path)]
+	s.jobsM.RUnlock()
+	if !ok {
+		return fmt.Errorf("no scan found for [%s]", path)
+	}
+	j.cancel()
+	<-j.done
+	return nil
+}
+
+// ScanStatuses returns the status of all known scan jobs
+func (s *Snapshot) ScanStatuses() []ScanStatus {
+	s.jobsM.RLock()
+	defer s.jobsM.RUnlock()
+	result := make([]ScanStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		result = append(result, j.snapshot())
+	}
+	return result
+}
+
+func (s *Snapshot) run(ctx context.Context, j *scanJob) {
+	defer close(j.done)
+	defer j.cancel()
+
+	started := time.Now()
+	err := s.walk(ctx, j)
+
+	finished := time.Now()
+	j.Lock()
+	j.status.FinishedAt = &finished
+	switch {
+	case err == nil:
+		j.status.State = ScanDone
+		j.status.Checkpoint = ""
+	case ctx.Err() != nil:
+		// keep the state as running in the checkpoint so the next scan resumes
+		j.status.State = ScanCanceled
+	default:
+		j.status.State = ScanFailed
+		j.status.Error = err.Error()
+	}
+	status := j.status
+	j.Unlock()
+
+	if status.State == ScanDone {
+		metrics.Summary(metrics.ScanDuration, "dir", status.Path).Observe(time.Since(started))
+	}
+	if status.State == ScanCanceled {
+		status.State = ScanRunning
+	}
+	if err := s.saveCheckpoint(&status); err != nil {
+		log.Printf("[ERROR] snapshot.run(): can't save checkpoint for [%s]: %v\n", status.Path, err)
+	}
+
+	log.Printf("[INFO] snapshot.run(): scan of [%s] %s: seen=%d changed=%d queued=%d errors=%d\n",
+		status.Path, j.state(), status.Seen, status.Changed, status.Queued, status.Errors)
+	if err != nil && ctx.Err() == nil {
+		s.messageCh <- message.FormatMessage("ERROR", fmt.Sprintf("scan of [%s] failed: %v", status.Path, err), "snapshot")
+	}
+}
+
+func (s *Snapshot) walk(ctx context.Context, j *scanJob) error {
+	path := j.status.Path
+	resumeAfter := j.status.Checkpoint
+
+	// read all supported backup storages form the config
+	backupStorages, err := configstorage.Active()
+	if err != nil {
+		return err
+	}
+
+	filters := config.FileFilters()
+
+	// the snapshot records are read for a batch of files in one transaction
+	batch := make([]string, 0, checkpointEvery)
+	err = filepath.Walk(path, func(absoluteFilePath string, fileInfo os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			atomic.AddInt64(&j.status.Errors, 1)
+			log.Printf("[ERROR] snapshot.walk(): %v\n", err)
+			if fileInfo != nil && fileInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fileInfo.IsDir() || config.Filtered(absoluteFilePath, filters) {
+			return nil
+		}
+		// everything up to the checkpoint is already done
+		if resumeAfter != "" && !afterCheckpoint(absoluteFilePath, resumeAfter) {
+			return nil
+		}
+
+		batch = append(batch, absoluteFilePath)
+		if len(batch) < checkpointEvery {
+			return nil
+		}
+		err = s.scanBatch(j, batch, backupStorages)
+		batch = batch[:0]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return s.scanBatch(j, batch, backupStorages)
+}
+
+// afterCheckpoint checks if filepath.Walk visits the file after the checkpoint. Walk sorts the names in every
+// directory, so the paths are compared element by element: "d/b-x/f" comes after "d/b/c" although it's the
+// smaller string.
+func afterCheckpoint(path, checkpoint string) bool {
+	p := strings.Split(path, string(filepath.Separator))
+	c := strings.Split(checkpoint, string(filepath.Separator))
+	for i := 0; i < len(p) && i < len(c); i++ {
+		if p[i] != c[i] {
+			return p[i] > c[i]
+		}
+	}
+	return len(p) > len(c)
+}
+
+// scanBatch compares files with the snapshot records of all storages and saves the checkpoint after the last file
+func (s *Snapshot) scanBatch(j *scanJob, batch []string, backupStorages []string) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	path := j.status.Path
+	records := make(map[string]map[string]string, len(backupStorages))
+	for _, backupStorage := range backupStorages {
+		r, err := s.storage.GetMany(backupStorage, batch)
+		if err != nil {
+			return err
+		}
+		records[backupStorage] = r
+	}
+
+	algorithm := config.Current().Checksum.ChangeAlgorithm()
+	migrated := make(map[string]map[string][]byte)
+	for _, absoluteFilePath := range batch {
+		atomic.AddInt64(&j.status.Seen, 1)
+		sums := newFileSums(absoluteFilePath)
+		changed := false
+		for _, backupStorage := range backupStorages {
+			var r *record.Record
+			if value, ok := records[backupStorage][absoluteFilePath]; ok {
+				r, _ = record.Decode(value, backupStorage)
+			}
+			if !fileDifferentToBackup(r, sums) {
+				// the record was written with another algorithm, it's replaced without an upload
+				if migrateChecksum(r, sums, algorithm) {
+					if value, err := r.Encode(); err == nil {
+						if migrated[backupStorage] == nil {
+							migrated[backupStorage] = make(map[string][]byte)
+						}
+						migrated[backupStorage][absoluteFilePath] = value
+					}
+				}
+				continue
+			}
+			relativePath, err := filepath.Rel(path, absoluteFilePath)
+			if err != nil {
+				atomic.AddInt64(&j.status.Errors, 1)
+				continue
+			}
+			changed = true
+			s.watcher.CreateFileAddedNotification(path, relativePath, &notification.MetaInfo{"storage": backupStorage})
+			atomic.AddInt64(&j.status.Queued, 1)
+		}
+		if changed {
+			atomic.AddInt64(&j.status.Changed, 1)
+		}
+	}
+	for backupStorage, values := range migrated {
+		if err := s.storage.AddAll(backupStorage, values); err != nil {
+			return err
+		}
+		log.Printf("[INFO] snapshot.scanBatch(): %d records of [%s] migrated to the %s checksum\n", len(values), backupStorage, algorithm)
+	}
+
+	j.Lock()
+	j.status.Checkpoint = batch[len(batch)-1]
+	j.Unlock()
+	status := j.snapshot()
+	if err := s.saveCheckpoint(&status); err != nil {
+		log.Printf("[ERROR] snapshot.scanBatch(): can't save checkpoint for [%s]: %v\n", path, err)
+	}
+	return nil
+}
+
+func (s *Snapshot) loadCheckpoint(path string) (*ScanStatus, error) {
+	value, err := s.storage.Get(path, checkpointBucket)
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, fmt.Errorf("no checkpoint for [%s]", path)
+	}
+	status := &ScanStatus{}
+	if err := json.Unmarshal([]byte(value), status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (s *Snapshot) saveCheckpoint(status *ScanStatus) error {
+	value, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return s.storage.Add(status.Path, checkpointBucket, value)
+}
+
+func (j *scanJob) state() string {
+	j.RLock()
+	defer j.RUnlock()
+	return j.status.State
+}
+
+// snapshot returns a consistent copy of the job status
+func (j *scanJob) snapshot() ScanStatus {
+	j.RLock()
+	defer j.RUnlock()
+	return ScanStatus{
+		Path:       j.status.Path,
+		State:      j.status.State,
+		Seen:       atomic.LoadInt64(&j.status.Seen),
+		Changed:    atomic.LoadInt64(&j.status.Changed),
+		Queued:     atomic.LoadInt64(&j.status.Queued),
+		Errors:     atomic.LoadInt64(&j.status.Errors),
+		Checkpoint: j.status.Checkpoint,
+		Error:      j.status.Error,
+		StartedAt:  j.status.StartedAt,
+		FinishedAt: j.status.FinishedAt,
+	}
+}
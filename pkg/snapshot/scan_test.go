@@ -0,0 +1,148 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/glower/bakku-app/pkg/message"
+	"github.com/glower/bakku-app/pkg/storage/memory"
+	"github.com/glower/bakku-app/pkg/types"
+)
+
+func TestAfterCheckpoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		checkpoint string
+		want       bool
+	}{
+		{
+			name:       "Scenario 1: a file in the same directory",
+			path:       filepath.Join("d", "b", "d"),
+			checkpoint: filepath.Join("d", "b", "c"),
+			want:       true,
+		},
+		{
+			name:       "Scenario 2: the checkpoint itself is done",
+			path:       filepath.Join("d", "b", "c"),
+			checkpoint: filepath.Join("d", "b", "c"),
+			want:       false,
+		},
+		{
+			name:       "Scenario 3: a directory which is the smaller string is visited later",
+			path:       filepath.Join("d", "b-x", "f"),
+			checkpoint: filepath.Join("d", "b", "c"),
+			want:       true,
+		},
+		{
+			name:       "Scenario 4: a file in an earlier directory",
+			path:       filepath.Join("d", "a", "z"),
+			checkpoint: filepath.Join("d", "b", "c"),
+			want:       false,
+		},
+		{
+			name:       "Scenario 5: a file next to the directory of the checkpoint",
+			path:       filepath.Join("d", "bz"),
+			checkpoint: filepath.Join("d", "b", "c"),
+			want:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := afterCheckpoint(tt.path, tt.checkpoint); got != tt.want {
+				t.Errorf("afterCheckpoint(%s, %s) = %v, want %v", tt.path, tt.checkpoint, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAfterCheckpoint_Resume resumes a walk after every file and checks that exactly the rest is visited
+func TestAfterCheckpoint_Resume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakku-scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"a/z", "b/c", "b/d", "b-x/f", "b.y", "bz/g", "c"} {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	walk := func(checkpoint string) []string {
+		var files []string
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			if checkpoint == "" || afterCheckpoint(path, checkpoint) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		return files
+	}
+
+	all := walk("")
+	for i, checkpoint := range all {
+		got := walk(checkpoint)
+		if want := all[i+1:]; !reflect.DeepEqual(got, want) && len(got)+len(want) > 0 {
+			t.Errorf("resume after [%s] visits %v, want %v", checkpoint, got, want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakku-scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	newSnapshot := func() *Snapshot {
+		return Setup(context.Background(), &types.GlobalResources{
+			Storage:   memory.New(),
+			MessageCh: make(chan message.Message, 10),
+		})
+	}
+
+	t.Run("Scenario 1: an interrupted scan resumes from its checkpoint", func(t *testing.T) {
+		s := newSnapshot()
+		checkpoint := filepath.Join(dir, "b", "c")
+		value, _ := json.Marshal(ScanStatus{Path: dir, State: ScanRunning, Seen: 7, Checkpoint: checkpoint})
+		if err := s.storage.Add(dir, checkpointBucket, value); err != nil {
+			t.Fatal(err)
+		}
+		status, err := s.Scan(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the scan must be done before the directory is removed
+		defer s.CancelScan(dir)
+		if status.Checkpoint != checkpoint || status.Seen != 7 {
+			t.Errorf("Scan() = %+v, want to resume after [%s] with 7 seen files", status, checkpoint)
+		}
+	})
+
+	t.Run("Scenario 2: snapshots don't share their scans", func(t *testing.T) {
+		s, other := newSnapshot(), newSnapshot()
+		if _, err := s.Scan(dir); err != nil {
+			t.Fatal(err)
+		}
+		if statuses := other.ScanStatuses(); len(statuses) != 0 {
+			t.Errorf("the other snapshot has the scans %+v", statuses)
+		}
+		if _, err := other.Scan(dir); err != nil {
+			t.Errorf("the same directory can be scanned by another snapshot: %v", err)
+		}
+		s.CancelScan(dir)
+		other.CancelScan(dir)
+	})
+}
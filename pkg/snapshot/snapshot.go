@@ -2,18 +2,16 @@ package snapshot
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"log"
-	"os"
-	"path/filepath"
+	"sync"
 
-	configstorage "github.com/glower/bakku-app/pkg/config/storage"
+	"github.com/glower/bakku-app/pkg/checksum"
 	"github.com/glower/bakku-app/pkg/message"
 	"github.com/glower/bakku-app/pkg/storage"
+	"github.com/glower/bakku-app/pkg/storage/record"
 	"github.com/glower/bakku-app/pkg/types"
 
-	"github.com/glower/file-watcher/notification"
-	fi "github.com/glower/file-watcher/util"
 	"github.com/glower/file-watcher/watcher"
 )
 
@@ -24,80 +22,86 @@ type Snapshot struct {
 	watcher   *watcher.Watch
 	storage   storage.Storager
 	messageCh chan message.Message
+
+	jobsM sync.RWMutex
+	// jobs are the scans of the watched directories, the last one of every directory is kept
+	jobs map[string]*scanJob
 }
 
 // Setup the snapshot storage
 func Setup(ctx context.Context, res *types.GlobalResources) *Snapshot {
 	snapShot := &Snapshot{
+		ctx:       ctx,
 		watcher:   res.FileWatcher,
 		storage:   res.Storage,
 		messageCh: res.MessageCh,
+		jobs:      make(map[string]*scanJob),
 	}
 	return snapShot
 }
 
-// CreateOrUpdate checks if files in a given dir was not backuped
+// CreateOrUpdate checks if files in a given dir was not backuped and blocks until the scan is finished
 func (s *Snapshot) CreateOrUpdate(path string) error {
 	log.Printf("[INFO] snapshot.update(): path=%s\n", path)
-
-	// read all supported backup storages form the config
-	backupStorages, err := configstorage.Active()
+	j, err := s.startScan(path)
 	if err != nil {
 		return err
 	}
+	<-j.done
 
-	return filepath.Walk(path, func(absoluteFilePath string, fileInfo os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !fileInfo.IsDir() {
+	status := j.snapshot()
+	switch status.State {
+	case ScanFailed:
+		return fmt.Errorf("scan of [%s] failed: %s", path, status.Error)
+	case ScanCanceled:
+		return fmt.Errorf("scan of [%s] was canceled", path)
+	}
+	return nil
+}
 
-			for _, backupStorage := range backupStorages {
-				if s.fileDifferentToBackup(backupStorage, absoluteFilePath) {
-					// fmt.Printf("Send [%s] to [%s]\n", absoluteFilePath, backupStorage)
-					relativePath, err := filepath.Rel(path, absoluteFilePath)
-					if err != nil {
-						return err
-					}
-					s.watcher.CreateFileAddedNotification(path, relativePath, &notification.MetaInfo{"storage": backupStorage})
-				}
-			}
+// fileSums computes the checksums of a file once for every algorithm
+type fileSums struct {
+	path   string
+	values map[string]string
+}
 
-		}
-		return nil
-	})
+func newFileSums(path string) *fileSums {
+	return &fileSums{path: path, values: make(map[string]string)}
 }
 
-func (s *Snapshot) fileDifferentToBackup(backupStorageName, absoluteFilePath string) bool {
-	snapshotEntry, err := s.storage.Get(absoluteFilePath, backupStorageName)
-	if err != nil {
-		// fmt.Printf("fileDifferentToBackup(): s.storage.Get err: %v\n", err)
-		return true
+// get returns the checksum or an empty string if the file can't be read
+func (f *fileSums) get(algorithm string) string {
+	if sum, ok := f.values[algorithm]; ok {
+		return sum
 	}
-	if snapshotEntry == "" {
-		// fmt.Printf("fileDifferentToBackup(): s.storage.Get empty data\n")
-		return true
-	}
-
-	e := &notification.Event{}
-	err = json.Unmarshal([]byte(snapshotEntry), e)
+	sum, err := checksum.File(f.path, algorithm)
 	if err != nil {
-		// fmt.Printf("fileDifferentToBackup(): unable to unmarshal file data [%q]: %v", snapshotEntry, err)
-		return true
+		log.Printf("[ERROR] snapshot.get(): can't compute the %s checksum of [%s]: %v\n", algorithm, f.path, err)
 	}
-	fileInfo, err := fi.GetFileInformation(absoluteFilePath)
-	if err != nil {
-		// fmt.Printf("fileDifferentToBackup(): unable to get [%s] info: %v", absoluteFilePath, err)
+	f.values[algorithm] = sum
+	return sum
+}
+
+// fileDifferentToBackup checks the snapshot record of a file against the file, the checksum is computed
+// with the algorithm of the record
+func fileDifferentToBackup(r *record.Record, sums *fileSums) bool {
+	if r == nil || r.Checksum.Value == "" {
 		return true
 	}
-	checksum, err := fileInfo.Checksum()
-	if err != nil {
-		// fmt.Printf("fileDifferentToBackup(): unable to get [%s] checksum: %v", absoluteFilePath, err)
-		return true
+	sum := sums.get(r.Checksum.Algorithm)
+	return sum == "" || sum != r.Checksum.Value
+}
+
+// migrateChecksum replaces the checksum of an unchanged file with the checksum of the configured algorithm,
+// it returns false if the record already has it
+func migrateChecksum(r *record.Record, sums *fileSums, algorithm string) bool {
+	if r.Checksum.Algorithm == algorithm {
+		return false
 	}
-	if e.Checksum != checksum {
-		// fmt.Printf("fileDifferentToBackup(): Old checksum: [%s] checksum: [%s]\n", e.Checksum, checksum)
-		return true
+	sum := sums.get(algorithm)
+	if sum == "" {
+		return false
 	}
-	return false
+	r.Checksum = record.Checksum{Algorithm: algorithm, Value: sum}
+	return true
 }
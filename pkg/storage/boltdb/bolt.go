@@ -1,112 +1,173 @@
 package boltdb
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/boltdb/bolt"
+
+	"github.com/glower/bakku-app/pkg/storage"
 )
 
-// BoltDB ...
+// openTimeout is how long Open waits for the file lock, only one process can have the database open
+const openTimeout = time.Second
+
+// defaultMetaBucket is used if no metadata bucket is configured
+const defaultMetaBucket = "snapshot"
+
+// versionKey is the key of the schema version in the metadata bucket
+const versionKey = "version"
+
+// BoltDB is a snapshot storage in one bolt database file, the file stays open until Close is called
 type BoltDB struct {
 	DBFilePath string
+
+	db         *bolt.DB
+	metaBucket string
+}
+
+func init() {
+	storage.RegisterBackend("boltdb", func(path string, opt storage.Options) (storage.Storager, error) {
+		db, err := Open(path, opt)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+}
+
+// Open opens or creates the database file
+func Open(path string, opt storage.Options) (*BoltDB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("bolt.Open(): can't open boltDB file [%s]: %v", path, err)
+	}
+	s := &BoltDB{DBFilePath: path, db: db, metaBucket: opt.MetaBucket}
+	if s.metaBucket == "" {
+		s.metaBucket = defaultMetaBucket
+	}
+	return s, nil
+}
+
+// Close closes the database file, the storage can't be used afterwards
+func (s *BoltDB) Close() error {
+	return s.db.Close()
 }
 
 // Exist checks if the database file exists
-func (s BoltDB) Exist() bool {
+func (s *BoltDB) Exist() bool {
 	if _, err := os.Stat(s.DBFilePath); os.IsNotExist(err) {
 		return false
 	}
 	return true
 }
 
-// Add info about file to the snapshot, filePath is the key and bucketName is the name of the backup storage
-func (s BoltDB) Add(filePath, bucketName string, value []byte) error {
-	// fmt.Printf("storage.Add(): [key=%s][bucket=%s][value=%q]\n", filePath, bucketName, string(value))
-	db, err := s.openDB()
-	if err != nil {
-		return err
-	}
-	defer func() {
-		err := db.Close() // handle this error
+// Add info about file to the snapshot, filePath is the key and bucketName is the name of the backup storage.
+// Concurrent calls are combined into one transaction.
+func (s *BoltDB) Add(filePath, bucketName string, value []byte) error {
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucketName))
 		if err != nil {
-			fmt.Printf("[ERROR] db.Close() faild: %v\n", err)
+			return err
 		}
-	}()
-	return db.Update(func(tx *bolt.Tx) error {
+		return b.Put([]byte(filePath), value)
+	})
+}
+
+// AddAll writes many entries of one bucket in a single transaction
+func (s *BoltDB) AddAll(bucketName string, values map[string][]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(bucketName))
 		if err != nil {
 			return err
 		}
-		return b.Put([]byte(filePath), value)
+		// bolt is a lot faster with keys in order
+		keys := make([]string, 0, len(values))
+		for filePath := range values {
+			keys = append(keys, filePath)
+		}
+		sort.Strings(keys)
+		for _, filePath := range keys {
+			if err := b.Put([]byte(filePath), values[filePath]); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
 // Get information about the file from the snapshot
-func (s BoltDB) Get(filePath, bucketName string) (string, error) {
-	// fmt.Printf("storage.Get(): [key=%s][bucket=%s]\n", filePath, bucketName)
+func (s *BoltDB) Get(filePath, bucketName string) (string, error) {
 	if filePath == "" {
 		return "", fmt.Errorf("bolt.Get(): the key(file path) is empty")
 	}
 
-	db, err := s.openDB()
+	var value string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b == nil {
+			return storage.BucketNotFoundError{Func: "bolt.Get()", Bucket: bucketName}
+		}
+		// the bytes are only valid during the transaction
+		value = string(b.Get([]byte(filePath)))
+		return nil
+	})
 	if err != nil {
 		return "", err
 	}
+	return value, nil
+}
 
-	defer func() {
-		err := db.Close()
-		if err != nil {
-			fmt.Printf("[ERROR] db.Close() faild: %v\n", err)
-		}
-	}()
-
-	var value []byte
-	err = db.View(func(tx *bolt.Tx) error {
+// GetMany reads many entries of one bucket in a single transaction, missing keys are not in the result
+func (s *BoltDB) GetMany(bucketName string, filePaths []string) (map[string]string, error) {
+	result := make(map[string]string, len(filePaths))
+	err := s.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucketName))
 		if b == nil {
-			return fmt.Errorf("bucket not found")
+			return nil
+		}
+		for _, filePath := range filePaths {
+			if v := b.Get([]byte(filePath)); v != nil {
+				result[filePath] = string(v)
+			}
 		}
-		value = b.Get([]byte(filePath))
 		return nil
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	return string(value), nil
+	return result, nil
 }
 
 // Remove file from the snapshot storage
-func (s BoltDB) Remove(filePath, bucketName string) error {
-	db, err := s.openDB()
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-
-	return db.Update(func(tx *bolt.Tx) error {
+func (s *BoltDB) Remove(filePath, bucketName string) error {
+	return s.db.Batch(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucketName))
-		err := b.Delete([]byte(filePath))
-		return err
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(filePath))
 	})
 }
 
 // GetAll return all entries from the snapshot storage for one bucket
-func (s BoltDB) GetAll(bucketName string) (map[string]string, error) {
-	db, _ := s.openDB()
-	defer db.Close()
+func (s *BoltDB) GetAll(bucketName string) (map[string]string, error) {
 	result := make(map[string]string)
-
-	err := db.View(func(tx *bolt.Tx) error {
+	err := s.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucketName))
 		if b == nil {
-			return fmt.Errorf("bolt.Get(): bucket [%s] not found", bucketName)
+			return storage.BucketNotFoundError{Func: "bolt.GetAll()", Bucket: bucketName}
 		}
 		return b.ForEach(func(k, v []byte) error {
-			filePath := string(k)
-			fileInfoJSON := string(v)
-			result[filePath] = fileInfoJSON
+			result[string(k)] = string(v)
 			return nil
 		})
 	})
@@ -116,11 +177,119 @@ func (s BoltDB) GetAll(bucketName string) (map[string]string, error) {
 	return result, nil
 }
 
-func (s BoltDB) openDB() (*bolt.DB, error) {
-	db, err := bolt.Open(s.DBFilePath, 0600, nil)
-	if err != nil {
-		fmt.Printf("[ERROR] bolt.openDB(): can't open boltDB file [%s]: %v\n", s.DBFilePath, err)
+// ForEachPrefix calls fn in key order for all entries of a bucket whose key starts with the prefix
+func (s *BoltDB) ForEachPrefix(bucketName, prefix string, fn func(key, value string) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			if err := fn(string(k), string(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Buckets returns the names of all buckets without the metadata bucket
+func (s *BoltDB) Buckets() ([]string, error) {
+	var result []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if string(name) != s.metaBucket {
+				result = append(result, string(name))
+			}
+			return nil
+		})
+	})
+	return result, err
+}
+
+// Update runs fn in a bolt transaction
+func (s *BoltDB) Update(fn func(storage.Tx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+// Version returns the schema version from the metadata bucket
+func (s *BoltDB) Version() (int, error) {
+	version := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.metaBucket))
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(versionKey))
+		if v == nil {
+			return nil
+		}
+		var err error
+		version, err = strconv.Atoi(string(v))
+		return err
+	})
+	return version, err
+}
+
+// SetVersion writes the schema version to the metadata bucket
+func (s *BoltDB) SetVersion(version int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(s.metaBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(versionKey), []byte(strconv.Itoa(version)))
+	})
+}
+
+// Backup writes a consistent copy of the database file
+func (s *BoltDB) Backup(suffix string) ([]string, error) {
+	path := s.DBFilePath + suffix
+	if err := s.CopyTo(path); err != nil {
 		return nil, err
 	}
-	return db, nil
+	return []string{path}, nil
+}
+
+// CopyTo writes a consistent copy of the database file to path
+func (s *BoltDB) CopyTo(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+}
+
+// boltTx is a storage.Tx of a bolt transaction
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) Get(key, bucketName string) (string, error) {
+	b := t.tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return "", nil
+	}
+	return string(b.Get([]byte(key))), nil
+}
+
+func (t boltTx) Put(key, bucketName string, value []byte) error {
+	b, err := t.tx.CreateBucketIfNotExists([]byte(bucketName))
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), value)
+}
+
+func (t boltTx) Delete(key, bucketName string) error {
+	b := t.tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return nil
+	}
+	return b.Delete([]byte(key))
 }
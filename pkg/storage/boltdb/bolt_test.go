@@ -6,8 +6,11 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/glower/bakku-app/pkg/storage"
 )
 
 func testDBFileName() string {
@@ -18,28 +21,37 @@ func testDBFilePath(testDBFileName string) string {
 	return filepath.Join(os.TempDir(), testDBFileName)
 }
 
+func openTestDB(t testing.TB) *BoltDB {
+	s, err := Open(testDBFilePath(testDBFileName()), storage.Options{})
+	if err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	return s
+}
+
+func closeTestDB(s *BoltDB) {
+	s.Close()
+	os.Remove(s.DBFilePath)
+}
+
 func TestStorage_Add(t *testing.T) {
-	testDBFileName := testDBFileName()
-	testDBFilePath := testDBFilePath(testDBFileName)
+	testStorage := openTestDB(t)
+	defer closeTestDB(testStorage)
 	type args struct {
 		filePath   string
 		bucketName string
 		value      []byte
 	}
 
-	testStorage := Storage{
-		DBFilePath: testDBFilePath,
-	}
-
 	tests := []struct {
 		name    string
-		s       *Storage
+		s       *BoltDB
 		args    args
 		wantErr bool
 	}{
 		{
 			name: "Scenario 1: add a value",
-			s:    &testStorage,
+			s:    testStorage,
 			args: args{
 				filePath:   "/foo",
 				bucketName: "test",
@@ -49,7 +61,7 @@ func TestStorage_Add(t *testing.T) {
 		},
 		// {
 		// 	name: "Scenario 2: empty bucket name",
-		// 	s:    &testStorage,
+		// 	s:    testStorage,
 		// 	args: args{
 		// 		filePath:   "/foo",
 		// 		bucketName: "",
@@ -59,7 +71,7 @@ func TestStorage_Add(t *testing.T) {
 		// },
 		// {
 		// 	name: "Scenario 3: empty key",
-		// 	s:    &testStorage,
+		// 	s:    testStorage,
 		// 	args: args{
 		// 		filePath:   "",
 		// 		bucketName: "test",
@@ -88,30 +100,26 @@ func TestStorage_Add(t *testing.T) {
 }
 
 func TestStorage_Get(t *testing.T) {
-	testDBFileName := testDBFileName()
-	testDBFilePath := testDBFilePath(testDBFileName)
+	testStorage := openTestDB(t)
+	defer closeTestDB(testStorage)
 	type args struct {
 		filePath   string
 		bucketName string
 	}
-	testStorage := Storage{
-		DBFilePath: testDBFilePath,
-	}
-
 	err := testStorage.Add("/foo/bar", "test", []byte("buzz"))
 	if err != nil {
 		t.Errorf("storage.Add(): error was not expected: [%v]", err)
 	}
 	tests := []struct {
 		name    string
-		s       *Storage
+		s       *BoltDB
 		args    args
 		want    string
 		wantErr bool
 	}{
 		{
 			name: "Scenario 1: get a value",
-			s:    &testStorage,
+			s:    testStorage,
 			args: args{
 				filePath:   "/foo/bar",
 				bucketName: "test",
@@ -121,7 +129,7 @@ func TestStorage_Get(t *testing.T) {
 		},
 		{
 			name: "Scenario 2: try to get a value from a wrong bucket",
-			s:    &testStorage,
+			s:    testStorage,
 			args: args{
 				filePath:   "/foo/bar",
 				bucketName: "test2",
@@ -131,7 +139,7 @@ func TestStorage_Get(t *testing.T) {
 		},
 		{
 			name: "Scenario 3: try to get a value from a wrong key",
-			s:    &testStorage,
+			s:    testStorage,
 			args: args{
 				filePath:   "/foo/bar/xxx",
 				bucketName: "test",
@@ -141,7 +149,7 @@ func TestStorage_Get(t *testing.T) {
 		},
 		{
 			name: "Scenario 4: try to get a value from an empty key",
-			s:    &testStorage,
+			s:    testStorage,
 			args: args{
 				filePath:   "",
 				bucketName: "test",
@@ -151,7 +159,7 @@ func TestStorage_Get(t *testing.T) {
 		},
 		{
 			name: "Scenario 5: try to get a value from an empty bucket",
-			s:    &testStorage,
+			s:    testStorage,
 			args: args{
 				filePath:   "/foo/bar",
 				bucketName: "",
@@ -175,16 +183,12 @@ func TestStorage_Get(t *testing.T) {
 }
 
 func TestStorage_GetAll(t *testing.T) {
-	testDBFileName := testDBFileName()
-	testDBFilePath := testDBFilePath(testDBFileName)
+	testStorage := openTestDB(t)
+	defer closeTestDB(testStorage)
 	type args struct {
 		bucketName string
 	}
 
-	testStorage := Storage{
-		DBFilePath: testDBFilePath,
-	}
-
 	err := testStorage.Add("/foo/bar", "test", []byte("buzz"))
 	if err != nil {
 		t.Errorf("storage.Add(): error was not expected: [%v]", err)
@@ -195,14 +199,14 @@ func TestStorage_GetAll(t *testing.T) {
 	}
 	tests := []struct {
 		name    string
-		s       *Storage
+		s       *BoltDB
 		args    args
 		want    map[string]string
 		wantErr bool
 	}{
 		{
 			name: "Scenario 1: get all items from a bucket name",
-			s:    &testStorage,
+			s:    testStorage,
 			args: args{
 				bucketName: "test",
 			},
@@ -214,7 +218,7 @@ func TestStorage_GetAll(t *testing.T) {
 		},
 		{
 			name: "Scenario 2: try to get all items from a wrong bucket name",
-			s:    &testStorage,
+			s:    testStorage,
 			args: args{
 				bucketName: "test2",
 			},
@@ -235,3 +239,142 @@ func TestStorage_GetAll(t *testing.T) {
 		})
 	}
 }
+
+func TestStorage_GetMany(t *testing.T) {
+	testStorage := openTestDB(t)
+	defer closeTestDB(testStorage)
+	err := testStorage.AddAll("test", map[string][]byte{"/a": []byte("1"), "/b": []byte("2")})
+	if err != nil {
+		t.Fatalf("storage.AddAll(): error was not expected: [%v]", err)
+	}
+
+	tests := []struct {
+		name       string
+		bucketName string
+		keys       []string
+		want       map[string]string
+	}{
+		{
+			name:       "Scenario 1: missing keys are not in the result",
+			bucketName: "test",
+			keys:       []string{"/a", "/b", "/c"},
+			want:       map[string]string{"/a": "1", "/b": "2"},
+		},
+		{
+			name:       "Scenario 2: a missing bucket has no entries",
+			bucketName: "test2",
+			keys:       []string{"/a"},
+			want:       map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testStorage.GetMany(tt.bucketName, tt.keys)
+			if err != nil {
+				t.Fatalf("Storage.GetMany(): error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Storage.GetMany(): got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// scanFiles is the number of files in the scan benchmarks
+const scanFiles = 100000
+
+// scanBatch is the number of files read at once, the same as in the snapshot scan
+const scanBatch = 100
+
+func benchmarkKeys() ([]string, map[string][]byte) {
+	keys := make([]string, scanFiles)
+	values := make(map[string][]byte, scanFiles)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/home/test/docs/%03d/file-%06d.txt", i%1000, i)
+		values[keys[i]] = []byte(`{"Checksum":"d41d8cd98f00b204e9800998ecf8427e","Size":1024}`)
+	}
+	return keys, values
+}
+
+// BenchmarkScan100k_Get reads the records of a 100k-file scan one by one
+func BenchmarkScan100k_Get(b *testing.B) {
+	s := openTestDB(b)
+	defer closeTestDB(s)
+	keys, values := benchmarkKeys()
+	if err := s.AddAll("local", values); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, key := range keys {
+			if _, err := s.Get(key, "local"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkScan100k_GetMany reads the records of a 100k-file scan in batches
+func BenchmarkScan100k_GetMany(b *testing.B) {
+	s := openTestDB(b)
+	defer closeTestDB(s)
+	keys, values := benchmarkKeys()
+	if err := s.AddAll("local", values); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < len(keys); i += scanBatch {
+			if _, err := s.GetMany("local", keys[i:i+scanBatch]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkScan100k_Add writes the records of 100k uploads from concurrent workers
+func BenchmarkScan100k_Add(b *testing.B) {
+	keys, values := benchmarkKeys()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		s := openTestDB(b)
+		b.StartTimer()
+		work := make(chan string)
+		var wg sync.WaitGroup
+		for w := 0; w < 64; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for key := range work {
+					if err := s.Add(key, "local", values[key]); err != nil {
+						b.Error(err)
+					}
+				}
+			}()
+		}
+		for _, key := range keys {
+			work <- key
+		}
+		close(work)
+		wg.Wait()
+		b.StopTimer()
+		closeTestDB(s)
+		b.StartTimer()
+	}
+}
+
+// BenchmarkScan100k_AddAll writes the records of 100k files in one transaction
+func BenchmarkScan100k_AddAll(b *testing.B) {
+	_, values := benchmarkKeys()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		s := openTestDB(b)
+		b.StartTimer()
+		if err := s.AddAll("local", values); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		closeTestDB(s)
+		b.StartTimer()
+	}
+}
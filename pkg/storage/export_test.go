@@ -0,0 +1,8 @@
+package storage
+
+// NewSameDir returns a sameDir storage for the given watched directories
+func NewSameDir(central Storager, open OpenFunc, backupDir, fileName string, dirs ...string) Storager {
+	s := newSameDir(central, open, Options{}, fileName, backupDir)
+	s.watched = func() []string { return dirs }
+	return s
+}
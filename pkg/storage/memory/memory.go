@@ -0,0 +1,205 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/glower/bakku-app/pkg/storage"
+)
+
+// Memory is a snapshot storage in memory for tests, it is not registered as a backend and can't be
+// selected with snapshot.default
+type Memory struct {
+	sync.RWMutex
+	buckets map[string]map[string]string
+	version int
+}
+
+// New returns an empty storage
+func New() *Memory {
+	return &Memory{buckets: make(map[string]map[string]string)}
+}
+
+// Exist is always true, there is no file
+func (m *Memory) Exist() bool { return true }
+
+// Backup does nothing, there is no file
+func (m *Memory) Backup(string) ([]string, error) { return nil, nil }
+
+// Close does nothing, the data is gone with the storage
+func (m *Memory) Close() error { return nil }
+
+// Add info about file to the snapshot
+func (m *Memory) Add(filePath, bucketName string, value []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.put(filePath, bucketName, string(value))
+	return nil
+}
+
+// AddAll writes many entries of one bucket at once
+func (m *Memory) AddAll(bucketName string, values map[string][]byte) error {
+	m.Lock()
+	defer m.Unlock()
+	for k, v := range values {
+		m.put(k, bucketName, string(v))
+	}
+	return nil
+}
+
+// Get information about the file from the snapshot
+func (m *Memory) Get(filePath, bucketName string) (string, error) {
+	if filePath == "" {
+		return "", fmt.Errorf("memory.Get(): the key(file path) is empty")
+	}
+	m.RLock()
+	defer m.RUnlock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return "", storage.BucketNotFoundError{Func: "memory.Get()", Bucket: bucketName}
+	}
+	return b[filePath], nil
+}
+
+// GetMany reads many entries of one bucket at once, missing keys are not in the result
+func (m *Memory) GetMany(bucketName string, filePaths []string) (map[string]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	result := make(map[string]string, len(filePaths))
+	b := m.buckets[bucketName]
+	for _, k := range filePaths {
+		if v, ok := b[k]; ok {
+			result[k] = v
+		}
+	}
+	return result, nil
+}
+
+// GetAll return all entries of one bucket
+func (m *Memory) GetAll(bucketName string) (map[string]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, storage.BucketNotFoundError{Func: "memory.GetAll()", Bucket: bucketName}
+	}
+	result := make(map[string]string, len(b))
+	for k, v := range b {
+		result[k] = v
+	}
+	return result, nil
+}
+
+// Remove file from the snapshot storage
+func (m *Memory) Remove(filePath, bucketName string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.buckets[bucketName], filePath)
+	return nil
+}
+
+// ForEachPrefix calls fn in key order for all entries of a bucket whose key starts with the prefix
+func (m *Memory) ForEachPrefix(bucketName, prefix string, fn func(key, value string) error) error {
+	m.RLock()
+	var keys []string
+	values := make(map[string]string)
+	for k, v := range m.buckets[bucketName] {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+			values[k] = v
+		}
+	}
+	m.RUnlock()
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn(k, values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Buckets returns the names of all buckets
+func (m *Memory) Buckets() ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	var result []string
+	for name := range m.buckets {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Update runs fn with the storage locked, the writes are applied when fn returns without error
+func (m *Memory) Update(fn func(storage.Tx) error) error {
+	m.Lock()
+	defer m.Unlock()
+	tx := &memoryTx{m: m}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for _, w := range tx.writes {
+		if w.delete {
+			delete(m.buckets[w.bucket], w.key)
+		} else {
+			m.put(w.key, w.bucket, w.value)
+		}
+	}
+	return nil
+}
+
+// Version returns the schema version
+func (m *Memory) Version() (int, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.version, nil
+}
+
+// SetVersion sets the schema version
+func (m *Memory) SetVersion(version int) error {
+	m.Lock()
+	defer m.Unlock()
+	m.version = version
+	return nil
+}
+
+func (m *Memory) put(key, bucketName, value string) {
+	if m.buckets[bucketName] == nil {
+		m.buckets[bucketName] = make(map[string]string)
+	}
+	m.buckets[bucketName][key] = value
+}
+
+type memoryWrite struct {
+	key, bucket, value string
+	delete             bool
+}
+
+// memoryTx collects the writes of a transaction, the storage is locked by Update
+type memoryTx struct {
+	m      *Memory
+	writes []memoryWrite
+}
+
+func (tx *memoryTx) Get(key, bucketName string) (string, error) {
+	for i := len(tx.writes) - 1; i >= 0; i-- {
+		if w := tx.writes[i]; w.key == key && w.bucket == bucketName {
+			return w.value, nil
+		}
+	}
+	return tx.m.buckets[bucketName][key], nil
+}
+
+func (tx *memoryTx) Put(key, bucketName string, value []byte) error {
+	tx.writes = append(tx.writes, memoryWrite{key: key, bucket: bucketName, value: string(value)})
+	return nil
+}
+
+func (tx *memoryTx) Delete(key, bucketName string) error {
+	tx.writes = append(tx.writes, memoryWrite{key: key, bucket: bucketName, delete: true})
+	return nil
+}
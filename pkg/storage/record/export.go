@@ -0,0 +1,308 @@
+package record
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glower/bakku-app/pkg/storage"
+)
+
+// Formats of the export
+const (
+	// FormatJSONL is one record per line, it keeps everything
+	FormatJSONL = "jsonl"
+	// FormatCSV has no versions, it's meant for reports in other tools
+	FormatCSV = "csv"
+)
+
+var csvHeader = []string{"storage", "path", "dir", "size", "mtime", "checksum_algorithm", "checksum", "remote_id", "stored"}
+
+// Filter selects the exported or imported records
+type Filter struct {
+	// Storages are the names of the storages like storage.local, all storages if empty
+	Storages []string `json:"storages,omitempty"`
+	// Prefix of the file path
+	Prefix string `json:"path,omitempty"`
+}
+
+func (f *Filter) match(r *Record) bool {
+	if !strings.HasPrefix(r.Path, f.Prefix) {
+		return false
+	}
+	if len(f.Storages) == 0 {
+		return true
+	}
+	for _, s := range f.Storages {
+		if s == r.Storage {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportOptions control how records are imported
+type ImportOptions struct {
+	Filter
+	// Overwrite replaces existing records, by default only missing records are added
+	Overwrite bool `json:"overwrite"`
+	// DryRun only reports what would be changed
+	DryRun bool `json:"dryRun"`
+	// From and To replace the path prefix From with To, e.g. if the files are in another home directory
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// ImportReport is the result of an import
+type ImportReport struct {
+	DryRun  bool `json:"dryRun"`
+	Records int  `json:"records"`
+	Added   int  `json:"added"`
+	Updated int  `json:"updated"`
+	// Existing records are kept
+	Existing int `json:"existing"`
+	// Skipped records don't match the filter
+	Skipped int `json:"skipped"`
+}
+
+// ValidFormat returns an error for unknown export formats
+func ValidFormat(format string) error {
+	if format != FormatJSONL && format != FormatCSV {
+		return fmt.Errorf("unknown format [%s], use %s or %s", format, FormatJSONL, FormatCSV)
+	}
+	return nil
+}
+
+// Export writes all records which match the filter sorted by storage and path, it returns the number of records
+func Export(db storage.Storager, w io.Writer, format string, f Filter) (int, error) {
+	if err := ValidFormat(format); err != nil {
+		return 0, err
+	}
+	buckets, err := db.Buckets()
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	enc := json.NewEncoder(bw)
+	if format == FormatCSV {
+		cw.Write(csvHeader)
+	}
+	n := 0
+	for _, bucket := range buckets {
+		if !strings.HasPrefix(bucket, storagePrefix) {
+			continue
+		}
+		err := db.ForEachPrefix(bucket, f.Prefix, func(key, value string) error {
+			r, err := Decode(value, bucket)
+			if err != nil {
+				return fmt.Errorf("invalid snapshot entry for [%s]: %v", key, err)
+			}
+			if r.Storage == "" {
+				r.Storage = bucket
+			}
+			if !f.match(r) {
+				return nil
+			}
+			n++
+			if format == FormatCSV {
+				return cw.Write(csvRow(r))
+			}
+			return enc.Encode(r)
+		})
+		if err != nil {
+			return n, err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// Import reads exported records and writes them to the snapshot database
+func Import(db storage.Storager, r io.Reader, format string, opt ImportOptions) (*ImportReport, error) {
+	if err := ValidFormat(format); err != nil {
+		return nil, err
+	}
+	var records []*Record
+	var err error
+	if format == FormatCSV {
+		records, err = readCSV(r)
+	} else {
+		records, err = readJSONL(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: opt.DryRun, Records: len(records)}
+	buckets := make(map[string][]*Record)
+	for _, rec := range records {
+		if !opt.match(rec) {
+			report.Skipped++
+			continue
+		}
+		if opt.From != "" && strings.HasPrefix(rec.Path, opt.From) {
+			rec.Path = opt.To + strings.TrimPrefix(rec.Path, opt.From)
+			if strings.HasPrefix(rec.Dir, opt.From) {
+				rec.Dir = opt.To + strings.TrimPrefix(rec.Dir, opt.From)
+			}
+		}
+		buckets[rec.Storage] = append(buckets[rec.Storage], rec)
+	}
+
+	for bucket, recs := range buckets {
+		keys := make([]string, len(recs))
+		for i, rec := range recs {
+			keys[i] = rec.Path
+		}
+		existing, err := db.GetMany(bucket, keys)
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[string][]byte)
+		for _, rec := range recs {
+			_, ok := existing[rec.Path]
+			switch {
+			case ok && !opt.Overwrite:
+				report.Existing++
+				continue
+			case ok:
+				report.Updated++
+			default:
+				report.Added++
+			}
+			value, err := rec.Encode()
+			if err != nil {
+				return nil, err
+			}
+			values[rec.Path] = value
+		}
+		if opt.DryRun || len(values) == 0 {
+			continue
+		}
+		if err := db.AddAll(bucket, values); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func readJSONL(r io.Reader) ([]*Record, error) {
+	var records []*Record
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		rec := &Record{}
+		err := dec.Decode(rec)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %v", line, err)
+		}
+		if err := valid(rec); err != nil {
+			return nil, fmt.Errorf("record %d: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func readCSV(r io.Reader) ([]*Record, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+	var records []*Record
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && row[0] == csvHeader[0] {
+			continue
+		}
+		rec, err := fromCSV(row)
+		if err == nil {
+			err = valid(rec)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func valid(r *Record) error {
+	switch {
+	case r.V > Version:
+		return fmt.Errorf("unknown record version %d", r.V)
+	case r.Path == "":
+		return fmt.Errorf("path is empty")
+	case !strings.HasPrefix(r.Storage, storagePrefix):
+		return fmt.Errorf("invalid storage [%s]", r.Storage)
+	}
+	return nil
+}
+
+func csvRow(r *Record) []string {
+	return []string{
+		r.Storage,
+		r.Path,
+		r.Dir,
+		strconv.FormatInt(r.Size, 10),
+		formatTime(r.ModTime),
+		r.Checksum.Algorithm,
+		r.Checksum.Value,
+		r.RemoteID,
+		formatTime(r.Stored),
+	}
+}
+
+func fromCSV(row []string) (*Record, error) {
+	r := &Record{
+		V:        Version,
+		Storage:  row[0],
+		Path:     row[1],
+		Dir:      row[2],
+		Checksum: Checksum{Algorithm: row[5], Value: row[6]},
+		RemoteID: row[7],
+	}
+	var err error
+	if r.Size, err = strconv.ParseInt(row[3], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid size [%s]", row[3])
+	}
+	if r.ModTime, err = parseTime(row[4]); err != nil {
+		return nil, err
+	}
+	if r.Stored, err = parseTime(row[8]); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time [%s]", s)
+	}
+	return t, nil
+}
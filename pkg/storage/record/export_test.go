@@ -0,0 +1,83 @@
+package record
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/glower/bakku-app/pkg/storage/memory"
+)
+
+func TestExportImport(t *testing.T) {
+	src := memory.New()
+	for _, r := range []*Record{
+		{Path: "/home/old/docs/a.txt", Dir: "/home/old/docs", Storage: "storage.local", Size: 1, Checksum: Checksum{AlgorithmDefault, "a"}},
+		{Path: "/home/old/docs/b.txt", Dir: "/home/old/docs", Storage: "storage.local", Size: 2, Checksum: Checksum{AlgorithmDefault, "b"}},
+		{Path: "/home/old/pics/c.jpg", Dir: "/home/old/pics", Storage: "storage.gdrive", Size: 3, Stored: time.Unix(1560000000, 0).UTC()},
+	} {
+		value, _ := r.Encode()
+		src.Add(r.Path, r.Storage, value)
+	}
+	src.Add("/home/old/docs", "snapshot.scan", []byte("{}"))
+
+	tests := []struct {
+		name        string
+		format      string
+		filter      Filter
+		opt         ImportOptions
+		wantRecords int
+		wantAdded   int
+		wantKey     string
+	}{
+		{
+			name:        "Scenario 1: jsonl export of all storages",
+			format:      FormatJSONL,
+			wantRecords: 3,
+			wantAdded:   3,
+			wantKey:     "/home/old/docs/a.txt",
+		},
+		{
+			name:        "Scenario 2: csv export of one storage",
+			format:      FormatCSV,
+			filter:      Filter{Storages: []string{"storage.gdrive"}},
+			wantRecords: 1,
+			wantAdded:   1,
+			wantKey:     "/home/old/pics/c.jpg",
+		},
+		{
+			name:        "Scenario 3: import with a path prefix and a new home directory",
+			format:      FormatJSONL,
+			opt:         ImportOptions{Filter: Filter{Prefix: "/home/old/docs"}, From: "/home/old", To: "/home/new"},
+			wantRecords: 3,
+			wantAdded:   2,
+			wantKey:     "/home/new/docs/b.txt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if _, err := Export(src, buf, tt.format, tt.filter); err != nil {
+				t.Fatal(err)
+			}
+			dst := memory.New()
+			report, err := Import(dst, buf, tt.format, tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Records != tt.wantRecords || report.Added != tt.wantAdded {
+				t.Errorf("Import() = %+v, want records=%d added=%d", report, tt.wantRecords, tt.wantAdded)
+			}
+			all, _ := dst.Buckets()
+			found := false
+			for _, bucket := range all {
+				if value, _ := dst.Get(tt.wantKey, bucket); value != "" {
+					r, _ := Decode(value, bucket)
+					found = r.Path == tt.wantKey
+				}
+			}
+			if !found {
+				t.Errorf("record [%s] was not imported", tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package record
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/glower/bakku-app/pkg/storage"
+)
+
+// storagePrefix is the prefix of the buckets with the records of a backup storage
+const storagePrefix = "storage."
+
+// migration changes the stored data from the version before to its version
+type migration struct {
+	version int
+	name    string
+	migrate func(db storage.Storager) error
+}
+
+// migrations must be sorted by version, the version of the last one is Version
+var migrations = []migration{
+	{version: 1, name: "file events to records", migrate: eventsToRecords},
+}
+
+// Migrate brings the snapshot database to the current schema version, the database files are copied
+// with the suffix .v<old version>.bak next to the central database before anything is changed.
+// Version, Buckets and Backup don't route keys, so sameDir moves no records before the backup.
+func Migrate(db storage.Storager) error {
+	version, err := db.Version()
+	if err != nil {
+		return fmt.Errorf("can't read the schema version: %v", err)
+	}
+	if version == Version {
+		return nil
+	}
+	if version > Version {
+		return fmt.Errorf("the snapshot database has schema version %d, this version of bakku knows only %d", version, Version)
+	}
+
+	buckets, err := db.Buckets()
+	if err != nil {
+		return err
+	}
+	if len(buckets) == 0 {
+		// a new database
+		return db.SetVersion(Version)
+	}
+
+	files, err := db.Backup(fmt.Sprintf(".v%d.bak", version))
+	if err != nil {
+		return fmt.Errorf("can't backup the snapshot database before the migration: %v", err)
+	}
+	log.Printf("[INFO] record.Migrate(): backup of the snapshot database before the migration: %v\n", files)
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		log.Printf("[INFO] record.Migrate(): migrate the snapshot database to version %d: %s\n", m.version, m.name)
+		if err := m.migrate(db); err != nil {
+			return fmt.Errorf("migration to version %d failed, the backup is in %v: %v", m.version, files, err)
+		}
+		if err := db.SetVersion(m.version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventsToRecords replaces the file events which were stored before version 1 with records. Records are
+// kept as they are, so an interrupted migration which wrote only some databases can run again.
+func eventsToRecords(db storage.Storager) error {
+	buckets, err := db.Buckets()
+	if err != nil {
+		return err
+	}
+	for _, bucket := range buckets {
+		if !strings.HasPrefix(bucket, storagePrefix) {
+			continue
+		}
+		values := make(map[string]string)
+		err := db.ForEachPrefix(bucket, "", func(key, value string) error {
+			values[key] = value
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = db.Update(func(tx storage.Tx) error {
+			for key, value := range values {
+				r, err := Decode(value, bucket)
+				if err != nil {
+					// the next scan uploads the file again and writes a new record
+					log.Printf("[ERROR] record.eventsToRecords(): invalid entry [%s] in [%s] is removed: %v\n", key, bucket, err)
+					if err := tx.Delete(key, bucket); err != nil {
+						return err
+					}
+					continue
+				}
+				encoded, err := r.Encode()
+				if err != nil {
+					return err
+				}
+				if err := tx.Put(key, bucket, encoded); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] record.eventsToRecords(): %d entries of [%s] migrated\n", len(values), bucket)
+	}
+	return nil
+}
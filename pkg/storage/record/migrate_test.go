@@ -0,0 +1,66 @@
+package record
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/storage/memory"
+)
+
+func TestMigrate(t *testing.T) {
+	db := memory.New()
+	event, _ := json.Marshal(notification.Event{
+		AbsolutePath:  "/home/test/docs/a.txt",
+		DirectoryPath: "/home/test/docs",
+		RelativePath:  "a.txt",
+		Size:          3,
+		Checksum:      "abc",
+	})
+	db.Add("/home/test/docs/a.txt", "storage.local", event)
+	db.Add("/home/test/docs/broken.txt", "storage.local", []byte("{"))
+	db.Add("/home/test/docs", "snapshot.scan", []byte(`{"path":"/home/test/docs"}`))
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	version, _ := db.Version()
+	migrated, _ := db.Get("/home/test/docs/a.txt", "storage.local")
+	broken, _ := db.Get("/home/test/docs/broken.txt", "storage.local")
+	checkpoint, _ := db.Get("/home/test/docs", "snapshot.scan")
+	r, err := Decode(migrated, "storage.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an interrupted migration runs again on the next start, sameDir writes every directory on its own
+	db.SetVersion(0)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := db.Get("/home/test/docs/a.txt", "storage.local")
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"Scenario 1: the schema version is set", version, Version},
+		{"Scenario 2: events are records", r.V, Version},
+		{"Scenario 3: the checksum is kept", r.Checksum, Checksum{Algorithm: AlgorithmDefault, Value: "abc"}},
+		{"Scenario 4: the storage is set", r.Storage, "storage.local"},
+		{"Scenario 5: the relative path is restored", r.Event().RelativePath, "a.txt"},
+		{"Scenario 6: invalid entries are removed", broken, ""},
+		{"Scenario 7: other buckets are not changed", checkpoint, `{"path":"/home/test/docs"}`},
+		{"Scenario 8: migrating the records again doesn't change them", again, migrated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
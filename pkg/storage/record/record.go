@@ -0,0 +1,155 @@
+// Package record defines the snapshot entries of bakku, they don't depend on the events of the file watcher
+package record
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/glower/file-watcher/notification"
+
+	"github.com/glower/bakku-app/pkg/checksum"
+)
+
+// Version is the current version of the record format and the schema version of the snapshot database
+const Version = 1
+
+// AlgorithmDefault is the checksum of the file watcher util package
+const AlgorithmDefault = checksum.Default
+
+// maxVersions is the number of former versions kept in a record
+const maxVersions = 10
+
+// Checksum is a checksum with the algorithm which computed it
+type Checksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// FileVersion is a former backup of a file
+type FileVersion struct {
+	Stored   time.Time `json:"stored"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	Checksum Checksum  `json:"checksum"`
+	Verify   *Checksum `json:"verify,omitempty"`
+	RemoteID string    `json:"remoteId,omitempty"`
+}
+
+// Record is the snapshot entry of a file backuped to one storage
+type Record struct {
+	// V is the version of the format
+	V       int    `json:"v"`
+	Path    string `json:"path"`
+	Storage string `json:"storage"`
+	// Dir is the watched directory of the file
+	Dir     string    `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// Checksum is computed with the change detection algorithm
+	Checksum Checksum `json:"checksum"`
+	// Verify is computed with the verification algorithm, records written before it was configured have none
+	Verify *Checksum `json:"verify,omitempty"`
+	// RemoteID is the id of the file in the storage, only set by storages which have one
+	RemoteID string    `json:"remoteId,omitempty"`
+	Stored   time.Time `json:"stored"`
+	// Versions are the former backups, the newest first
+	Versions []FileVersion `json:"versions,omitempty"`
+}
+
+// FromEvent returns the record of an uploaded file
+func FromEvent(e *notification.Event, storageName string) *Record {
+	r := &Record{
+		V:        Version,
+		Path:     e.AbsolutePath,
+		Storage:  storageName,
+		Dir:      e.DirectoryPath,
+		Size:     e.Size,
+		Checksum: Checksum{Algorithm: AlgorithmDefault, Value: e.Checksum},
+		Stored:   e.Timestamp,
+	}
+	if r.Stored.IsZero() {
+		r.Stored = time.Now()
+	}
+	if info, err := os.Stat(e.AbsolutePath); err == nil {
+		r.ModTime = info.ModTime()
+	}
+	return r
+}
+
+// Event returns the file event of the record, it's used to restore the file
+func (r *Record) Event() *notification.Event {
+	rel, err := filepath.Rel(r.Dir, r.Path)
+	if err != nil || r.Dir == "" {
+		rel = filepath.Base(r.Path)
+	}
+	return &notification.Event{
+		FileName:      filepath.Base(r.Path),
+		Size:          r.Size,
+		Checksum:      r.Checksum.Value,
+		DirectoryPath: r.Dir,
+		AbsolutePath:  r.Path,
+		RelativePath:  rel,
+		Action:        notification.FileAdded,
+		Timestamp:     r.Stored,
+	}
+}
+
+// Replace makes the record the new backup of a file, the former backup is added to the versions
+func (r *Record) Replace(former *Record) {
+	if former == nil {
+		return
+	}
+	v := FileVersion{
+		Stored:   former.Stored,
+		Size:     former.Size,
+		ModTime:  former.ModTime,
+		Checksum: former.Checksum,
+		Verify:   former.Verify,
+		RemoteID: former.RemoteID,
+	}
+	r.Versions = append([]FileVersion{v}, former.Versions...)
+	if len(r.Versions) > maxVersions {
+		r.Versions = r.Versions[:maxVersions]
+	}
+}
+
+// Encode returns the stored value of the record
+func (r *Record) Encode() ([]byte, error) {
+	r.V = Version
+	return json.Marshal(r)
+}
+
+// Decode reads a stored value, values written before the records had a version are file events
+func Decode(value string, storageName string) (*Record, error) {
+	// the field names of both formats overlap, the version decides which one it is
+	probe := struct {
+		V int `json:"v"`
+	}{}
+	if err := json.Unmarshal([]byte(value), &probe); err != nil {
+		return nil, err
+	}
+	if probe.V > 0 {
+		r := &Record{}
+		if err := json.Unmarshal([]byte(value), r); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+
+	e := &notification.Event{}
+	if err := json.Unmarshal([]byte(value), e); err != nil {
+		return nil, err
+	}
+	r := &Record{
+		V:        Version,
+		Path:     e.AbsolutePath,
+		Storage:  storageName,
+		Dir:      e.DirectoryPath,
+		Size:     e.Size,
+		Checksum: Checksum{Algorithm: AlgorithmDefault, Value: e.Checksum},
+		Stored:   e.Timestamp,
+	}
+	return r, nil
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/glower/bakku-app/pkg/config/watchdir"
+)
+
+// Storage is the snapshot database of one watched directory
+type Storage interface {
+	Storager
+	// Path is the watched directory
+	Path() string
+	// FilePath is the database file
+	FilePath() string
+	FileName() string
+}
+
+var (
+	storagesM sync.RWMutex
+	storages  = make(map[string]Storage)
+)
+
+// Register adds the database of a watched directory
+func Register(s Storage) error {
+	if s == nil {
+		return fmt.Errorf("snapshot storage is nil")
+	}
+	if s.Path() == "" {
+		return fmt.Errorf("snapshot storage has no path")
+	}
+	path := filepath.Clean(s.Path())
+	storagesM.Lock()
+	defer storagesM.Unlock()
+	if _, ok := storages[path]; ok {
+		return fmt.Errorf("snapshot storage for [%s] is already registered", path)
+	}
+	storages[path] = s
+	return nil
+}
+
+// GetByPath returns the database of the watched directory which contains the path
+func GetByPath(path string) (Storage, error) {
+	storagesM.RLock()
+	defer storagesM.RUnlock()
+	if len(storages) == 0 {
+		return nil, fmt.Errorf("snapshot storage is empty")
+	}
+	if path == "" {
+		return nil, fmt.Errorf("path is empty")
+	}
+	// the longest directory wins if watched directories are nested
+	var found Storage
+	for dir, s := range storages {
+		if watchdir.Contains(dir, path) && (found == nil || len(dir) > len(filepath.Clean(found.Path()))) {
+			found = s
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no snapshot storage for [%s]", path)
+	}
+	return found, nil
+}
+
+func unregister(path string) {
+	storagesM.Lock()
+	defer storagesM.Unlock()
+	delete(storages, filepath.Clean(path))
+}
+
+// registered returns the databases of all watched directories
+func registered() []Storage {
+	storagesM.RLock()
+	defer storagesM.RUnlock()
+	var result []Storage
+	for _, s := range storages {
+		result = append(result, s)
+	}
+	return result
+}
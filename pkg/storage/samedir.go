@@ -0,0 +1,484 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/glower/bakku-app/pkg/config"
+	"github.com/glower/bakku-app/pkg/config/watchdir"
+)
+
+// dirStorage is the database of a watched directory
+type dirStorage struct {
+	Storager
+	path     string
+	filePath string
+}
+
+func (s *dirStorage) Path() string     { return s.path }
+func (s *dirStorage) FilePath() string { return s.filePath }
+func (s *dirStorage) FileName() string { return filepath.Base(s.filePath) }
+
+// sameDir keeps the records of every watched directory in a database inside this directory, so the
+// snapshot moves together with the files. Records of other paths are kept in the central database.
+type sameDir struct {
+	sync.Mutex
+	central  Storager
+	open     OpenFunc
+	opt      Options
+	fileName string
+	dirs     []*dirStorage
+	// watched returns the watched directories, they are read from the config
+	watched func() []string
+	// failed are directories where no database can be created, e.g. read-only directories
+	failed map[string]bool
+	// backupDir is the directory of the central database, backups are not written into the watched
+	// directories where they would be uploaded
+	backupDir string
+}
+
+func newSameDir(central Storager, open OpenFunc, opt Options, fileName, backupDir string) *sameDir {
+	return &sameDir{
+		central:   central,
+		open:      open,
+		opt:       opt,
+		fileName:  fileName,
+		watched:   config.WatchedDirs,
+		failed:    make(map[string]bool),
+		backupDir: backupDir,
+	}
+}
+
+// route returns the database for a key, the database of a watched directory is opened on first use
+func (s *sameDir) route(key string) Storager {
+	if dir := s.watchedDir(key); dir != "" {
+		return s.openDir(dir)
+	}
+	// the directory was removed from the config
+	if db, err := GetByPath(key); err == nil {
+		return db
+	}
+	return s.central
+}
+
+// watchedDir returns the watched directory which contains the path
+func (s *sameDir) watchedDir(path string) string {
+	return watchdir.Of(s.watched(), path)
+}
+
+func (s *sameDir) openDir(dir string) Storager {
+	s.Lock()
+	defer s.Unlock()
+	for _, d := range s.dirs {
+		if d.path == dir {
+			return d
+		}
+	}
+	if s.failed[dir] {
+		return s.central
+	}
+
+	filePath := filepath.Join(dir, s.fileName)
+	_, err := os.Stat(filePath)
+	created := os.IsNotExist(err)
+	db, err := s.open(filePath, s.opt)
+	if err != nil {
+		log.Printf("[ERROR] storage.openDir(): can't open the snapshot of [%s], using the central database: %v\n", dir, err)
+		s.failed[dir] = true
+		return s.central
+	}
+	d := &dirStorage{Storager: db, path: dir, filePath: filePath}
+	if created {
+		// the moved records have the schema of the central database
+		if version, err := s.central.Version(); err == nil {
+			db.SetVersion(version)
+		}
+	}
+	if err := s.moveFromCentral(d); err != nil {
+		log.Printf("[ERROR] storage.openDir(): can't move the records of [%s] from the central database: %v\n", dir, err)
+		db.Close()
+		if created {
+			os.Remove(filePath)
+		}
+		s.failed[dir] = true
+		return s.central
+	}
+	if err := Register(d); err != nil {
+		log.Printf("[ERROR] storage.openDir(): %v\n", err)
+	}
+	s.dirs = append(s.dirs, d)
+	return d
+}
+
+// moveFromCentral moves the records of a directory which were written before sameDir was used
+func (s *sameDir) moveFromCentral(d *dirStorage) error {
+	buckets, err := s.central.Buckets()
+	if err != nil {
+		return err
+	}
+	for _, bucket := range buckets {
+		values := make(map[string][]byte)
+		err := s.central.ForEachPrefix(bucket, d.path, func(key, value string) error {
+			if watchdir.Contains(d.path, key) {
+				values[key] = []byte(value)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if err := d.AddAll(bucket, values); err != nil {
+			return err
+		}
+		err = s.central.Update(func(tx Tx) error {
+			for key := range values {
+				if err := tx.Delete(key, bucket); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] storage.moveFromCentral(): moved %d records of [%s] to [%s]\n", len(values), bucket, d.filePath)
+	}
+	return nil
+}
+
+// all opens the databases of all watched directories and returns them with the central database
+func (s *sameDir) all() []Storager {
+	for _, dir := range s.watched() {
+		s.route(dir)
+	}
+	s.Lock()
+	defer s.Unlock()
+	result := []Storager{s.central}
+	for _, d := range s.dirs {
+		result = append(result, d)
+	}
+	return result
+}
+
+// existing calls fn for the central database and the databases of the watched directories which
+// exist already, nothing is created or moved. A database which was not used yet is opened only for fn,
+// so the schema version and the backup can be read before the first record is moved.
+func (s *sameDir) existing(fn func(db Storager, dir string) error) error {
+	if err := fn(s.central, ""); err != nil {
+		return err
+	}
+	s.Lock()
+	opened := make(map[string]Storager, len(s.dirs))
+	for _, d := range s.dirs {
+		opened[d.path] = d
+	}
+	s.Unlock()
+	for _, dir := range s.watched() {
+		if db, ok := opened[dir]; ok {
+			if err := fn(db, dir); err != nil {
+				return err
+			}
+			continue
+		}
+		filePath := filepath.Join(dir, s.fileName)
+		if _, err := os.Stat(filePath); err != nil {
+			continue
+		}
+		db, err := s.open(filePath, s.opt)
+		if err != nil {
+			return fmt.Errorf("can't open the snapshot of [%s]: %v", dir, err)
+		}
+		err = fn(db, dir)
+		db.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sameDir) Exist() bool {
+	return s.central.Exist()
+}
+
+func (s *sameDir) Add(filePath, bucketName string, value []byte) error {
+	return s.route(filePath).Add(filePath, bucketName, value)
+}
+
+func (s *sameDir) Get(filePath, bucketName string) (string, error) {
+	return s.route(filePath).Get(filePath, bucketName)
+}
+
+func (s *sameDir) Remove(filePath, bucketName string) error {
+	return s.route(filePath).Remove(filePath, bucketName)
+}
+
+func (s *sameDir) GetAll(bucketName string) (map[string]string, error) {
+	result := make(map[string]string)
+	found := false
+	for _, db := range s.all() {
+		values, err := db.GetAll(bucketName)
+		if IsBucketNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for k, v := range values {
+			result[k] = v
+		}
+	}
+	if !found {
+		return nil, BucketNotFoundError{Func: "storage.GetAll()", Bucket: bucketName}
+	}
+	return result, nil
+}
+
+func (s *sameDir) AddAll(bucketName string, values map[string][]byte) error {
+	routed := make(map[Storager]map[string][]byte)
+	for k, v := range values {
+		db := s.route(k)
+		if routed[db] == nil {
+			routed[db] = make(map[string][]byte)
+		}
+		routed[db][k] = v
+	}
+	for db, values := range routed {
+		if err := db.AddAll(bucketName, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sameDir) GetMany(bucketName string, filePaths []string) (map[string]string, error) {
+	routed := make(map[Storager][]string)
+	for _, k := range filePaths {
+		db := s.route(k)
+		routed[db] = append(routed[db], k)
+	}
+	result := make(map[string]string, len(filePaths))
+	for db, keys := range routed {
+		values, err := db.GetMany(bucketName, keys)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range values {
+			result[k] = v
+		}
+	}
+	return result, nil
+}
+
+func (s *sameDir) ForEachPrefix(bucket, prefix string, fn func(key, value string) error) error {
+	var dbs []Storager
+	for _, db := range s.all() {
+		if d, ok := db.(*dirStorage); ok && !watchdir.Contains(d.path, prefix) && !strings.HasPrefix(d.path, prefix) {
+			continue
+		}
+		dbs = append(dbs, db)
+	}
+	if len(dbs) == 1 {
+		return dbs[0].ForEachPrefix(bucket, prefix, fn)
+	}
+
+	// the entries of all databases are merged to keep the key order
+	values := make(map[string]string)
+	for _, db := range dbs {
+		err := db.ForEachPrefix(bucket, prefix, func(key, value string) error {
+			values[key] = value
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn(k, values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Buckets returns the buckets of the existing databases, it doesn't open or move anything
+func (s *sameDir) Buckets() ([]string, error) {
+	seen := make(map[string]bool)
+	var result []string
+	err := s.existing(func(db Storager, dir string) error {
+		buckets, err := db.Buckets()
+		if err != nil {
+			return err
+		}
+		for _, b := range buckets {
+			if !seen[b] {
+				seen[b] = true
+				result = append(result, b)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Update is atomic for every database file but not across them: if the transaction has keys of more
+// than one directory some of the databases can be written when another one fails. The callers, the
+// migrations and the reindex of a storage, write the same values when they run again.
+func (s *sameDir) Update(fn func(Tx) error) error {
+	tx := &sameDirTx{s: s, writes: make(map[Storager][]txWrite)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for db, writes := range tx.writes {
+		err := db.Update(func(dbTx Tx) error {
+			for _, w := range writes {
+				var err error
+				if w.delete {
+					err = dbTx.Delete(w.key, w.bucket)
+				} else {
+					err = dbTx.Put(w.key, w.bucket, w.value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Version returns the lowest schema version of the existing databases, it doesn't open or move anything
+func (s *sameDir) Version() (int, error) {
+	version := -1
+	err := s.existing(func(db Storager, dir string) error {
+		v, err := db.Version()
+		if err != nil {
+			return err
+		}
+		if version < 0 || v < version {
+			version = v
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func (s *sameDir) SetVersion(version int) error {
+	for _, db := range s.all() {
+		if err := db.SetVersion(version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Backup copies the existing databases, records which are still in the central database are not moved
+// before the copy
+func (s *sameDir) Backup(suffix string) ([]string, error) {
+	var result []string
+	err := s.existing(func(db Storager, dir string) error {
+		if dir == "" {
+			files, err := db.Backup(suffix)
+			result = append(result, files...)
+			return err
+		}
+		c, ok := db.(copier)
+		if !ok {
+			if d, isDir := db.(*dirStorage); isDir {
+				c, ok = d.Storager.(copier)
+			}
+		}
+		if !ok {
+			return nil
+		}
+		path := filepath.Join(s.backupDir, backupName(dir, s.fileName)+suffix)
+		if err := c.CopyTo(path); err != nil {
+			return err
+		}
+		result = append(result, path)
+		return nil
+	})
+	return result, err
+}
+
+// backupName returns the file name of the backup of a directory database, the hash of the path keeps
+// directories with the same name apart
+func backupName(dir, fileName string) string {
+	sum := sha1.Sum([]byte(dir))
+	return fmt.Sprintf("%s-%x%s", filepath.Base(dir), sum[:4], fileName)
+}
+
+func (s *sameDir) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	err := s.central.Close()
+	for _, d := range s.dirs {
+		unregister(d.path)
+		if cerr := d.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	s.dirs = nil
+	return err
+}
+
+type txWrite struct {
+	key, bucket string
+	value       []byte
+	delete      bool
+}
+
+// sameDirTx collects the writes of a transaction for every database
+type sameDirTx struct {
+	s      *sameDir
+	writes map[Storager][]txWrite
+}
+
+func (tx *sameDirTx) Get(key, bucket string) (string, error) {
+	db := tx.s.route(key)
+	writes := tx.writes[db]
+	for i := len(writes) - 1; i >= 0; i-- {
+		if w := writes[i]; w.key == key && w.bucket == bucket {
+			return string(w.value), nil
+		}
+	}
+	return db.Get(key, bucket)
+}
+
+func (tx *sameDirTx) Put(key, bucket string, value []byte) error {
+	db := tx.s.route(key)
+	tx.writes[db] = append(tx.writes[db], txWrite{key: key, bucket: bucket, value: value})
+	return nil
+}
+
+func (tx *sameDirTx) Delete(key, bucket string) error {
+	db := tx.s.route(key)
+	tx.writes[db] = append(tx.writes[db], txWrite{key: key, bucket: bucket, delete: true})
+	return nil
+}
@@ -0,0 +1,183 @@
+package storage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/glower/bakku-app/pkg/storage"
+	"github.com/glower/bakku-app/pkg/storage/boltdb"
+	"github.com/glower/bakku-app/pkg/storage/memory"
+)
+
+func openBolt(path string, opt storage.Options) (storage.Storager, error) {
+	return boltdb.Open(path, opt)
+}
+
+func TestSameDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "bakku-samedir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	docs := filepath.Join(root, "docs")
+	pics := filepath.Join(root, "pics")
+	os.Mkdir(docs, 0700)
+	os.Mkdir(pics, 0700)
+
+	central := memory.New()
+	// written before sameDir was used
+	central.Add(filepath.Join(docs, "old.txt"), "storage.local", []byte("old"))
+	backupDir := filepath.Join(root, "config")
+	s := storage.NewSameDir(central, openBolt, backupDir, ".snapshot", docs, pics)
+	defer s.Close()
+
+	s.Add(filepath.Join(pics, "a.jpg"), "storage.local", []byte("a"))
+	s.Add(filepath.Join(root, "other.txt"), "storage.local", []byte("other"))
+	err = s.Update(func(tx storage.Tx) error {
+		tx.Put(filepath.Join(docs, "new.txt"), "storage.local", []byte("new"))
+		return tx.Put(filepath.Join(pics, "b.jpg"), "storage.local", []byte("b"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups, err := s.Backup(".v0.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backupDirs []string
+	for _, f := range backups {
+		backupDirs = append(backupDirs, filepath.Dir(f))
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{
+			name: "Scenario 1: records of a watched directory are moved from the central database",
+			got:  get(central, filepath.Join(docs, "old.txt")),
+			want: "",
+		},
+		{
+			name: "Scenario 2: the moved records are read from the directory",
+			got:  get(s, filepath.Join(docs, "old.txt")),
+			want: "old",
+		},
+		{
+			name: "Scenario 3: every watched directory has a database file",
+			got:  []bool{exists(filepath.Join(docs, ".snapshot")), exists(filepath.Join(pics, ".snapshot"))},
+			want: []bool{true, true},
+		},
+		{
+			name: "Scenario 4: other paths are kept in the central database",
+			got:  get(central, filepath.Join(root, "other.txt")),
+			want: "other",
+		},
+		{
+			name: "Scenario 5: prefix iteration merges all databases in key order",
+			got:  keys(s, root),
+			want: []string{filepath.Join(docs, "new.txt"), filepath.Join(docs, "old.txt"), filepath.Join(root, "other.txt"), filepath.Join(pics, "a.jpg"), filepath.Join(pics, "b.jpg")},
+		},
+		{
+			name: "Scenario 6: prefix iteration of one directory",
+			got:  keys(s, pics),
+			want: []string{filepath.Join(pics, "a.jpg"), filepath.Join(pics, "b.jpg")},
+		},
+		{
+			name: "Scenario 7: backups are not written into the watched directories",
+			got:  backupDirs,
+			want: []string{backupDir, backupDir},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameDir_BeforeMove(t *testing.T) {
+	root, err := ioutil.TempDir("", "bakku-samedir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	docs := filepath.Join(root, "docs")
+	os.Mkdir(docs, 0700)
+
+	central := memory.New()
+	central.Add(filepath.Join(docs, "old.txt"), "storage.local", []byte("old"))
+	s := storage.NewSameDir(central, openBolt, filepath.Join(root, "config"), ".snapshot", docs)
+	defer s.Close()
+
+	version, err := s.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buckets, err := s.Buckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Backup(".v0.bak"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{
+			name: "Scenario 1: the version is read from the central database",
+			got:  version,
+			want: 0,
+		},
+		{
+			name: "Scenario 2: the buckets are read from the central database",
+			got:  buckets,
+			want: []string{"storage.local"},
+		},
+		{
+			name: "Scenario 3: version, buckets and backup don't create a directory database",
+			got:  exists(filepath.Join(docs, ".snapshot")),
+			want: false,
+		},
+		{
+			name: "Scenario 4: version, buckets and backup don't move records",
+			got:  get(central, filepath.Join(docs, "old.txt")),
+			want: "old",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func get(s storage.Storager, key string) string {
+	value, _ := s.Get(key, "storage.local")
+	return value
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func keys(s storage.Storager, prefix string) []string {
+	var result []string
+	s.ForEachPrefix("storage.local", prefix, func(key, _ string) error {
+		result = append(result, key)
+		return nil
+	})
+	return result
+}
@@ -1,7 +1,12 @@
 package storage
 
 import (
-	"github.com/glower/bakku-app/pkg/storage/boltdb"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	configsnapshot "github.com/glower/bakku-app/pkg/config/snapshot"
 )
 
 // Storager is an interface for a permanent storage for a files meta data
@@ -11,11 +16,114 @@ type Storager interface {
 	Get(string, string) (string, error)
 	GetAll(string) (map[string]string, error)
 	Remove(string, string) error
+
+	// AddAll writes many entries of one bucket at once
+	AddAll(string, map[string][]byte) error
+	// GetMany reads many entries of one bucket at once, missing keys are not in the result
+	GetMany(string, []string) (map[string]string, error)
+	// ForEachPrefix calls fn in key order for all entries of a bucket whose key starts with the prefix,
+	// fn must not write to the storage
+	ForEachPrefix(bucket, prefix string, fn func(key, value string) error) error
+	// Buckets returns the names of all buckets without the metadata bucket
+	Buckets() ([]string, error)
+	// Update runs fn in a transaction, nothing is written if fn returns an error
+	Update(fn func(Tx) error) error
+	// Version returns the schema version of the stored data, 0 for a new database
+	Version() (int, error)
+	SetVersion(int) error
+	// Backup copies every database file to a file with the same name and the suffix, copies of databases
+	// inside watched directories are written next to the central database. It returns the created files.
+	Backup(suffix string) ([]string, error)
+	Close() error
+}
+
+// Tx is a read-write transaction
+type Tx interface {
+	Get(key, bucket string) (string, error)
+	Put(key, bucket string, value []byte) error
+	Delete(key, bucket string) error
+}
+
+// BucketNotFoundError is returned when a bucket is read which was never written
+type BucketNotFoundError struct {
+	Func   string
+	Bucket string
+}
+
+func (e BucketNotFoundError) Error() string {
+	return fmt.Sprintf("%s: bucket [%s] not found", e.Func, e.Bucket)
+}
+
+// IsBucketNotFound checks if the error is returned because the bucket doesn't exist
+func IsBucketNotFound(err error) bool {
+	_, ok := err.(BucketNotFoundError)
+	return ok
+}
+
+// copier is a database which can write a copy of its file to any path
+type copier interface {
+	CopyTo(path string) error
+}
+
+// Options are passed to a backend when a database is opened
+type Options struct {
+	// MetaBucket is the bucket with the schema version, it is not used for file records
+	MetaBucket string
 }
 
-// New returns new snapshot storage implementation
-func New(path string) Storager {
-	return &boltdb.BoltDB{
-		DBFilePath: path,
+// OpenFunc opens or creates a database of a backend
+type OpenFunc func(path string, opt Options) (Storager, error)
+
+var (
+	backendsM sync.RWMutex
+	backends  = make(map[string]OpenFunc)
+)
+
+// RegisterBackend makes a database implementation available under a name, see snapshot.default in the config
+func RegisterBackend(name string, open OpenFunc) {
+	backendsM.Lock()
+	defer backendsM.Unlock()
+	backends[name] = open
+}
+
+// Backends returns the names of all registered database implementations
+func Backends() []string {
+	backendsM.RLock()
+	defer backendsM.RUnlock()
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func backend(name string) (OpenFunc, error) {
+	backendsM.RLock()
+	open, ok := backends[name]
+	backendsM.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown snapshot storage [%s], available are %v", name, Backends())
+	}
+	return open, nil
+}
+
+// New opens the snapshot storage configured in the snapshot section of the config, path is the central
+// database. With sameDir the records of a watched directory are kept in a database inside this directory.
+// The storage has to be closed when the service stops.
+func New(path string) (Storager, error) {
+	conf := configsnapshot.Conf()
+	open, err := backend(configsnapshot.DefaultStorage())
+	if err != nil {
+		return nil, err
+	}
+	opt := Options{MetaBucket: conf.BucketName}
+	central, err := open(path, opt)
+	if err != nil {
+		return nil, err
+	}
+	if !conf.SameDir {
+		return central, nil
 	}
+	return newSameDir(central, open, opt, conf.FileName, filepath.Dir(path)), nil
 }
@@ -40,6 +40,33 @@ func (f *FakeStorage) GetAll(filePath string) (map[string]string, error) {
 func (f *FakeStorage) Remove(filePath string, bucketName string) error {
 	return nil
 }
+func (f *FakeStorage) AddAll(bucketName string, values map[string][]byte) error {
+	return nil
+}
+func (f *FakeStorage) GetMany(bucketName string, filePaths []string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+func (f *FakeStorage) ForEachPrefix(bucketName, prefix string, fn func(key, value string) error) error {
+	return nil
+}
+func (f *FakeStorage) Buckets() ([]string, error) {
+	return nil, nil
+}
+func (f *FakeStorage) Update(fn func(Tx) error) error {
+	return nil
+}
+func (f *FakeStorage) Version() (int, error) {
+	return 0, nil
+}
+func (f *FakeStorage) SetVersion(int) error {
+	return nil
+}
+func (f *FakeStorage) Backup(string) ([]string, error) {
+	return nil, nil
+}
+func (f *FakeStorage) Close() error {
+	return nil
+}
 
 func TestRegisterStorage(t *testing.T) {
 
@@ -1,17 +1,29 @@
 package types
 
 import (
+	"time"
+
 	"github.com/glower/bakku-app/pkg/message"
+	"github.com/glower/bakku-app/pkg/ratelimit"
 	"github.com/glower/bakku-app/pkg/storage"
 	"github.com/glower/file-watcher/watcher"
 )
 
-// BackupComplete represents
+// BackupComplete represents the end of the upload of a file to a storage
 type BackupComplete struct {
-	Success            bool
-	StorageName        string
-	FilePath           string
-	WatchDirectoryName string
+	Success            bool   `json:"success"`
+	StorageName        string `json:"storage"`
+	FilePath           string `json:"path"`
+	WatchDirectoryName string `json:"dir,omitempty"`
+	// Error is the reason of a failed upload
+	Error string `json:"error,omitempty"`
+	// Finished is set with the last result of the storages the buffer sent the file to, a storage which holds
+	// the file has no result. It's sent without storage if the last storage holds the file.
+	Finished bool `json:"finished,omitempty"`
+	// Done is set when all storages the file was sent to have it
+	Done bool `json:"done,omitempty"`
+	// Held is set when the storages without the file hold it, they upload it when they are released
+	Held bool `json:"held,omitempty"`
 }
 
 // BackupProgress represents a moment of progress.
@@ -24,10 +36,18 @@ type BackupProgress struct {
 }
 
 type BackupStatus struct {
-	TotalFiles      int    `json:"total"`
-	FilesInProgress int    `json:"in_progress"`
-	FilesDone       int    `json:"done"`
-	Status          string `json:"status"`
+	TotalFiles      int                `json:"total"`
+	FilesInProgress int                `json:"in_progress"`
+	FilesDone       int                `json:"done"`
+	Status          string             `json:"status"`
+	Bandwidth       []ratelimit.Status `json:"bandwidth,omitempty"`
+	// BytesTotal are the bytes of the queue, a file is counted once per storage
+	BytesTotal int64 `json:"bytes_total"`
+	BytesDone  int64 `json:"bytes_done"`
+	// Throughput is in bytes per second
+	Throughput int64 `json:"throughput"`
+	// ETA is the estimated number of seconds until the queue is uploaded, 0 if nothing is uploaded right now
+	ETA int64 `json:"eta"`
 }
 
 type GlobalResources struct {
@@ -36,3 +56,82 @@ type GlobalResources struct {
 	FileWatcher      *watcher.Watch
 	Storage          storage.Storager
 }
+
+// Possible states of a queued file
+const (
+	QueueUploading = "uploading"
+	QueueHeld      = "held"
+	// QueueWaiting files wait for a free upload slot of their storage
+	QueueWaiting = "waiting"
+)
+
+// QueuedFile is a file which is uploaded right now or waits for its storage
+type QueuedFile struct {
+	Path    string    `json:"path"`
+	Storage string    `json:"storage"`
+	State   string    `json:"state"`
+	Started time.Time `json:"started,omitempty"`
+}
+
+// UploadStats are the uploads of a storage or a watched directory
+type UploadStats struct {
+	// Pending files wait for an upload slot or are held
+	Pending    int `json:"pending"`
+	InProgress int `json:"in_progress"`
+	// Failed files were not uploaded at the last attempt
+	Failed int `json:"failed"`
+	// BytesPending are the bytes of the pending files and the rest of the files in progress
+	BytesPending int64 `json:"bytes_pending"`
+	// Throughput is in bytes per second
+	Throughput int64 `json:"throughput"`
+	// ETA is the estimated number of seconds until all pending bytes are uploaded, 0 if nothing is uploaded right now
+	ETA           int64      `json:"eta"`
+	LastBackup    *time.Time `json:"last_backup,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// StorageStatus is the state of one backup storage
+type StorageStatus struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+	Paused bool   `json:"paused"`
+	// State is set by the user: active, paused or draining
+	State string `json:"state"`
+	Held  int    `json:"held"`
+	UploadStats
+	// Throttled is why the storage doesn't upload right now: paused, schedule, quiet hours or errors
+	Throttled string `json:"throttled,omitempty"`
+	// ThrottlePause is the pause in seconds after upload errors
+	ThrottlePause int64 `json:"throttle_pause,omitempty"`
+	// Limit is the bandwidth limit in bytes per second, 0 is unlimited
+	Limit int64 `json:"limit"`
+}
+
+// Control states of storages and files
+const (
+	StateActive      = "active"
+	StatePaused      = "paused"
+	StateDraining    = "draining"
+	StateCanceled    = "canceled"
+	StatePrioritized = "prioritized"
+)
+
+// ControlState is a change of the state of a storage or, if Path is set, of one file in its queue
+type ControlState struct {
+	Storage string `json:"storage"`
+	Path    string `json:"path,omitempty"`
+	State   string `json:"state"`
+}
+
+// DirStatus is the state of the backups of one watched directory
+type DirStatus struct {
+	Path     string `json:"path"`
+	Active   bool   `json:"active"`
+	ScanOnly bool   `json:"scan_only"`
+	// Missing is set if the directory doesn't exist, e.g. an unmounted network share, it's not watched
+	Missing bool `json:"missing,omitempty"`
+	// Scan is the state of the last scan, it's empty if the directory was not scanned yet
+	Scan string `json:"scan,omitempty"`
+	UploadStats
+}
@@ -0,0 +1,26 @@
+'use strict';
+
+const fs = require('fs');
+const os = require('os');
+const path = require('path');
+
+// the service stores a token for local clients in its config dir
+const configDir = () => process.env.BAKKUAPPCONF || path.join(os.homedir(), '.bakkuapp');
+
+const token = () => {
+    try {
+        return fs.readFileSync(path.join(configDir(), 'client.token'), 'utf8').trim();
+    } catch (err) {
+        return '';
+    }
+};
+
+const baseURL = process.env.BAKKU_ADDR || 'http://localhost:8080';
+
+module.exports = {
+    baseURL,
+    token,
+    // EventSource can't set headers, the token is passed as query parameter
+    eventsURL: (stream) => `${baseURL}/events?stream=${stream}&access_token=${encodeURIComponent(token())}`,
+    authHeader: () => `Bearer ${token()}`,
+};
@@ -1,5 +1,7 @@
 'use strict';
 
+const api = require('../helpers/api');
+
 const loading = `
     <div class="preloader-wrapper xsmall active">
         <div class="spinner-layer spinner-green-only">
@@ -17,7 +19,7 @@ const loading = `
 const createBackupStatusListener = () => {
     console.log("createBackupStatusListener()");
 
-    var evtSource = new EventSource(`http://localhost:8080/events?stream=status`);
+    var evtSource = new EventSource(api.eventsURL('status'));
     evtSource.onerror = (err) => {
         // console.error("!!! createBackupStatusListener():", err)
     }
@@ -38,7 +40,7 @@ const createBackupStatusListener = () => {
 const createProgressListener = () => {
     console.log("createProgressListener()");
 
-    var evtSource = new EventSource(`http://localhost:8080/events?stream=files`);
+    var evtSource = new EventSource(api.eventsURL('files'));
     evtSource.onerror = (err) => {
         // console.error("!!! createProgressListener():", err)
     }
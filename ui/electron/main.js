@@ -3,6 +3,7 @@ const path = require('path');
 const EventSource = require("eventsource");
 const windowFactory = require('./helpers/window-factory');
 const { APP_NAME, MAIN_WINDOW_WIDTH, MAIN_WINDOW_HEIGHT } = require('./helpers/constants');
+const api = require('./helpers/api');
 const WindowsToaster = require('node-notifier').WindowsToaster;
 
 let tray = null
@@ -106,7 +107,7 @@ function manageTrayRightClick(tray) {
 const createNotificationListener = (name) => {
    console.log("createNotificationListener():", name)
    // http://server/events?stream=messages
-   var evtSource = new EventSource(`http://localhost:8080/events?stream=${name}`);
+   var evtSource = new EventSource(api.eventsURL(name));
    evtSource.onerror = (err) => {
       console.error("createNotificationListener():", err)
    }
@@ -132,7 +133,8 @@ if (app.dock) app.dock.hide();
 // Attach listener in the main process with the given ID
 ipcMain.on('get-config-action', (event, arg) => {
    // const { net } = require('electron');
-   const req = net.request('http://localhost:8080/api/config');
+   const req = net.request(`${api.baseURL}/api/config`);
+   req.setHeader('Authorization', api.authHeader());
    req.on('response', (response) => {
       console.log(`STATUS: ${response.statusCode}`)
       // console.log(`HEADERS: ${JSON.stringify(response.headers)}`)